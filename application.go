@@ -14,7 +14,9 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"reflect"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,6 +27,8 @@ import (
 var (
 	ErrRendererNotRegister = errors.New("renderer not registered")
 	ErrDecoderNotRegister  = errors.New("decoder not registered")
+	ErrServerRunning       = errors.New("server is already running")
+	ErrServerNotRunning    = errors.New("server is not running")
 )
 
 var requestMethods = []string{
@@ -72,7 +76,11 @@ type Renderer interface {
 // Application is a http.Handler which can be used to dispatch requests to different
 // handler functions via configurable routes
 type Application struct {
+	// Server is the HTTP server, it is replaced by a copy if the application runs
+	// again after it has been shut down, since a server cannot be reused.
 	Server *http.Server
+	// the server that has been shut down.
+	closedServer *http.Server
 
 	// Graceful shutdown timeout.
 	ShutdownTimeout time.Duration
@@ -80,14 +88,30 @@ type Application struct {
 	// Graceful shutdown signals.
 	ShutdownSignals []os.Signal
 
-	certFile string
-	keyFile  string
+	// HTTP/2 settings, including h2c, see HTTP2Config.
+	HTTP2 *HTTP2Config
+	// the server that HTTP/2 has been configured on.
+	http2Server *http.Server
+
+	// Lifecycle hooks and the running server, see ServeContext.
+	onStart     []Hook
+	onShutdown  []Hook
+	onStop      []Hook
+	lifecycleMu sync.Mutex
+	lifecycle   *lifecycle
 
 	trees map[string]*node
 
 	// Named routes.
 	routes map[string]*Route
 
+	// Route groups, which are used for handling unmatched requests.
+	groups []*RouteGroup
+
+	// The parent application and the prefix that the application is mounted on, see Mount.
+	mountParent *Application
+	mountPrefix string
+
 	maxParams uint16
 
 	// Enables automatic redirection if the current route can't be matched but a
@@ -143,6 +167,17 @@ type Application struct {
 	// If enabled, use the request.URL.RawPath instead of request.URL.Path.
 	UseRawPath bool
 
+	// Trusted proxies, see SetTrustedProxies.
+	trustedProxies []*net.IPNet
+
+	// The family of forwarding headers that the trusted proxies set, defaults to
+	// X-Forwarded-*, see ForwardedHeaders.
+	ForwardedHeaders ForwardedHeaders
+
+	// Keys of signed and encrypted cookies, see SetCookieHashKeys and SetCookieBlockKeys.
+	cookieHashKeys  [][]byte
+	cookieBlockKeys [][]byte
+
 	middlewares []MiddlewareFunc
 	handle      Handle
 
@@ -152,6 +187,12 @@ type Application struct {
 	// Request input decoder.
 	Decoder Decoder
 
+	// Role based access control, see RequireRole and RequirePermission.
+	RBAC *RBAC
+
+	// Fingerprinted assets, see Context.AssetURL.
+	Assets *Assets
+
 	Logger log.Logger
 }
 
@@ -182,7 +223,12 @@ func New() *Application {
 // RouteURL creates an url with the given route name and arguments.
 func (app *Application) RouteURL(name string, args ...string) (*url.URL, error) {
 	if route, ok := app.routes[name]; ok {
-		return route.URL(args...)
+		u, err := route.URL(args...)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = app.mountPath() + u.Path
+		return u, nil
 	}
 
 	return nil, fmt.Errorf("route %q does not exist", name)
@@ -207,27 +253,27 @@ func (app *Application) Get(path string, handle Handle, opts ...RouteOption) {
 
 // Head implements Router.Head.
 func (app *Application) Head(path string, handle Handle, opts ...RouteOption) {
-	app.Handle(http.MethodHead, path, handle)
+	app.Handle(http.MethodHead, path, handle, opts...)
 }
 
 // Options implements Router.Options.
 func (app *Application) Options(path string, handle Handle, opts ...RouteOption) {
-	app.Handle(http.MethodOptions, path, handle)
+	app.Handle(http.MethodOptions, path, handle, opts...)
 }
 
 // Post implements Router.Post.
 func (app *Application) Post(path string, handle Handle, opts ...RouteOption) {
-	app.Handle(http.MethodPost, path, handle)
+	app.Handle(http.MethodPost, path, handle, opts...)
 }
 
 // Put implements Router.Put.
 func (app *Application) Put(path string, handle Handle, opts ...RouteOption) {
-	app.Handle(http.MethodPut, path, handle)
+	app.Handle(http.MethodPut, path, handle, opts...)
 }
 
 // Patch implements Router.Patch.
 func (app *Application) Patch(path string, handle Handle, opts ...RouteOption) {
-	app.Handle(http.MethodPatch, path, handle)
+	app.Handle(http.MethodPatch, path, handle, opts...)
 }
 
 // Delete implements Router.Delete.
@@ -301,11 +347,17 @@ func (app *Application) HandlerFunc(method, path string, f http.HandlerFunc, opt
 }
 
 // ServeFiles serves files from the given file system root.
+// The fingerprinted files are cached for one year as immutable if the root is
+// an Assets instance.
 func (app *Application) ServeFiles(path string, root http.FileSystem, opts ...RouteOption) {
 	fileServer := http.FileServer(root)
+	assets, _ := root.(*Assets)
 
 	app.Get(strings.TrimSuffix(path, "/")+"/*filepath", func(c *Context) error {
 		c.Request.URL.Path = c.Params.String("filepath")
+		if assets != nil && assets.IsFingerprinted(c.Request.URL.Path) {
+			c.Response.Header().Set(headerCacheControl, cacheControlImmutable)
+		}
 		fileServer.ServeHTTP(c.Response, c.Request)
 		return nil
 	}, opts...)
@@ -389,6 +441,8 @@ func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		app.Logger.Errorf("clevergo: %s", err.Error())
 		switch e := err.(type) {
+		case StreamError:
+			// the response has been partially sent, nothing can be done.
 		case Error:
 			c.Error(e.Status(), err.Error())
 		default:
@@ -440,62 +494,130 @@ func (app *Application) handleRequest(c *Context) (err error) {
 		}
 	}
 
+	// Unmatched requests run through the middlewares of the route group whose fallback
+	// handler is used, or the longest matching route group if none.
+	allow := ""
 	if c.Request.Method == http.MethodOptions && app.HandleOPTIONS {
-		// Handle OPTIONS requests
-		if allow := app.allowed(path, http.MethodOptions); allow != "" {
-			c.Response.Header().Set("Allow", allow)
+		allow = app.allowed(path, http.MethodOptions)
+	} else if app.HandleMethodNotAllowed {
+		allow = app.allowed(path, c.Request.Method)
+	}
+	fallback := func(g *RouteGroup) Handle { return g.notFound }
+	if allow != "" && c.Request.Method != http.MethodOptions {
+		fallback = func(g *RouteGroup) Handle { return g.methodNotAllowed }
+	}
+	if group := app.matchGroup(path, fallback); group != nil {
+		return Chain(func(c *Context) error {
+			return app.handleUnmatched(c, allow, fallback(group))
+		}, group.middlewares...)(c)
+	}
+	return app.handleUnmatched(c, allow, nil)
+}
+
+// handleUnmatched handles OPTIONS, 405 and 404 responses, the fallback handler of
+// route group takes precedence over the application's.
+func (app *Application) handleUnmatched(c *Context, allow string, fallback Handle) error {
+	if allow != "" {
+		c.Response.Header().Set("Allow", allow)
+		if c.Request.Method == http.MethodOptions {
 			if app.GlobalOPTIONS != nil {
 				app.GlobalOPTIONS.ServeHTTP(c.Response, c.Request)
 			}
-			return
+			return nil
 		}
-	} else if app.HandleMethodNotAllowed { // Handle 405
-		if allow := app.allowed(path, c.Request.Method); allow != "" {
-			c.Response.Header().Set("Allow", allow)
-			if app.MethodNotAllowed != nil {
-				app.MethodNotAllowed.ServeHTTP(c.Response, c.Request)
-				return
-			}
-			return ErrMethodNotAllowed
+
+		// Handle 405
+		if fallback != nil {
+			return fallback(c)
+		}
+		if app.MethodNotAllowed != nil {
+			app.MethodNotAllowed.ServeHTTP(c.Response, c.Request)
+			return nil
 		}
+		return ErrMethodNotAllowed
 	}
 
 	// Handle 404
+	if fallback != nil {
+		return fallback(c)
+	}
 	if app.NotFound != nil {
 		app.NotFound.ServeHTTP(c.Response, c.Request)
-		return
+		return nil
 	}
 
 	return ErrNotFound
 }
 
-func (app *Application) initServer() {
+// matchGroup returns the route group that has the longest path matching the given
+// path among the groups that have the fallback handler, or the longest matching
+// route group if none of them has the handler, nil if not found.
+func (app *Application) matchGroup(path string, fallback func(*RouteGroup) Handle) *RouteGroup {
+	var group, handled *RouteGroup
+	for _, g := range app.groups {
+		if !g.match(path) {
+			continue
+		}
+		if group == nil || len(g.path) > len(group.path) {
+			group = g
+		}
+		if fallback(g) != nil && (handled == nil || len(g.path) > len(handled.path)) {
+			handled = g
+		}
+	}
+	if handled != nil {
+		return handled
+	}
+	return group
+}
+
+func (app *Application) initServer(tls bool) error {
 	if app.Server == nil {
 		app.Server = &http.Server{}
+	} else if app.Server == app.closedServer {
+		app.Server = copyServer(app.Server)
 	}
 	app.Server.Handler = app
+	return app.configureHTTP2(tls)
+}
+
+// copyServer returns a new server that has the same exported fields as the given
+// one, the unexported states, such as listeners and shutdown flag, are excluded.
+func copyServer(s *http.Server) *http.Server {
+	srv := &http.Server{}
+	src, dst := reflect.ValueOf(s).Elem(), reflect.ValueOf(srv).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if src.Type().Field(i).PkgPath == "" {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	return srv
 }
 
 // Run starts a HTTP server with the given address.
 func (app *Application) Run(address string) error {
+	return app.RunContext(context.Background(), address)
+}
+
+// RunContext starts a HTTP server with the given address, the server is shut down
+// gracefully once the context is done, see ServeContext.
+func (app *Application) RunContext(ctx context.Context, address string) error {
 	ln, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
-	return app.Serve(ln)
+	return app.ServeContext(ctx, ln)
 }
 
 // RunTLS starts a HTTPS server with the given address, certfile and keyfile.
 func (app *Application) RunTLS(address, certFile, keyFile string) error {
-	app.certFile = certFile
-	app.keyFile = keyFile
 	ln, err := net.Listen("tcp", address)
 	if err != nil {
 		return err
 	}
 	defer ln.Close()
 
-	return app.Serve(ln)
+	return app.serve(context.Background(), certFile, keyFile, []net.Listener{ln})
 }
 
 // RunUnix starts a HTTP Server which listening and serving HTTP requests
@@ -508,28 +630,205 @@ func (app *Application) RunUnix(address string) error {
 	return app.Serve(ln)
 }
 
-// Serve accepts incoming connections on the Listener ln.
-func (app *Application) Serve(ln net.Listener) (err error) {
-	app.initServer()
+// Serve accepts incoming connections on the Listener ln, see ServeContext.
+func (app *Application) Serve(ln net.Listener) error {
+	return app.ServeContext(context.Background(), ln)
+}
+
+// Listen serves the application on all of the given listeners, such as a TCP
+// listener, a TLS listener created by tls.NewListener and a unix socket listener,
+// see ServeContext.
+func (app *Application) Listen(listeners ...net.Listener) error {
+	return app.ServeContext(context.Background(), listeners...)
+}
+
+// Hook is a function that is called during the lifecycle of server, see OnStart,
+// OnShutdown and OnStop.
+type Hook func(ctx context.Context) error
+
+// OnStart registers hooks that are called in order before serving, the server
+// will not be started if any of them failed, in which case the remaining start
+// hooks and all of the OnStop hooks are skipped, the resources acquired by the
+// started hooks should be released by the caller once the error is returned. The
+// context is the one passed to ServeContext.
+func (app *Application) OnStart(hooks ...Hook) {
+	app.onStart = append(app.onStart, hooks...)
+}
+
+// OnShutdown registers hooks that are called in order once the server begins to
+// shut down, in-flight requests are still being served, such as deregistering
+// the service from load balancers. The context is done after ShutdownTimeout.
+func (app *Application) OnShutdown(hooks ...Hook) {
+	app.onShutdown = append(app.onShutdown, hooks...)
+}
+
+// OnStop registers hooks that are called in order after the server stopped and
+// all requests completed, such as closing databases. The context is done after
+// ShutdownTimeout.
+func (app *Application) OnStop(hooks ...Hook) {
+	app.onStop = append(app.onStop, hooks...)
+}
+
+// lifecycle is the state of a running server.
+type lifecycle struct {
+	// shutdown receives the context of Shutdown.
+	shutdown chan context.Context
+	// done is closed once the server stopped.
+	done chan struct{}
+	// errors that occurred during shutdown.
+	err error
+}
+
+// ServeContext serves the application on all of the given listeners, the listeners
+// share the same server and are closed once it is shut down.
+//
+// The server is shut down gracefully within ShutdownTimeout once the context is
+// done, Shutdown is called, one of ShutdownSignals is received, or any of listeners
+// failed. The errors of listeners, shutdown and hooks are combined, see MultiError,
+// http.ErrServerClosed is omitted.
+func (app *Application) ServeContext(ctx context.Context, listeners ...net.Listener) error {
+	return app.serve(ctx, "", "", listeners)
+}
+
+// serve serves HTTPS if the certificate and key files are present.
+func (app *Application) serve(ctx context.Context, certFile, keyFile string, listeners []net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("clevergo: no listeners")
+	}
+	l := &lifecycle{
+		shutdown: make(chan context.Context, 1),
+		done:     make(chan struct{}),
+	}
+	app.lifecycleMu.Lock()
+	if app.lifecycle != nil {
+		app.lifecycleMu.Unlock()
+		return ErrServerRunning
+	}
+	app.lifecycle = l
+	app.lifecycleMu.Unlock()
+	defer func() {
+		app.lifecycleMu.Lock()
+		app.lifecycle = nil
+		app.lifecycleMu.Unlock()
+		close(l.done)
+	}()
+
+	tls := certFile != "" && keyFile != ""
+	if err := app.start(ctx, tls); err != nil {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return err
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, app.ShutdownSignals...)
+	defer signal.Stop(stop)
 
-	go func() {
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
 		app.Logger.Infof("clevergo: listening on %s.\n", ln.Addr().String())
-		if app.certFile != "" && app.keyFile != "" {
-			err = app.Server.ServeTLS(ln, app.certFile, app.keyFile)
-		} else {
-			err = app.Server.Serve(ln)
+		go func(ln net.Listener) {
+			if tls {
+				errs <- app.Server.ServeTLS(ln, certFile, keyFile)
+			} else {
+				errs <- app.Server.Serve(ln)
+			}
+		}(ln)
+	}
+
+	var merr MultiError
+	pending := len(listeners)
+	collect := func(err error) {
+		pending--
+		if err != nil && err != http.ErrServerClosed {
+			app.Logger.Errorf("clevergo: failed to serve: %s.\n", err)
+			merr = append(merr, err)
 		}
-		app.Logger.Errorf("clevergo: failed to start server: %s.\n", err)
-		stop <- os.Interrupt
-	}()
+	}
+	var shutdownCtx context.Context
+	select {
+	case <-stop:
+	case <-ctx.Done():
+	case err := <-errs:
+		collect(err)
+	case shutdownCtx = <-l.shutdown:
+	}
+	if shutdownCtx == nil {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(context.Background(), app.ShutdownTimeout)
+		defer cancel()
+	}
 
-	<-stop
+	serr := app.shutdown(shutdownCtx)
+	for pending > 0 {
+		collect(<-errs)
+	}
+	serr = append(serr, app.stop()...)
+	l.err = serr.err()
+	return append(merr, serr...).err()
+}
+
+func (app *Application) start(ctx context.Context, tls bool) error {
+	if err := app.initServer(tls); err != nil {
+		return err
+	}
+	for _, hook := range app.onStart {
+		if err := hook(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// shutdown calls the shutdown hooks and shuts down the server gracefully, the
+// connections are closed forcibly if the context is done before they are idle.
+func (app *Application) shutdown(ctx context.Context) (errs MultiError) {
+	app.Logger.Infof("clevergo: shutting down server...\n")
+	for _, hook := range app.onShutdown {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := app.Server.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+		app.Server.Close()
+	}
+	app.closedServer = app.Server
+	return
+}
+
+func (app *Application) stop() (errs MultiError) {
 	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
 	defer cancel()
-	app.Logger.Infof("clevergo: shutting down server...\n")
-	err = app.Server.Shutdown(ctx)
+	for _, hook := range app.onStop {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	return
 }
+
+// Shutdown shuts down the running server gracefully, and waits for it to stop or
+// the context to be done, it returns ErrServerNotRunning if the server is not
+// running. The errors that occurred during shutdown are returned, including the
+// errors of OnShutdown and OnStop hooks.
+func (app *Application) Shutdown(ctx context.Context) error {
+	app.lifecycleMu.Lock()
+	l := app.lifecycle
+	app.lifecycleMu.Unlock()
+	if l == nil {
+		return ErrServerNotRunning
+	}
+	select {
+	case l.shutdown <- ctx:
+	default:
+		// the server is being shut down.
+	}
+	select {
+	case <-l.done:
+		return l.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -10,16 +10,18 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	stdlog "log"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
+	"clevergo.tech/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -83,17 +85,17 @@ func TestMain(m *testing.M) {
 	var err error
 	tmpDir, err = ioutil.TempDir("", "clevergo")
 	if err != nil {
-		log.Fatal(err)
+		stdlog.Fatal(err)
 	}
 	defer os.RemoveAll(tmpDir) // clean up
 
 	certFile = filepath.Join(tmpDir, "cert.pem")
 	if err := ioutil.WriteFile(certFile, certFileData, 0666); err != nil {
-		log.Fatal(err)
+		stdlog.Fatal(err)
 	}
 	keyFile = filepath.Join(tmpDir, "key.pem")
 	if err := ioutil.WriteFile(keyFile, keyFileData, 0666); err != nil {
-		log.Fatal(err)
+		stdlog.Fatal(err)
 	}
 
 	os.Exit(m.Run())
@@ -226,6 +228,21 @@ func TestApplicationAny(t *testing.T) {
 	assert.Equal(t, "/ping", url.String())
 }
 
+func TestApplicationRouteOptions(t *testing.T) {
+	app := Pure()
+	app.Get("/", echoHandler(""), RouteName("get"))
+	app.Head("/", echoHandler(""), RouteName("head"))
+	app.Options("/", echoHandler(""), RouteName("options"))
+	app.Post("/", echoHandler(""), RouteName("post"))
+	app.Put("/", echoHandler(""), RouteName("put"))
+	app.Patch("/", echoHandler(""), RouteName("patch"))
+	app.Delete("/", echoHandler(""), RouteName("delete"))
+	for _, name := range []string{"get", "head", "options", "post", "put", "patch", "delete"} {
+		_, err := app.RouteURL(name)
+		assert.Nil(t, err, name)
+	}
+}
+
 func TestApplicationInvalidInput(t *testing.T) {
 	app := Pure()
 
@@ -772,6 +789,22 @@ func TestApplicationServeError(t *testing.T) {
 	}
 }
 
+func TestApplicationServeStreamError(t *testing.T) {
+	var logs strings.Builder
+	app := Pure()
+	app.Logger = log.New(&logs, "", 0)
+	app.Get("/", func(c *Context) error {
+		c.Response.WriteHeader(http.StatusOK)
+		c.WriteString("partial")
+		return StreamError{errors.New("render error")}
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "partial", w.Body.String())
+	assert.Contains(t, logs.String(), "render error")
+}
+
 func TestApplicationUseRawPath(t *testing.T) {
 	app := Pure()
 	app.UseRawPath = true
@@ -929,3 +962,316 @@ func TestApplicationRunUnixError(t *testing.T) {
 		t.Error("expected error, got nil")
 	}
 }
+
+type failingListener struct {
+	net.Listener
+	err error
+}
+
+func (ln *failingListener) Accept() (net.Conn, error) {
+	return nil, ln.err
+}
+
+func TestApplicationListen(t *testing.T) {
+	app := Pure()
+	app.ShutdownSignals = []os.Signal{syscall.SIGUSR1}
+	app.Handle(http.MethodGet, "/", echoHandler("Listen"))
+	release := make(chan struct{})
+	app.Handle(http.MethodGet, "/slow", func(c *Context) error {
+		<-release
+		return c.String(http.StatusOK, "slow")
+	})
+
+	tcp1, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	tcp2, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	sock := filepath.Join(tmpDir, "listen.sock")
+	unix, err := net.Listen("unix", sock)
+	assert.Nil(t, err)
+
+	done := make(chan error)
+	go func() {
+		done <- app.Listen(tcp1, tcp2, unix)
+	}()
+
+	for _, ln := range []net.Listener{tcp1, tcp2} {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "Listen", string(body))
+	}
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", sock)
+			},
+		},
+	}
+	resp, err := client.Get("http://unix")
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "Listen", string(body))
+
+	// in-flight requests are completed during shutdown.
+	slow := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + tcp1.Addr().String() + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		slow <- string(body)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	assert.Equal(t, "slow", <-slow)
+	assert.Nil(t, <-done)
+
+	// all listeners are closed.
+	for _, ln := range []net.Listener{tcp1, tcp2, unix} {
+		_, err := ln.Accept()
+		assert.NotNil(t, err)
+	}
+}
+
+func TestApplicationListenError(t *testing.T) {
+	app := Pure()
+	assert.NotNil(t, app.Listen())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	acceptErr := errors.New("accept error")
+	failing := &failingListener{Listener: ln, err: acceptErr}
+	other, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, acceptErr, app.Listen(other, failing))
+	_, err = other.Accept()
+	assert.NotNil(t, err)
+}
+
+func TestApplicationRunContext(t *testing.T) {
+	app := Pure()
+	app.Handle(http.MethodGet, "/", echoHandler("RunContext"))
+	var events []string
+	record := func(event string, err error) Hook {
+		return func(ctx context.Context) error {
+			events = append(events, event)
+			return err
+		}
+	}
+	app.OnStart(record("start1", nil), record("start2", nil))
+	app.OnShutdown(record("shutdown", nil))
+	app.OnStop(record("stop", nil))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- app.ServeContext(ctx, ln)
+	}()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "RunContext", string(body))
+	assert.Equal(t, ErrServerRunning, app.Listen(ln))
+
+	cancel()
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"start1", "start2", "shutdown", "stop"}, events)
+
+	// the application can be run again.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Nil(t, app.RunContext(ctx, "127.0.0.1:0"))
+	assert.NotNil(t, app.RunContext(ctx, "invalid address"))
+}
+
+func TestApplicationRunAfterShutdown(t *testing.T) {
+	app := Pure()
+	server := &http.Server{ReadTimeout: time.Second}
+	app.Server = server
+	app.Handle(http.MethodGet, "/", echoHandler("again"))
+	for i := 0; i < 2; i++ {
+		started := make(chan struct{})
+		app.onStart = []Hook{func(ctx context.Context) error {
+			close(started)
+			return nil
+		}}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		done := make(chan error)
+		go func() {
+			done <- app.Serve(ln)
+		}()
+		<-started
+		// the server of caller is served at first, and a copy of it after shutdown.
+		assert.Equal(t, i == 0, app.Server == server)
+		assert.Equal(t, time.Second, app.Server.ReadTimeout)
+
+		resp, err := http.Get("http://" + ln.Addr().String())
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "again", string(body))
+
+		assert.Nil(t, app.Shutdown(context.Background()))
+		assert.Nil(t, <-done)
+	}
+}
+
+func TestCopyServer(t *testing.T) {
+	connState := func(net.Conn, http.ConnState) {}
+	s := &http.Server{
+		Addr:           ":8080",
+		ReadTimeout:    time.Second,
+		WriteTimeout:   2 * time.Second,
+		IdleTimeout:    3 * time.Second,
+		MaxHeaderBytes: 1024,
+		TLSConfig:      &tls.Config{ServerName: "example.com"},
+		ConnState:      connState,
+	}
+	s.Close()
+	srv := copyServer(s)
+	assert.False(t, s == srv)
+	assert.Equal(t, s.Addr, srv.Addr)
+	assert.Equal(t, s.ReadTimeout, srv.ReadTimeout)
+	assert.Equal(t, s.WriteTimeout, srv.WriteTimeout)
+	assert.Equal(t, s.IdleTimeout, srv.IdleTimeout)
+	assert.Equal(t, s.MaxHeaderBytes, srv.MaxHeaderBytes)
+	assert.Equal(t, s.TLSConfig, srv.TLSConfig)
+	assert.NotNil(t, srv.ConnState)
+
+	// the copy is not closed.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go srv.Serve(ln)
+	defer srv.Close()
+	resp, err := http.Get("http://" + ln.Addr().String())
+	assert.Nil(t, err)
+	resp.Body.Close()
+}
+
+func TestApplicationShutdown(t *testing.T) {
+	app := Pure()
+	assert.Equal(t, ErrServerNotRunning, app.Shutdown(context.Background()))
+
+	started := make(chan struct{})
+	app.OnStart(func(ctx context.Context) error {
+		close(started)
+		return nil
+	})
+	release := make(chan struct{})
+	app.Handle(http.MethodGet, "/", func(c *Context) error {
+		<-release
+		return c.String(http.StatusOK, "slow")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		done <- app.Serve(ln)
+	}()
+	<-started
+
+	resp := make(chan string)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resp <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		resp <- string(body)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// the in-flight requests are aborted once the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, app.Shutdown(ctx))
+	assert.NotEqual(t, "slow", <-resp)
+	close(release)
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+	assert.Equal(t, ErrServerNotRunning, app.Shutdown(context.Background()))
+}
+
+func TestApplicationLifecycleErrors(t *testing.T) {
+	app := Pure()
+	startErr := errors.New("start error")
+	app.OnStart(func(ctx context.Context) error {
+		return startErr
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, startErr, app.Serve(ln))
+	// the listener is closed.
+	_, err = ln.Accept()
+	assert.NotNil(t, err)
+
+	// the remaining start hooks and the stop hooks are skipped.
+	app = Pure()
+	var events []string
+	record := func(event string, err error) Hook {
+		return func(ctx context.Context) error {
+			events = append(events, event)
+			return err
+		}
+	}
+	app.OnStart(record("start1", nil), record("start2", startErr), record("start3", nil))
+	app.OnStop(record("stop1", nil), record("stop2", nil), record("stop3", nil))
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	assert.Equal(t, startErr, app.Serve(ln))
+	assert.Equal(t, []string{"start1", "start2"}, events)
+
+	app = Pure()
+	shutdownErr := errors.New("shutdown error")
+	stopErr := errors.New("stop error")
+	stopped := false
+	app.OnShutdown(func(ctx context.Context) error {
+		return shutdownErr
+	})
+	app.OnStop(func(ctx context.Context) error {
+		stopped = true
+		assert.Nil(t, ctx.Err())
+		return stopErr
+	})
+	started := make(chan struct{})
+	app.OnStart(func(ctx context.Context) error {
+		close(started)
+		return nil
+	})
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		done <- app.Serve(ln)
+	}()
+	<-started
+	expected := MultiError{shutdownErr, stopErr}
+	assert.Equal(t, expected, app.Shutdown(context.Background()))
+	assert.Equal(t, expected, <-done)
+	assert.True(t, stopped)
+
+	// the errors of listeners are reported.
+	app = Pure()
+	var logs strings.Builder
+	app.Logger = log.New(&logs, "", 0)
+	ln, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	acceptErr := errors.New("accept error")
+	assert.Equal(t, acceptErr, app.Serve(&failingListener{Listener: ln, err: acceptErr}))
+	assert.Contains(t, logs.String(), "failed to serve: accept error")
+	assert.NotContains(t, logs.String(), http.ErrServerClosed.Error())
+}
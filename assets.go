@@ -0,0 +1,279 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the length of hexadecimal fingerprints.
+const assetHashLength = 10
+
+// AssetsOption is a function that receives an assets instance.
+type AssetsOption func(*Assets)
+
+// AssetsReload is an option that indicates whether to fingerprint files again on
+// file change, the modification time is checked whenever the URL of a file is
+// generated, it should be enabled in development only.
+func AssetsReload(reload bool) AssetsOption {
+	return func(a *Assets) {
+		a.reload = reload
+	}
+}
+
+// AssetsPrefix is an option that sets the URL prefix of assets, such as "/static/",
+// defaults to "/".
+func AssetsPrefix(prefix string) AssetsOption {
+	return func(a *Assets) {
+		a.prefix = prefix
+	}
+}
+
+// Assets is a http.FileSystem that fingerprints files by their content at startup,
+// such as "js/app.js" turns into "js/app.3f9a1c0b2e.js", so that browsers can cache
+// them forever, and fetch the new version once the content changes.
+//
+// The fingerprinted names are resolved to the original files, and the original names
+// are still available. Assets can be served by Static or ServeFiles, both of them
+// serve the fingerprinted files with long-lived Cache-Control header:
+//
+//	assets, err := clevergo.NewAssets(http.Dir("public"), clevergo.AssetsPrefix("/static/"))
+//	app.Assets = assets
+//	app.ServeFiles("/static/", assets)
+//
+// The URLs can be generated by Context.AssetURL and the "asset" template function.
+//
+// Files are fingerprinted once, and treated as static for the life of the process,
+// unless reloading is enabled, see AssetsReload. Files that are added later are
+// never fingerprinted.
+type Assets struct {
+	root   http.FileSystem
+	prefix string
+	reload bool
+
+	mu sync.RWMutex
+	// original names to fingerprinted names.
+	manifest map[string]string
+	// fingerprinted names to original names.
+	originals map[string]string
+	// modification time of original names.
+	modTimes map[string]time.Time
+}
+
+// NewAssets returns an assets instance that fingerprints all files of the given
+// root, the precompressed siblings, such as "app.js.gz", share the fingerprint of
+// the original files.
+func NewAssets(root http.FileSystem, opts ...AssetsOption) (*Assets, error) {
+	a := &Assets{
+		root:      root,
+		prefix:    "/",
+		manifest:  make(map[string]string),
+		originals: make(map[string]string),
+		modTimes:  make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if !strings.HasSuffix(a.prefix, "/") {
+		a.prefix += "/"
+	}
+	if err := a.walk("/"); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Assets) walk(dir string) error {
+	f, err := a.root.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if err = a.walk(name); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := trimEncodingExt(info.Name(), names); ok {
+			continue
+		}
+		if err = a.fingerprint(name, info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fingerprint hashes the file and updates the manifest, the caller must hold
+// the lock if the assets is in use.
+func (a *Assets) fingerprint(name string, modTime time.Time) error {
+	hash, err := a.hash(name)
+	if err != nil {
+		return err
+	}
+	ext := path.Ext(name)
+	fingerprinted := strings.TrimSuffix(name, ext) + "." + hash + ext
+	if old, ok := a.manifest[name[1:]]; ok {
+		delete(a.originals, "/"+old)
+	}
+	a.manifest[name[1:]] = fingerprinted[1:]
+	a.originals[fingerprinted] = name
+	a.modTimes[name] = modTime
+	return nil
+}
+
+// refresh fingerprints the file again if it has been modified.
+func (a *Assets) refresh(name string) {
+	name = path.Clean("/" + name)
+	a.mu.RLock()
+	modTime, ok := a.modTimes[name]
+	a.mu.RUnlock()
+	if !ok {
+		return
+	}
+	f, err := a.root.Open(name)
+	if err != nil {
+		return
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fingerprint(name, info.ModTime())
+}
+
+// trimEncodingExt returns the original name of the precompressed file, if the
+// original file exists.
+func trimEncodingExt(name string, names map[string]bool) (string, bool) {
+	for _, e := range staticEncodings {
+		if original := strings.TrimSuffix(name, e.extension); original != name && names[original] {
+			return original, true
+		}
+	}
+	return "", false
+}
+
+func (a *Assets) hash(name string) (string, error) {
+	f, err := a.root.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:assetHashLength], nil
+}
+
+// Open implements http.FileSystem, the fingerprinted names are resolved to the
+// original files.
+func (a *Assets) Open(name string) (http.File, error) {
+	if original, ok := a.original(name); ok {
+		name = original
+	}
+	return a.root.Open(name)
+}
+
+// original returns the original name of the fingerprinted name, including the
+// precompressed siblings.
+func (a *Assets) original(name string) (string, bool) {
+	name = path.Clean("/" + name)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if original, ok := a.originals[name]; ok {
+		return original, true
+	}
+	for _, e := range staticEncodings {
+		if trimmed := strings.TrimSuffix(name, e.extension); trimmed != name {
+			if original, ok := a.originals[trimmed]; ok {
+				return original + e.extension, true
+			}
+		}
+	}
+	return "", false
+}
+
+// IsFingerprinted reports whether the name is a fingerprinted name of the assets.
+func (a *Assets) IsFingerprinted(name string) bool {
+	_, ok := a.original(name)
+	return ok
+}
+
+// Path returns the fingerprinted name of the given file, it returns the name as it
+// is if the file does not exist.
+func (a *Assets) Path(name string) string {
+	if a.reload {
+		a.refresh(name)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if fingerprinted, ok := a.manifest[strings.TrimPrefix(name, "/")]; ok {
+		return fingerprinted
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+// URL returns the URL of the given file, which is composed of the prefix and the
+// fingerprinted name, such as "/static/js/app.3f9a1c0b2e.js".
+func (a *Assets) URL(name string) string {
+	return a.prefix + a.Path(name)
+}
+
+// Manifest returns a copy of the manifest that maps the original names to the
+// fingerprinted names.
+func (a *Assets) Manifest() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	manifest := make(map[string]string, len(a.manifest))
+	for name, fingerprinted := range a.manifest {
+		manifest[name] = fingerprinted
+	}
+	return manifest
+}
+
+// WriteManifest writes the manifest as JSON, which can be consumed by other tools.
+func (a *Assets) WriteManifest(w io.Writer) error {
+	data, err := json.MarshalIndent(a.Manifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// AssetURL returns the fingerprinted URL of the given asset, see Assets.URL. The
+// name is returned as it is if the assets of application is absent.
+func (c *Context) AssetURL(name string) string {
+	if c.app == nil || c.app.Assets == nil {
+		return name
+	}
+	return c.app.Assets.URL(name)
+}
+
+// make sure Assets conforms with the http.FileSystem interface.
+var _ http.FileSystem = (*Assets)(nil)
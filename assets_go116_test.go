@@ -0,0 +1,29 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package clevergo
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAssetsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":      {Data: []byte("app")},
+		"css/app.css": {Data: []byte("css")},
+	}
+	assets, err := NewAssetsFS(fsys, AssetsPrefix("/static/"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"app.js":      "app." + testAssetHash("app") + ".js",
+		"css/app.css": "css/app." + testAssetHash("css") + ".css",
+	}, assets.Manifest())
+	assert.Equal(t, "/static/app."+testAssetHash("app")+".js", assets.URL("app.js"))
+}
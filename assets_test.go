@@ -0,0 +1,222 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAssetHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:assetHashLength]
+}
+
+func newTestAssets(t *testing.T, opts ...AssetsOption) (*Assets, string) {
+	dir, err := ioutil.TempDir("", "assets")
+	assert.Nil(t, err)
+	writeTemplateFiles(t, dir, map[string]string{
+		"app.js":          "app",
+		"app.js.gz":       "app gzip",
+		"css/app.min.css": "css",
+		"LICENSE":         "license",
+		"index.html":      `<script src="{{ asset "app.js" }}"></script>`,
+	})
+	assets, err := NewAssets(http.Dir(dir), opts...)
+	assert.Nil(t, err)
+	return assets, dir
+}
+
+func TestNewAssets(t *testing.T) {
+	assets, dir := newTestAssets(t)
+	defer os.RemoveAll(dir)
+
+	appJS := "app." + testAssetHash("app") + ".js"
+	manifest := assets.Manifest()
+	assert.Equal(t, map[string]string{
+		"app.js":          appJS,
+		"css/app.min.css": "css/app.min." + testAssetHash("css") + ".css",
+		"LICENSE":         "LICENSE." + testAssetHash("license"),
+		"index.html":      "index." + testAssetHash(`<script src="{{ asset "app.js" }}"></script>`) + ".html",
+	}, manifest)
+
+	// the manifest is a copy.
+	manifest["app.js"] = "foo"
+	assert.Equal(t, appJS, assets.Path("app.js"))
+
+	_, err := NewAssets(http.Dir(dir + "/missing"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAssetsURL(t *testing.T) {
+	assets, dir := newTestAssets(t, AssetsPrefix("/static"))
+	defer os.RemoveAll(dir)
+
+	appJS := "app." + testAssetHash("app") + ".js"
+	cases := map[string]string{
+		"app.js":          "/static/" + appJS,
+		"/app.js":         "/static/" + appJS,
+		"css/app.min.css": "/static/css/app.min." + testAssetHash("css") + ".css",
+		"missing.js":      "/static/missing.js",
+	}
+	for name, expected := range cases {
+		assert.Equal(t, expected, assets.URL(name), name)
+	}
+
+	app := Pure()
+	ctx := newContext(nil, nil)
+	ctx.app = app
+	assert.Equal(t, "app.js", ctx.AssetURL("app.js"))
+	app.Assets = assets
+	assert.Equal(t, "/static/"+appJS, ctx.AssetURL("app.js"))
+}
+
+func TestAssetsReload(t *testing.T) {
+	for _, reload := range []bool{false, true} {
+		assets, dir := newTestAssets(t, AssetsReload(reload))
+		oldJS := "app." + testAssetHash("app") + ".js"
+		assert.Equal(t, oldJS, assets.Path("app.js"))
+
+		filename := filepath.Join(dir, "app.js")
+		assert.Nil(t, ioutil.WriteFile(filename, []byte("new app"), 0644))
+		future := time.Now().Add(time.Minute)
+		assert.Nil(t, os.Chtimes(filename, future, future))
+
+		newJS := "app." + testAssetHash("new app") + ".js"
+		if reload {
+			assert.Equal(t, newJS, assets.Path("app.js"))
+			assert.True(t, assets.IsFingerprinted(newJS))
+			assert.False(t, assets.IsFingerprinted(oldJS))
+			assert.True(t, assets.IsFingerprinted(newJS+".gz"))
+		} else {
+			assert.Equal(t, oldJS, assets.Path("app.js"))
+			assert.False(t, assets.IsFingerprinted(newJS))
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAssetsOpen(t *testing.T) {
+	assets, dir := newTestAssets(t)
+	defer os.RemoveAll(dir)
+
+	hash := testAssetHash("app")
+	cases := map[string]string{
+		"/app.js":                     "app",
+		"/app." + hash + ".js":        "app",
+		"app." + hash + ".js":         "app",
+		"/app.js.gz":                  "app gzip",
+		"/app." + hash + ".js.gz":     "app gzip",
+		"/css/../app." + hash + ".js": "app",
+	}
+	for name, expected := range cases {
+		f, err := assets.Open(name)
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+		content, err := ioutil.ReadAll(f)
+		f.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(content), name)
+	}
+
+	_, err := assets.Open("/app.0000000000.js")
+	assert.True(t, os.IsNotExist(err))
+
+	assert.True(t, assets.IsFingerprinted("/app."+hash+".js"))
+	assert.True(t, assets.IsFingerprinted("/app."+hash+".js.gz"))
+	assert.False(t, assets.IsFingerprinted("/app.js"))
+	assert.False(t, assets.IsFingerprinted("/app.0000000000.js"))
+}
+
+func TestAssetsWriteManifest(t *testing.T) {
+	assets, dir := newTestAssets(t)
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, assets.WriteManifest(buf))
+	manifest := make(map[string]string)
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &manifest))
+	assert.Equal(t, assets.Manifest(), manifest)
+}
+
+func TestAssetsServeFiles(t *testing.T) {
+	assets, dir := newTestAssets(t, AssetsPrefix("/static/"))
+	defer os.RemoveAll(dir)
+
+	app := Pure()
+	app.Assets = assets
+	app.ServeFiles("/static/", assets)
+	cases := []struct {
+		path         string
+		code         int
+		body         string
+		cacheControl string
+	}{
+		{assets.URL("app.js"), http.StatusOK, "app", cacheControlImmutable},
+		{assets.URL("LICENSE"), http.StatusOK, "license", cacheControlImmutable},
+		{"/static/app.js", http.StatusOK, "app", ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.cacheControl, w.Header().Get("Cache-Control"), test.path)
+	}
+}
+
+func TestAssetsStatic(t *testing.T) {
+	assets, dir := newTestAssets(t)
+	defer os.RemoveAll(dir)
+
+	s := NewStatic(assets)
+	cases := []struct {
+		path         string
+		body         string
+		encoding     string
+		cacheControl string
+	}{
+		{assets.URL("app.js"), "app gzip", "gzip", cacheControlImmutable},
+		{assets.URL("LICENSE"), "license", "", cacheControlImmutable},
+		{"/app.js", "app gzip", "gzip", cacheControlNoCache},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		s.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"), test.path)
+		assert.Equal(t, test.cacheControl, w.Header().Get("Cache-Control"), test.path)
+	}
+}
+
+func TestAssetsTemplateFunc(t *testing.T) {
+	assets, dir := newTestAssets(t, AssetsPrefix("/static/"))
+	defer os.RemoveAll(dir)
+
+	app := Pure()
+	app.Assets = assets
+	app.Renderer = NewTemplateRenderer(http.Dir(dir))
+	app.Get("/", func(c *Context) error {
+		return c.Render(http.StatusOK, "index.html", nil)
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, `<script src="`+assets.URL("app.js")+`"></script>`, w.Body.String())
+}
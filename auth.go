@@ -0,0 +1,280 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"strings"
+)
+
+const headerWWWAuthenticate = "WWW-Authenticate"
+
+const defaultRealm = "Restricted"
+
+type principalKey struct{}
+
+// SetPrincipal stores the authenticated principal, such as an user or a token.
+func (c *Context) SetPrincipal(principal interface{}) {
+	c.WithValue(principalKey{}, principal)
+}
+
+// Principal returns the authenticated principal, nil if the request is unauthenticated.
+func (c *Context) Principal() interface{} {
+	return c.Value(principalKey{})
+}
+
+// unauthorized sets WWW-Authenticate header with the given scheme and parameters,
+// and returns ErrUnauthorized.
+func unauthorized(c *Context, scheme string, params ...string) error {
+	c.SetHeader(headerWWWAuthenticate, challenge(scheme, params...))
+	return ErrUnauthorized
+}
+
+type challengeKey struct{}
+
+// setChallenge stores the challenge of an authentication middleware that skipped
+// the request, it will be sent if the request is rejected by RequirePolicy later.
+func setChallenge(c *Context, scheme string, params ...string) {
+	c.WithValue(challengeKey{}, challenge(scheme, params...))
+}
+
+// challenge returns the challenge of the given scheme and parameters, parameters
+// with empty value are omitted.
+func challenge(scheme string, params ...string) string {
+	challenge := scheme
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] == "" {
+			continue
+		}
+		if challenge == scheme {
+			challenge += " "
+		} else {
+			challenge += ", "
+		}
+		challenge += params[i] + "=" + quoteString(params[i+1])
+	}
+	return challenge
+}
+
+// quoteString returns a quoted-string defined in RFC 7230 section 3.2.6, double
+// quotes and backslashes are escaped, control characters are removed since they
+// cannot be represented.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch == '\t' || (ch >= 0x20 && ch != 0x7f):
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// BasicAuthValidator validates the username and password, and returns the principal.
+// Returning a nil principal without error indicates that the credentials are invalid,
+// an error other than nil will be returned by the middleware as it is.
+type BasicAuthValidator func(c *Context, username, password string) (interface{}, error)
+
+// BasicAuthOption is a function that receives a basic auth instance.
+type BasicAuthOption func(*basicAuth)
+
+// BasicAuthRealm is an option that sets realm.
+func BasicAuthRealm(realm string) BasicAuthOption {
+	return func(a *basicAuth) {
+		a.realm = realm
+	}
+}
+
+// BasicAuthSkipper is an option that sets skipper.
+func BasicAuthSkipper(skipper Skipper) BasicAuthOption {
+	return func(a *basicAuth) {
+		a.skipper = skipper
+	}
+}
+
+// BasicAuth returns a HTTP basic authentication middleware, the principal returned by
+// validator will be stored in context, see Context.Principal.
+// Unauthenticated requests will be rejected by ErrUnauthorized with WWW-Authenticate header.
+func BasicAuth(validator BasicAuthValidator, opts ...BasicAuthOption) MiddlewareFunc {
+	a := &basicAuth{
+		validator: validator,
+		realm:     defaultRealm,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a.middleware
+}
+
+type basicAuth struct {
+	validator BasicAuthValidator
+	realm     string
+	skipper   Skipper
+}
+
+func (a *basicAuth) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if a.skipper != nil && a.skipper(c) {
+			setChallenge(c, "Basic", "realm", a.realm, "charset", "UTF-8")
+			return next(c)
+		}
+
+		username, password, ok := c.BasicAuth()
+		if !ok {
+			return a.unauthorized(c)
+		}
+		principal, err := a.validator(c, username, password)
+		if err != nil {
+			return err
+		}
+		if principal == nil {
+			return a.unauthorized(c)
+		}
+		c.SetPrincipal(principal)
+		return next(c)
+	}
+}
+
+func (a *basicAuth) unauthorized(c *Context) error {
+	return unauthorized(c, "Basic", "realm", a.realm, "charset", "UTF-8")
+}
+
+// KeyExtractor extracts key from request, returns an empty string if the key is absent.
+type KeyExtractor func(c *Context) string
+
+// HeaderKeyExtractor returns a key extractor that extracts key from the given header,
+// the scheme is optional, such as "Bearer", and is case-insensitive.
+func HeaderKeyExtractor(header, scheme string) KeyExtractor {
+	return func(c *Context) string {
+		value := c.GetHeader(header)
+		if scheme == "" {
+			return value
+		}
+		if len(value) > len(scheme) && strings.EqualFold(value[:len(scheme)], scheme) && value[len(scheme)] == ' ' {
+			return strings.TrimSpace(value[len(scheme)+1:])
+		}
+		return ""
+	}
+}
+
+// QueryKeyExtractor returns a key extractor that extracts key from the given query parameter.
+func QueryKeyExtractor(name string) KeyExtractor {
+	return func(c *Context) string {
+		return c.QueryParam(name)
+	}
+}
+
+// CookieKeyExtractor returns a key extractor that extracts key from the given cookie.
+func CookieKeyExtractor(name string) KeyExtractor {
+	return func(c *Context) string {
+		cookie, err := c.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// ChainKeyExtractor returns a key extractor that tries the given extractors in order,
+// and returns the first non-empty key.
+func ChainKeyExtractor(extractors ...KeyExtractor) KeyExtractor {
+	return func(c *Context) string {
+		for _, extractor := range extractors {
+			if key := extractor(c); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+var defaultKeyExtractor = HeaderKeyExtractor("Authorization", "Bearer")
+
+// KeyValidator validates the key, and returns the principal.
+// Returning a nil principal without error indicates that the key is invalid,
+// an error other than nil will be returned by the middleware as it is.
+type KeyValidator func(c *Context, key string) (interface{}, error)
+
+// KeyAuthOption is a function that receives a key auth instance.
+type KeyAuthOption func(*keyAuth)
+
+// KeyAuthRealm is an option that sets realm.
+func KeyAuthRealm(realm string) KeyAuthOption {
+	return func(a *keyAuth) {
+		a.realm = realm
+	}
+}
+
+// KeyAuthScheme is an option that sets the authentication scheme of WWW-Authenticate
+// header, defaults to "Bearer".
+func KeyAuthScheme(scheme string) KeyAuthOption {
+	return func(a *keyAuth) {
+		a.scheme = scheme
+	}
+}
+
+// KeyAuthSkipper is an option that sets skipper.
+func KeyAuthSkipper(skipper Skipper) KeyAuthOption {
+	return func(a *keyAuth) {
+		a.skipper = skipper
+	}
+}
+
+// KeyAuth returns an API key or bearer token authentication middleware, the key
+// is extracted from Authorization bearer header if extractor is nil.
+// The principal returned by validator will be stored in context, see Context.Principal.
+// Unauthenticated requests will be rejected by ErrUnauthorized with WWW-Authenticate header.
+func KeyAuth(extractor KeyExtractor, validator KeyValidator, opts ...KeyAuthOption) MiddlewareFunc {
+	if extractor == nil {
+		extractor = defaultKeyExtractor
+	}
+	a := &keyAuth{
+		extractor: extractor,
+		validator: validator,
+		realm:     defaultRealm,
+		scheme:    "Bearer",
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a.middleware
+}
+
+type keyAuth struct {
+	extractor KeyExtractor
+	validator KeyValidator
+	realm     string
+	scheme    string
+	skipper   Skipper
+}
+
+func (a *keyAuth) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if a.skipper != nil && a.skipper(c) {
+			setChallenge(c, a.scheme, "realm", a.realm)
+			return next(c)
+		}
+
+		key := a.extractor(c)
+		if key == "" {
+			return unauthorized(c, a.scheme, "realm", a.realm)
+		}
+		principal, err := a.validator(c, key)
+		if err != nil {
+			return err
+		}
+		if principal == nil {
+			return unauthorized(c, a.scheme, "realm", a.realm, "error", "invalid_token")
+		}
+		c.SetPrincipal(principal)
+		return next(c)
+	}
+}
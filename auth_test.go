@@ -0,0 +1,162 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func principalHandler(c *Context) error {
+	return c.String(http.StatusOK, c.Principal().(string))
+}
+
+func TestContextPrincipal(t *testing.T) {
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Nil(t, c.Principal())
+	c.SetPrincipal("foo")
+	assert.Equal(t, "foo", c.Principal())
+}
+
+func TestBasicAuth(t *testing.T) {
+	internalErr := errors.New("internal error")
+	validator := func(c *Context, username, password string) (interface{}, error) {
+		if username == "error" {
+			return nil, internalErr
+		}
+		if username == "foo" && password == "bar" {
+			return username, nil
+		}
+		return nil, nil
+	}
+	m := BasicAuth(validator, BasicAuthRealm("Admin"), BasicAuthSkipper(PathSkipper("/public")))
+	cases := []struct {
+		path     string
+		username string
+		password string
+		err      error
+		body     string
+	}{
+		{"/", "", "", ErrUnauthorized, ""},
+		{"/", "foo", "baz", ErrUnauthorized, ""},
+		{"/", "error", "", internalErr, ""},
+		{"/", "foo", "bar", nil, "foo"},
+		{"/public", "", "", nil, "public"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.username != "" {
+			req.SetBasicAuth(test.username, test.password)
+		}
+		c := newContext(w, req)
+		err := m(func(c *Context) error {
+			if c.Principal() == nil {
+				return c.String(http.StatusOK, "public")
+			}
+			return principalHandler(c)
+		})(c)
+		assert.Equal(t, test.err, err)
+		if err == ErrUnauthorized {
+			assert.Equal(t, `Basic realm="Admin", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+		}
+		assert.Equal(t, test.body, w.Body.String())
+	}
+}
+
+func TestKeyExtractors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?api_key=query", nil)
+	req.Header.Set("Authorization", "bearer  header")
+	req.Header.Set("X-API-Key", "key")
+	req.AddCookie(&http.Cookie{Name: "token", Value: "cookie"})
+	c := newContext(nil, req)
+
+	assert.Equal(t, "header", HeaderKeyExtractor("Authorization", "Bearer")(c))
+	assert.Equal(t, "", HeaderKeyExtractor("Authorization", "Basic")(c))
+	assert.Equal(t, "", HeaderKeyExtractor("X-API-Key", "Bearer")(c))
+	assert.Equal(t, "key", HeaderKeyExtractor("X-API-Key", "")(c))
+	assert.Equal(t, "query", QueryKeyExtractor("api_key")(c))
+	assert.Equal(t, "", QueryKeyExtractor("token")(c))
+	assert.Equal(t, "cookie", CookieKeyExtractor("token")(c))
+	assert.Equal(t, "", CookieKeyExtractor("api_key")(c))
+	assert.Equal(t, "cookie", ChainKeyExtractor(QueryKeyExtractor("token"), CookieKeyExtractor("token"))(c))
+	assert.Equal(t, "", ChainKeyExtractor(QueryKeyExtractor("token"))(c))
+}
+
+func TestKeyAuth(t *testing.T) {
+	internalErr := errors.New("internal error")
+	validator := func(c *Context, key string) (interface{}, error) {
+		switch key {
+		case "error":
+			return nil, internalErr
+		case "secret":
+			return "foo", nil
+		}
+		return nil, nil
+	}
+	cases := []struct {
+		extractor     KeyExtractor
+		opts          []KeyAuthOption
+		authorization string
+		query         string
+		err           error
+		challenge     string
+		body          string
+	}{
+		{nil, nil, "", "", ErrUnauthorized, `Bearer realm="Restricted"`, ""},
+		{nil, nil, "Bearer invalid", "", ErrUnauthorized, `Bearer realm="Restricted", error="invalid_token"`, ""},
+		{nil, nil, "Bearer error", "", internalErr, "", ""},
+		{nil, nil, "Bearer secret", "", nil, "", "foo"},
+		{QueryKeyExtractor("key"), []KeyAuthOption{KeyAuthScheme("ApiKey"), KeyAuthRealm("API")}, "", "", ErrUnauthorized, `ApiKey realm="API"`, ""},
+		{QueryKeyExtractor("key"), nil, "", "secret", nil, "", "foo"},
+		{nil, []KeyAuthOption{KeyAuthSkipper(func(c *Context) bool { return true })}, "", "", nil, "", "skipped"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/?key="+test.query, nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		c := newContext(w, req)
+		err := KeyAuth(test.extractor, validator, test.opts...)(func(c *Context) error {
+			if c.Principal() == nil {
+				return c.String(http.StatusOK, "skipped")
+			}
+			return principalHandler(c)
+		})(c)
+		assert.Equal(t, test.err, err)
+		assert.Equal(t, test.challenge, w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, test.body, w.Body.String())
+	}
+}
+
+func TestQuoteString(t *testing.T) {
+	cases := map[string]string{
+		"":           `""`,
+		"Restricted": `"Restricted"`,
+		`a "b" \ c`:  `"a \"b\" \\ c"`,
+		"a\tb\r\nc":  "\"a\tbc\"",
+		"中文":         `"中文"`,
+	}
+	for s, expected := range cases {
+		assert.Equal(t, expected, quoteString(s))
+	}
+}
+
+func TestUnauthorizedResponse(t *testing.T) {
+	app := New()
+	app.Use(BasicAuth(func(c *Context, username, password string) (interface{}, error) {
+		return nil, nil
+	}))
+	app.Get("/", echoHandler("foo"))
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="Restricted", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"))
+}
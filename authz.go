@@ -0,0 +1,281 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import "sync"
+
+// Policy is an interface that decides whether a request is authorized.
+type Policy interface {
+	// Authorize returns true if the request is allowed, an error other than nil
+	// will be returned by the route as it is.
+	Authorize(c *Context) (bool, error)
+}
+
+// PolicyFunc is an adapter to allow the use of ordinary functions as policies,
+// it is useful for resource level checks, such as verifying that the principal
+// owns the resource identified by c.Params.
+type PolicyFunc func(c *Context) (bool, error)
+
+// Authorize implements Policy.Authorize.
+func (f PolicyFunc) Authorize(c *Context) (bool, error) {
+	return f(c)
+}
+
+// AllPolicies returns a policy that allows the request only if all of the given
+// policies allow it.
+func AllPolicies(policies ...Policy) Policy {
+	return PolicyFunc(func(c *Context) (bool, error) {
+		for _, policy := range policies {
+			if ok, err := policy.Authorize(c); !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// AnyPolicy returns a policy that allows the request if any of the given policies
+// allows it.
+func AnyPolicy(policies ...Policy) Policy {
+	return PolicyFunc(func(c *Context) (bool, error) {
+		for _, policy := range policies {
+			if ok, err := policy.Authorize(c); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	})
+}
+
+// RoleHolder is an interface that principals implement to expose their roles,
+// see Context.Principal.
+type RoleHolder interface {
+	Roles() []string
+}
+
+func principalRoles(c *Context) []string {
+	if holder, ok := c.Principal().(RoleHolder); ok {
+		return holder.Roles()
+	}
+	return nil
+}
+
+func (c *Context) rbac() *RBAC {
+	if c.app == nil {
+		return nil
+	}
+	return c.app.RBAC
+}
+
+// RolePolicy returns a policy that allows the principal that has any of the given
+// roles, inherited roles of Application.RBAC are taken into account.
+func RolePolicy(roles ...string) Policy {
+	return PolicyFunc(func(c *Context) (bool, error) {
+		rbac := c.rbac()
+		for _, owned := range principalRoles(c) {
+			for _, role := range roles {
+				if owned == role || (rbac != nil && rbac.inherits(owned, role)) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	})
+}
+
+// PermissionPolicy returns a policy that allows the principal that is granted all
+// of the given permissions by Application.RBAC.
+func PermissionPolicy(permissions ...string) Policy {
+	return PolicyFunc(func(c *Context) (bool, error) {
+		rbac := c.rbac()
+		if rbac == nil {
+			return false, nil
+		}
+		roles := principalRoles(c)
+		for _, permission := range permissions {
+			if !rbac.IsGranted(roles, permission) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// RequirePolicy is a route option that authorizes requests with the given policies,
+// all of them must allow the request.
+//
+// Unauthenticated requests are rejected with ErrUnauthorized, and requests denied by
+// policies are rejected with ErrForbidden. The principal is supposed to be set by
+// an authentication middleware in advance, such as BasicAuth, KeyAuth and JWTAuth.
+// The WWW-Authenticate header is the challenge of the authentication middleware
+// that skipped the request, defaults to a Bearer challenge.
+//
+// The policies are always checked right before the route handler, after all of the
+// middlewares, including the ones added by RouteMiddleware, regardless of the order
+// of route options.
+func RequirePolicy(policies ...Policy) RouteOption {
+	return func(r *Route) {
+		r.policies = append(r.policies, policies...)
+	}
+}
+
+// authorize returns a handler that authorizes requests with the policy before
+// calling the next handler.
+func authorize(policy Policy, next Handle) Handle {
+	return func(c *Context) error {
+		if c.Principal() == nil {
+			if challenge, ok := c.Value(challengeKey{}).(string); ok {
+				c.SetHeader(headerWWWAuthenticate, challenge)
+				return ErrUnauthorized
+			}
+			return unauthorized(c, "Bearer", "realm", defaultRealm)
+		}
+		ok, err := policy.Authorize(c)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrForbidden
+		}
+		return next(c)
+	}
+}
+
+// RequireRole is a route option that requires the principal to have any of the
+// given roles, see RolePolicy and RequirePolicy.
+func RequireRole(roles ...string) RouteOption {
+	return RequirePolicy(RolePolicy(roles...))
+}
+
+// RequirePermission is a route option that requires the principal to be granted
+// all of the given permissions, see PermissionPolicy and RequirePolicy.
+func RequirePermission(permissions ...string) RouteOption {
+	return RequirePolicy(PermissionPolicy(permissions...))
+}
+
+// RouteGroupRequirePolicy is a route group option that authorizes the requests of
+// the routes that belong to the group, see RequirePolicy.
+func RouteGroupRequirePolicy(policies ...Policy) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.routeOptions = append(r.routeOptions, RequirePolicy(policies...))
+	}
+}
+
+// RouteGroupRequireRole is a route group option that requires the principal to have
+// any of the given roles, see RequireRole.
+func RouteGroupRequireRole(roles ...string) RouteGroupOption {
+	return RouteGroupRequirePolicy(RolePolicy(roles...))
+}
+
+// RouteGroupRequirePermission is a route group option that requires the principal to
+// be granted all of the given permissions, see RequirePermission.
+func RouteGroupRequirePermission(permissions ...string) RouteGroupOption {
+	return RouteGroupRequirePolicy(PermissionPolicy(permissions...))
+}
+
+// RBAC is a role based access control, a role inherits the permissions of its
+// parent roles. It is safe for concurrent use.
+type RBAC struct {
+	mu          sync.RWMutex
+	parents     map[string][]string
+	permissions map[string]map[string]bool
+}
+
+// NewRBAC returns a RBAC instance.
+func NewRBAC() *RBAC {
+	return &RBAC{
+		parents:     make(map[string][]string),
+		permissions: make(map[string]map[string]bool),
+	}
+}
+
+// AddRole adds a role that inherits the given parent roles.
+func (r *RBAC) AddRole(role string, parents ...string) {
+	r.mu.Lock()
+	r.parents[role] = append(r.parents[role], parents...)
+	r.mu.Unlock()
+}
+
+// Grant grants the given permissions to the role.
+func (r *RBAC) Grant(role string, permissions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.permissions[role] == nil {
+		r.permissions[role] = make(map[string]bool, len(permissions))
+	}
+	for _, permission := range permissions {
+		r.permissions[role][permission] = true
+	}
+}
+
+// Revoke revokes the given permissions from the role.
+func (r *RBAC) Revoke(role string, permissions ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, permission := range permissions {
+		delete(r.permissions[role], permission)
+	}
+}
+
+// HasRole reports whether any of the given roles is or inherits the role.
+func (r *RBAC) HasRole(roles []string, role string) bool {
+	for _, owned := range roles {
+		if owned == role || r.inherits(owned, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGranted reports whether any of the given roles is granted the permission,
+// either directly or through inheritance.
+func (r *RBAC) IsGranted(roles []string, permission string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	visited := make(map[string]bool)
+	for _, role := range roles {
+		if r.isGranted(role, permission, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RBAC) isGranted(role, permission string, visited map[string]bool) bool {
+	if visited[role] {
+		return false
+	}
+	visited[role] = true
+	if r.permissions[role][permission] {
+		return true
+	}
+	for _, parent := range r.parents[role] {
+		if r.isGranted(parent, permission, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// inherits reports whether the role inherits the ancestor role.
+func (r *RBAC) inherits(role, ancestor string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	visited := make(map[string]bool)
+	queue := []string{role}
+	for len(queue) > 0 {
+		role, queue = queue[0], queue[1:]
+		for _, parent := range r.parents[role] {
+			if parent == ancestor {
+				return true
+			}
+			if !visited[parent] {
+				visited[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return false
+}
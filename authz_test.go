@@ -0,0 +1,267 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser struct {
+	name  string
+	roles []string
+}
+
+func (u testUser) Roles() []string {
+	return u.roles
+}
+
+func newTestRBAC() *RBAC {
+	rbac := NewRBAC()
+	rbac.AddRole("editor", "author")
+	rbac.AddRole("admin", "editor")
+	rbac.AddRole("author", "admin") // circular inheritance.
+	rbac.Grant("author", "posts:create", "posts:update")
+	rbac.Grant("editor", "posts:delete")
+	rbac.Grant("admin", "users:delete")
+	return rbac
+}
+
+func TestRBAC(t *testing.T) {
+	rbac := NewRBAC()
+	rbac.AddRole("editor", "author")
+	rbac.AddRole("admin", "editor")
+	rbac.Grant("author", "posts:create", "posts:update")
+	rbac.Grant("editor", "posts:delete")
+	rbac.Grant("admin", "users:delete")
+
+	assert.True(t, rbac.HasRole([]string{"admin"}, "admin"))
+	assert.True(t, rbac.HasRole([]string{"admin"}, "author"))
+	assert.True(t, rbac.HasRole([]string{"guest", "editor"}, "author"))
+	assert.False(t, rbac.HasRole([]string{"editor"}, "admin"))
+	assert.False(t, rbac.HasRole(nil, "author"))
+
+	assert.True(t, rbac.IsGranted([]string{"admin"}, "posts:create"))
+	assert.True(t, rbac.IsGranted([]string{"editor"}, "posts:delete"))
+	assert.False(t, rbac.IsGranted([]string{"editor"}, "users:delete"))
+	assert.False(t, rbac.IsGranted([]string{"author"}, "posts:delete"))
+	assert.False(t, rbac.IsGranted(nil, "posts:create"))
+
+	rbac.Revoke("author", "posts:update")
+	assert.False(t, rbac.IsGranted([]string{"admin"}, "posts:update"))
+	assert.True(t, rbac.IsGranted([]string{"admin"}, "posts:create"))
+
+	// circular inheritance.
+	rbac = newTestRBAC()
+	assert.True(t, rbac.HasRole([]string{"author"}, "editor"))
+	assert.True(t, rbac.IsGranted([]string{"author"}, "users:delete"))
+	assert.False(t, rbac.IsGranted([]string{"author"}, "unknown"))
+	assert.False(t, rbac.HasRole([]string{"author"}, "unknown"))
+}
+
+func TestPolicies(t *testing.T) {
+	allow := PolicyFunc(func(c *Context) (bool, error) { return true, nil })
+	deny := PolicyFunc(func(c *Context) (bool, error) { return false, nil })
+	policyErr := errors.New("policy error")
+	fail := PolicyFunc(func(c *Context) (bool, error) { return false, policyErr })
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cases := []struct {
+		policy Policy
+		ok     bool
+		err    error
+	}{
+		{AllPolicies(), true, nil},
+		{AllPolicies(allow, allow), true, nil},
+		{AllPolicies(allow, deny), false, nil},
+		{AllPolicies(allow, fail), false, policyErr},
+		{AnyPolicy(), false, nil},
+		{AnyPolicy(deny, allow), true, nil},
+		{AnyPolicy(deny, deny), false, nil},
+		{AnyPolicy(fail, allow), false, policyErr},
+	}
+	for _, test := range cases {
+		ok, err := test.policy.Authorize(c)
+		assert.Equal(t, test.ok, ok)
+		assert.Equal(t, test.err, err)
+	}
+}
+
+func TestRolePolicyAndPermissionPolicy(t *testing.T) {
+	app := Pure()
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	c.app = app
+	c.SetPrincipal(testUser{roles: []string{"editor"}})
+
+	// without RBAC.
+	ok, _ := RolePolicy("editor").Authorize(c)
+	assert.True(t, ok)
+	ok, _ = RolePolicy("author").Authorize(c)
+	assert.False(t, ok)
+	ok, _ = PermissionPolicy("posts:delete").Authorize(c)
+	assert.False(t, ok)
+
+	app.RBAC = newTestRBAC()
+	ok, _ = RolePolicy("guest", "author").Authorize(c)
+	assert.True(t, ok)
+	ok, _ = PermissionPolicy("posts:create", "posts:delete").Authorize(c)
+	assert.True(t, ok)
+	ok, _ = PermissionPolicy("posts:create", "unknown").Authorize(c)
+	assert.False(t, ok)
+
+	// principal without roles.
+	c.SetPrincipal("foo")
+	ok, _ = RolePolicy("editor").Authorize(c)
+	assert.False(t, ok)
+	ok, _ = PermissionPolicy("posts:create").Authorize(c)
+	assert.False(t, ok)
+}
+
+func TestRequirePolicy(t *testing.T) {
+	rbac := NewRBAC()
+	rbac.AddRole("admin", "author")
+	rbac.Grant("author", "posts:update")
+	rbac.Grant("admin", "posts:delete")
+
+	users := map[string]testUser{
+		"admin":  {"admin", []string{"admin"}},
+		"author": {"author", []string{"author"}},
+		"foo":    {"foo", []string{"author"}},
+	}
+	authenticate := func(next Handle) Handle {
+		return func(c *Context) error {
+			if user, ok := users[c.GetHeader("X-User")]; ok {
+				c.SetPrincipal(user)
+			}
+			return next(c)
+		}
+	}
+	owner := PolicyFunc(func(c *Context) (bool, error) {
+		return c.Params.String("author") == c.Principal().(testUser).name, nil
+	})
+
+	app := Pure()
+	app.RBAC = rbac
+	app.Use(authenticate)
+	app.Get("/admin", echoHandler("admin"), RequireRole("admin"))
+	app.Delete("/posts/:author", echoHandler("deleted"), RequirePermission("posts:delete"))
+	app.Put("/posts/:author", echoHandler("updated"), RequirePermission("posts:update"), RequirePolicy(AnyPolicy(owner, RolePolicy("admin"))))
+	group := app.Group("/users", RouteGroupRequireRole("admin"))
+	group.Get("/", echoHandler("users"))
+	group.Delete("/:id", echoHandler("deleted"), RequirePermission("users:delete"))
+	app.Group("/posts", RouteGroupRequirePermission("posts:update")).Get("/", echoHandler("posts"))
+	app.Group("/authors", RouteGroupRequirePolicy(RolePolicy("author"))).Get("/", echoHandler("authors"))
+
+	cases := []struct {
+		method string
+		path   string
+		user   string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/admin", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/admin", "author", http.StatusForbidden, ""},
+		{http.MethodGet, "/admin", "admin", http.StatusOK, "admin"},
+		{http.MethodDelete, "/posts/foo", "author", http.StatusForbidden, ""},
+		{http.MethodDelete, "/posts/foo", "admin", http.StatusOK, "deleted"},
+		{http.MethodPut, "/posts/foo", "author", http.StatusForbidden, ""},
+		{http.MethodPut, "/posts/foo", "foo", http.StatusOK, "updated"},
+		{http.MethodPut, "/posts/foo", "admin", http.StatusOK, "updated"},
+		{http.MethodGet, "/users/", "", http.StatusUnauthorized, ""},
+		{http.MethodGet, "/users/", "author", http.StatusForbidden, ""},
+		{http.MethodGet, "/users/", "admin", http.StatusOK, "users"},
+		{http.MethodDelete, "/users/1", "admin", http.StatusForbidden, ""},
+		{http.MethodGet, "/posts/", "author", http.StatusOK, "posts"},
+		{http.MethodGet, "/authors/", "admin", http.StatusOK, "authors"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("X-User", test.user)
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.method+" "+test.path+" "+test.user)
+		if test.body != "" {
+			assert.Equal(t, test.body, w.Body.String())
+		}
+		if test.code == http.StatusUnauthorized {
+			assert.Equal(t, `Bearer realm="Restricted"`, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestRequireRoleWithJWTAuth(t *testing.T) {
+	keys := NewJWTKeySet(JWTKey{Key: testHMACKey})
+	optional := func(c *Context) bool {
+		return c.GetHeader("Authorization") == ""
+	}
+	cases := []struct {
+		opts      []JWTOption
+		claims    map[string]interface{}
+		code      int
+		challenge string
+	}{
+		{nil, nil, http.StatusUnauthorized, `Bearer realm="API"`},
+		{nil, map[string]interface{}{"roles": []string{"admin"}}, http.StatusOK, ""},
+		{nil, map[string]interface{}{"roles": []string{"author"}}, http.StatusForbidden, ""},
+		{nil, map[string]interface{}{"scope": "admin"}, http.StatusForbidden, ""},
+		{[]JWTOption{JWTRolesClaim("scope")}, map[string]interface{}{"scope": "read admin"}, http.StatusOK, ""},
+		{[]JWTOption{JWTRolesClaim("scope")}, map[string]interface{}{"roles": []string{"admin"}}, http.StatusForbidden, ""},
+	}
+	for i, test := range cases {
+		app := Pure()
+		opts := append([]JWTOption{JWTRealm("API"), JWTSkipper(optional)}, test.opts...)
+		app.Use(JWTAuth(keys, opts...))
+		app.Get("/admin", echoHandler("admin"), RequireRole("admin"))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		if test.claims != nil {
+			req.Header.Set("Authorization", "Bearer "+signTestJWT(JWTAlgorithmHS256, "", test.claims))
+		}
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, i)
+		assert.Equal(t, test.challenge, w.Header().Get("WWW-Authenticate"), i)
+	}
+}
+
+func TestRequirePolicyOrder(t *testing.T) {
+	authenticate := func(next Handle) Handle {
+		return func(c *Context) error {
+			if user := c.GetHeader("X-User"); user != "" {
+				c.SetPrincipal(testUser{user, []string{user}})
+			}
+			return next(c)
+		}
+	}
+	app := Pure()
+	app.Get("/before", echoHandler("before"), RequireRole("admin"), RouteMiddleware(authenticate))
+	app.Get("/after", echoHandler("after"), RouteMiddleware(authenticate), RequireRole("admin"))
+	app.Group("/group", RouteGroupMiddleware(authenticate), RouteGroupRequireRole("admin")).Get("/", echoHandler("group"))
+	for _, path := range []string{"/before", "/after", "/group/"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-User", "admin")
+		app.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+
+		w = httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+}
+
+func TestRequirePolicyError(t *testing.T) {
+	policyErr := errors.New("policy error")
+	route := newRoute("/", echoHandler("foo"), RequirePolicy(PolicyFunc(func(c *Context) (bool, error) {
+		return false, policyErr
+	})))
+	c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	c.SetPrincipal("foo")
+	assert.Equal(t, policyErr, route.handle(c))
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"io"
+	"net/http"
+)
+
+type bodyLimitKey struct{}
+
+// BodyLimit returns a middleware that limits the size of request body to the given
+// number of bytes.
+//
+// Requests whose Content-Length exceeds the limit are rejected with ErrRequestEntityTooLarge
+// before reading the body, otherwise, reading beyond the limit fails with
+// ErrRequestEntityTooLarge, which will be reported as 413 status code by Context.Decode
+// and so on.
+//
+// The limit of route takes precedence, see RouteBodyLimit and RouteGroupBodyLimit.
+func BodyLimit(limit int64) MiddlewareFunc {
+	l := &bodyLimit{limit: limit}
+	return l.middleware
+}
+
+// RouteBodyLimit is a route option that limits the size of request body, it takes
+// precedence over the limits of route group and global middleware, see BodyLimit.
+func RouteBodyLimit(limit int64) RouteOption {
+	return func(r *Route) {
+		r.setValue(bodyLimitKey{}, limit)
+		RouteMiddleware(BodyLimit(limit))(r)
+	}
+}
+
+// RouteGroupBodyLimit is a route group option that limits the size of request body
+// of the routes that belong to the group, it takes precedence over the global limit,
+// see BodyLimit.
+func RouteGroupBodyLimit(limit int64) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.routeOptions = append(r.routeOptions, RouteBodyLimit(limit))
+	}
+}
+
+type bodyLimit struct {
+	limit int64
+}
+
+func (l *bodyLimit) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		limit := l.resolve(c)
+		if c.Request.ContentLength > limit {
+			c.SetHeader("Connection", "close")
+			return ErrRequestEntityTooLarge
+		}
+
+		if body, ok := c.Request.Body.(*limitedBody); ok {
+			body.limit = limit
+		} else if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &limitedBody{
+				ReadCloser: c.Request.Body,
+				header:     c.Response.Header(),
+				limit:      limit,
+			}
+		}
+
+		return next(c)
+	}
+}
+
+// resolve returns the limit of the matched route if present, the route will be looked up
+// in advance if the middleware is used as a global middleware and the Content-Length
+// exceeds the limit, since the route may allow a larger limit.
+func (l *bodyLimit) resolve(c *Context) int64 {
+	route := c.Route
+	if route == nil && c.Request.ContentLength > l.limit {
+		route, _ = lookupRoute(c)
+	}
+	if route != nil {
+		if limit, ok := route.Value(bodyLimitKey{}).(int64); ok {
+			return limit
+		}
+	}
+	return l.limit
+}
+
+// limitedBody is similar to http.MaxBytesReader, but the limit can be changed
+// before reaching the limit.
+type limitedBody struct {
+	io.ReadCloser
+	header   http.Header
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.exceeded {
+		return 0, ErrRequestEntityTooLarge
+	}
+	remaining := b.limit - b.read
+	if remaining < 0 {
+		remaining = 0
+	}
+	// reads one more byte to determine whether the body exceeds the limit.
+	if int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+	n, err = b.ReadCloser.Read(p)
+	if int64(n) <= remaining {
+		b.read += int64(n)
+		return
+	}
+
+	b.read += remaining
+	b.exceeded = true
+	b.header.Set("Connection", "close")
+	return int(remaining), ErrRequestEntityTooLarge
+}
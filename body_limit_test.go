@@ -0,0 +1,125 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readBodyHandler(c *Context) error {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, headerContentTypeText, body)
+}
+
+func TestBodyLimit(t *testing.T) {
+	cases := []struct {
+		body          string
+		contentLength int64
+		code          int
+	}{
+		{"", 0, http.StatusOK},
+		{"foo", 3, http.StatusOK},
+		{"foobar", 6, http.StatusOK},
+		{"foobarx", 7, http.StatusRequestEntityTooLarge},
+		// unknown content length.
+		{"foobar", -1, http.StatusOK},
+		{"foobarx", -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range cases {
+		app := New()
+		app.Use(BodyLimit(6))
+		app.Post("/", readBodyHandler)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		req.ContentLength = test.contentLength
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.body)
+		if test.code == http.StatusRequestEntityTooLarge {
+			assert.Equal(t, "close", w.Header().Get("Connection"))
+		}
+	}
+}
+
+func TestBodyLimitPrecheck(t *testing.T) {
+	handled := false
+	handle := BodyLimit(3)(func(c *Context) error {
+		handled = true
+		return nil
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foobar"))
+	c := newContext(httptest.NewRecorder(), req)
+	assert.Equal(t, ErrRequestEntityTooLarge, handle(c))
+	assert.False(t, handled)
+}
+
+func TestBodyLimitOverride(t *testing.T) {
+	app := New()
+	app.Use(BodyLimit(3))
+	app.Post("/", readBodyHandler)
+	app.Post("/route", readBodyHandler, RouteBodyLimit(6))
+	api := app.Group("/api", RouteGroupBodyLimit(9))
+	api.Post("/", readBodyHandler)
+	api.Post("/route", readBodyHandler, RouteBodyLimit(1))
+	v1 := api.Group("/v1")
+	v1.Post("/", readBodyHandler)
+
+	cases := []struct {
+		path string
+		body string
+		code int
+	}{
+		{"/", "foo", http.StatusOK},
+		{"/", "foob", http.StatusRequestEntityTooLarge},
+		{"/route", "foobar", http.StatusOK},
+		{"/route", "foobarx", http.StatusRequestEntityTooLarge},
+		{"/api/", "foobarfiz", http.StatusOK},
+		{"/api/", "foobarfizz", http.StatusRequestEntityTooLarge},
+		{"/api/route", "f", http.StatusOK},
+		{"/api/route", "fo", http.StatusRequestEntityTooLarge},
+		{"/api/v1/", "foobarfiz", http.StatusOK},
+		{"/api/v1/", "foobarfizz", http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range cases {
+		for _, contentLength := range []int64{int64(len(test.body)), -1} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			req.ContentLength = contentLength
+			app.ServeHTTP(w, req)
+			assert.Equal(t, test.code, w.Code, test.path+" "+test.body)
+			if test.code == http.StatusOK {
+				assert.Equal(t, test.body, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	body := &limitedBody{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("foobar")),
+		header:     http.Header{},
+		limit:      4,
+	}
+	p := make([]byte, 3)
+	n, err := body.Read(p)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(p[:n]))
+
+	n, err = body.Read(p)
+	assert.Equal(t, ErrRequestEntityTooLarge, err)
+	assert.Equal(t, "b", string(p[:n]))
+
+	n, err = body.Read(p)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, ErrRequestEntityTooLarge, err)
+}
@@ -0,0 +1,445 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerAge       = "Age"
+	headerSetCookie = "Set-Cookie"
+
+	defaultCacheTTL = time.Minute
+
+	// defaultCacheMaxSize is the default limit of recorded response body.
+	defaultCacheMaxSize = 1 << 20
+)
+
+// CacheStore is an interface that stores cached responses.
+type CacheStore interface {
+	// Get returns the value of the given key, it returns nil value without error
+	// if the key does not exist or has expired.
+	Get(key string) ([]byte, error)
+
+	// Set sets the value of the given key that expires after the TTL.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete deletes the value of the given key.
+	Delete(key string) error
+}
+
+type cacheTTLKey struct{}
+
+// RouteCacheTTL is a route option that sets the TTL of cached responses of the
+// route, it takes precedence over the TTL of route group and middleware, zero or
+// negative TTL disables caching of the route, see Cache.
+func RouteCacheTTL(ttl time.Duration) RouteOption {
+	return RouteValue(cacheTTLKey{}, ttl)
+}
+
+// RouteGroupCacheTTL is a route group option that sets the TTL of cached responses
+// of the routes that belong to the group, see RouteCacheTTL.
+func RouteGroupCacheTTL(ttl time.Duration) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.routeOptions = append(r.routeOptions, RouteCacheTTL(ttl))
+	}
+}
+
+// CacheOption is a function that receives a cache middleware.
+type CacheOption func(*cache)
+
+// CacheTTL is an option that sets the default TTL of cached responses, defaults
+// to one minute.
+func CacheTTL(ttl time.Duration) CacheOption {
+	return func(m *cache) {
+		m.ttl = ttl
+	}
+}
+
+// CacheKey is an option that sets the function that returns the key of request,
+// defaults to the request URI. The method and route are always part of the key,
+// the function should include everything else that distinguishes responses, such
+// as the user ID of private resources.
+func CacheKey(f func(c *Context) string) CacheOption {
+	return func(m *cache) {
+		m.key = f
+	}
+}
+
+// CacheMaxSize is an option that sets the max size of response body that can be
+// cached, defaults to 1MB, the larger responses are written through without
+// being cached.
+func CacheMaxSize(size int64) CacheOption {
+	return func(m *cache) {
+		m.maxSize = size
+	}
+}
+
+// CacheSkipper is an option that sets skipper.
+func CacheSkipper(skipper Skipper) CacheOption {
+	return func(m *cache) {
+		m.skipper = skipper
+	}
+}
+
+// Cache returns a middleware that caches the full responses of GET requests in the
+// given store, the cached responses are also used for HEAD requests.
+//
+// Only the 200 responses are cached, responses that contain Set-Cookie header,
+// "Vary: *" or Cache-Control directives "no-store", "no-cache" and "private" are
+// never cached, as well as the responses of requests that contain Authorization or
+// Cookie header, unless the response contains "public" or "s-maxage" directive, see
+// RFC 7234 section 3.2. The "s-maxage" and "max-age" directives of response take precedence
+// over the TTL, see CacheTTL and RouteCacheTTL. The requests that contain "no-store"
+// directive bypass the cache, and "no-cache" or "max-age" directives of requests
+// force the response to be regenerated if the cached one is stale.
+//
+// The concurrent requests of the same key that missed the cache are coalesced, only
+// one of them calls the handler, and others wait for and share its response, the
+// waiting requests give up once they are canceled.
+func Cache(store CacheStore, opts ...CacheOption) MiddlewareFunc {
+	m := &cache{
+		store:   store,
+		ttl:     defaultCacheTTL,
+		maxSize: defaultCacheMaxSize,
+		key: func(c *Context) string {
+			return c.Request.URL.RequestURI()
+		},
+		calls: make(map[string]*cacheCall),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.middleware
+}
+
+type cache struct {
+	store   CacheStore
+	ttl     time.Duration
+	maxSize int64
+	key     func(c *Context) string
+	skipper Skipper
+	now     func() time.Time
+
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// cacheCall is an in-flight request that fills the cache.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+}
+
+type cacheEntry struct {
+	Status  int               `json:"status"`
+	Header  http.Header       `json:"header"`
+	Body    []byte            `json:"body"`
+	Vary    map[string]string `json:"vary,omitempty"`
+	Created int64             `json:"created"`
+	Expires int64             `json:"expires"`
+}
+
+func (m *cache) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+		method := c.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			return next(c)
+		}
+		route, _ := lookupRoute(c)
+		ttl := m.ttl
+		if route != nil {
+			if v, ok := route.Value(cacheTTLKey{}).(time.Duration); ok {
+				ttl = v
+			}
+		}
+		directives := parseCacheControl(c.Request.Header.Get(headerCacheControl))
+		if _, ok := directives["no-store"]; ok || ttl <= 0 {
+			return next(c)
+		}
+
+		key := http.MethodGet + " "
+		if route != nil {
+			key += route.path + " "
+		}
+		key += m.key(c)
+
+		now := m.now()
+		entry, err := m.get(key)
+		if err != nil {
+			return err
+		}
+		if entry != nil && m.acceptable(c.Request, directives, entry, now) {
+			return m.write(c, entry, now)
+		}
+		if method == http.MethodHead {
+			return next(c)
+		}
+
+		m.mu.Lock()
+		if call, ok := m.calls[key]; ok {
+			m.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-c.Request.Context().Done():
+				return c.Request.Context().Err()
+			}
+			// the response of leader might vary on the headers of request.
+			if now = m.now(); call.entry != nil && m.acceptable(c.Request, directives, call.entry, now) {
+				return m.write(c, call.entry, now)
+			}
+			return next(c)
+		}
+		call := &cacheCall{done: make(chan struct{})}
+		m.calls[key] = call
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.calls, key)
+			m.mu.Unlock()
+			close(call.done)
+		}()
+
+		call.entry, err = m.fill(c, next, key, ttl)
+		return err
+	}
+}
+
+func (m *cache) get(key string) (*cacheEntry, error) {
+	data, err := m.store.Get(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		// treats the corrupted entry as missing.
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// acceptable reports whether the cached entry is fresh and satisfies the request.
+func (m *cache) acceptable(r *http.Request, directives map[string]string, entry *cacheEntry, now time.Time) bool {
+	if now.UnixNano() >= entry.Expires {
+		return false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return false
+	}
+	if v, ok := directives["max-age"]; ok {
+		maxAge, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || now.Sub(time.Unix(0, entry.Created)) > time.Duration(maxAge)*time.Second {
+			return false
+		}
+	}
+	return entry.matchVary(r)
+}
+
+// matchVary reports whether the request has the same values of the headers that
+// the entry varies on.
+func (e *cacheEntry) matchVary(r *http.Request) bool {
+	for name, value := range e.Vary {
+		if r.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// varyValues returns the values of the request headers that the response varies
+// on, ok is false if the response varies on "*".
+func varyValues(header http.Header, r *http.Request) (values map[string]string, ok bool) {
+	for _, value := range header[headerVary] {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[name] = r.Header.Get(name)
+		}
+	}
+	return values, true
+}
+
+func (m *cache) write(c *Context, entry *cacheEntry, now time.Time) error {
+	c.SetHeader(headerAge, strconv.FormatInt(int64(now.Sub(time.Unix(0, entry.Created))/time.Second), 10))
+	return replayEntry(c, entry)
+}
+
+// fill calls the handler and stores the response if it is cacheable, the stored
+// entry will be returned.
+func (m *cache) fill(c *Context, next Handle, key string, ttl time.Duration) (*cacheEntry, error) {
+	w := newCacheWriter(c.Response, m.maxSize)
+	snapshot := cloneHeader(c.Response.Header())
+	defer func(resp http.ResponseWriter) {
+		c.Response = resp
+	}(c.Response)
+	c.Response = w
+
+	if err := next(c); err != nil {
+		return nil, err
+	}
+	if !w.wroteHeader || w.hijacked || w.exceeded || w.statusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	header := w.Header()
+	if header.Get(headerSetCookie) != "" {
+		return nil, nil
+	}
+	directives := parseCacheControl(header.Get(headerCacheControl))
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[name]; ok {
+			return nil, nil
+		}
+	}
+	if isCredentialed(c.Request) {
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+		if !public && !sMaxAge {
+			return nil, nil
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[name]; ok {
+			maxAge, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, nil
+			}
+			ttl = time.Duration(maxAge) * time.Second
+			break
+		}
+	}
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	vary, ok := varyValues(header, c.Request)
+	if !ok {
+		return nil, nil
+	}
+
+	now := m.now()
+	entry := &cacheEntry{
+		Status:  w.statusCode,
+		Header:  headerDiff(snapshot, header),
+		Body:    w.buf.Bytes(),
+		Vary:    vary,
+		Created: now.UnixNano(),
+		Expires: now.Add(ttl).UnixNano(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.store.Set(key, data, ttl); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// isCredentialed reports whether the request contains credentials.
+func isCredentialed(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// cacheWriter writes the response through and records it, it is shared by Cache,
+// Coalesce and Idempotency. The body is no longer recorded once its size exceeds
+// the limit.
+type cacheWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	limit       int64
+	exceeded    bool
+	statusCode  int
+	wroteHeader bool
+	hijacked    bool
+}
+
+func newCacheWriter(w http.ResponseWriter, limit int64) *cacheWriter {
+	return &cacheWriter{ResponseWriter: w, limit: limit, statusCode: http.StatusOK}
+}
+
+func (w *cacheWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.statusCode = statusCode
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if !w.exceeded {
+		if int64(w.buf.Len()+n) > w.limit {
+			w.exceeded = true
+			w.buf = bytes.Buffer{}
+		} else {
+			w.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *cacheWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, the hijacked responses are never cached.
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.hijacked = true
+		return h.Hijack()
+	}
+	return nil, nil, errHijackerNotSupported
+}
+
+// Unwrap returns the original http.ResponseWriter.
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// parseCacheControl parses the directives of Cache-Control header, the names are
+// lower-cased, and the quotes of values are removed.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		var value string
+		if i := strings.IndexByte(directive, '='); i >= 0 {
+			directive, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(directive))] = value
+	}
+	return directives
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRUCacheStore is an in-memory cache store that evicts the least recently used
+// entries once the total size of keys and values exceeds the limit, expired
+// entries are evicted on access or by GC.
+type LRUCacheStore struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List
+	items   map[string]*list.Element
+	now     func() time.Time
+}
+
+type lruCacheItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (item *lruCacheItem) size() int64 {
+	return int64(len(item.key) + len(item.value))
+}
+
+// NewLRUCacheStore returns a LRU cache store whose size of keys and values is
+// limited to the given number of bytes.
+func NewLRUCacheStore(maxSize int64) *LRUCacheStore {
+	return &LRUCacheStore{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get implements CacheStore.Get.
+func (s *LRUCacheStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := e.Value.(*lruCacheItem)
+	if !s.now().Before(item.expires) {
+		s.remove(e)
+		return nil, nil
+	}
+	s.ll.MoveToFront(e)
+	return item.value, nil
+}
+
+// Set implements CacheStore.Set, the values that are larger than the limit are
+// discarded.
+func (s *LRUCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	item := &lruCacheItem{
+		key:     key,
+		value:   append([]byte(nil), value...),
+		expires: s.now().Add(ttl),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+	if item.size() > s.maxSize {
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(item)
+	s.size += item.size()
+	for s.size > s.maxSize {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+// Delete implements CacheStore.Delete.
+func (s *LRUCacheStore) Delete(key string) error {
+	s.mu.Lock()
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Len returns the number of entries, including the expired entries that have not
+// been evicted.
+func (s *LRUCacheStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+// GC evicts expired entries.
+func (s *LRUCacheStore) GC() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for e := s.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if !now.Before(e.Value.(*lruCacheItem).expires) {
+			s.remove(e)
+		}
+		e = prev
+	}
+}
+
+func (s *LRUCacheStore) remove(e *list.Element) {
+	item := s.ll.Remove(e).(*lruCacheItem)
+	delete(s.items, item.key)
+	s.size -= item.size()
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCacheStore(t *testing.T) {
+	now := time.Now()
+	store := NewLRUCacheStore(10)
+	store.now = func() time.Time { return now }
+
+	value, err := store.Get("a")
+	assert.Nil(t, err)
+	assert.Nil(t, value)
+
+	assert.Nil(t, store.Set("a", []byte("foo"), time.Minute))
+	assert.Nil(t, store.Set("b", []byte("bar"), time.Minute))
+	assert.Equal(t, int64(8), store.size)
+	value, _ = store.Get("a")
+	assert.Equal(t, "foo", string(value))
+
+	// "b" is the least recently used entry.
+	assert.Nil(t, store.Set("c", []byte("baz"), time.Minute))
+	assert.Equal(t, 2, store.Len())
+	value, _ = store.Get("b")
+	assert.Nil(t, value)
+	value, _ = store.Get("a")
+	assert.Equal(t, "foo", string(value))
+
+	// replaces the existing entry.
+	assert.Nil(t, store.Set("a", []byte("qux"), time.Minute))
+	assert.Equal(t, int64(8), store.size)
+	value, _ = store.Get("a")
+	assert.Equal(t, "qux", string(value))
+
+	// the values that are larger than the limit are discarded.
+	assert.Nil(t, store.Set("d", []byte("0123456789"), time.Minute))
+	value, _ = store.Get("d")
+	assert.Nil(t, value)
+	assert.Equal(t, 2, store.Len())
+
+	assert.Nil(t, store.Delete("a"))
+	assert.Nil(t, store.Delete("a"))
+	value, _ = store.Get("a")
+	assert.Nil(t, value)
+	assert.Equal(t, int64(4), store.size)
+}
+
+func TestLRUCacheStoreExpiration(t *testing.T) {
+	now := time.Now()
+	store := NewLRUCacheStore(100)
+	store.now = func() time.Time { return now }
+	assert.Nil(t, store.Set("a", []byte("foo"), time.Minute))
+	assert.Nil(t, store.Set("b", []byte("bar"), time.Hour))
+	assert.Nil(t, store.Set("c", []byte("baz"), 2*time.Minute))
+
+	now = now.Add(time.Minute)
+	value, _ := store.Get("a")
+	assert.Nil(t, value)
+	assert.Equal(t, 2, store.Len())
+
+	now = now.Add(time.Minute)
+	store.GC()
+	assert.Equal(t, 1, store.Len())
+	assert.Equal(t, int64(4), store.size)
+	value, _ = store.Get("b")
+	assert.Equal(t, "bar", string(value))
+}
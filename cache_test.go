@@ -0,0 +1,385 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"clevergo.tech/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	assert.Equal(t, map[string]string{}, parseCacheControl(""))
+	assert.Equal(t, map[string]string{
+		"public":   "",
+		"max-age":  "60",
+		"no-cache": "Set-Cookie",
+	}, parseCacheControl(`Public, max-age=60,, no-cache="Set-Cookie"`))
+}
+
+type cacheTestServer struct {
+	app   *Application
+	store *LRUCacheStore
+	calls int32
+}
+
+func newCacheTestServer(opts ...CacheOption) *cacheTestServer {
+	s := &cacheTestServer{app: Pure(), store: NewLRUCacheStore(1 << 20)}
+	s.app.Use(ErrorHandler(), Cache(s.store, append([]CacheOption{CacheMaxSize(1024)}, opts...)...))
+	handle := func(c *Context) error {
+		n := atomic.AddInt32(&s.calls, 1)
+		if v := c.QueryParam("cache-control"); v != "" {
+			c.SetHeader("Cache-Control", v)
+		}
+		if v := c.QueryParam("vary"); v != "" {
+			c.SetHeader("Vary", v)
+		}
+		if c.QueryParam("cookie") != "" {
+			c.SetCookie(&http.Cookie{Name: "foo", Value: "bar"})
+		}
+		code := http.StatusOK
+		if v := c.QueryParam("code"); v != "" {
+			code, _ = strconv.Atoi(v)
+		}
+		if c.QueryParam("error") != "" {
+			return errors.New("error")
+		}
+		if v := c.QueryParam("size"); v != "" {
+			size, _ := strconv.Atoi(v)
+			return c.String(code, strings.Repeat("a", size))
+		}
+		c.SetHeader("X-Lang", c.GetHeader("Accept-Language"))
+		return c.String(code, "response "+strconv.Itoa(int(n)))
+	}
+	s.app.Get("/", handle)
+	s.app.Head("/", handle)
+	s.app.Post("/", handle)
+	s.app.Get("/short", handle, RouteCacheTTL(time.Second))
+	s.app.Get("/nocache", handle, RouteCacheTTL(0))
+	return s
+}
+
+func (s *cacheTestServer) do(method, path string, header map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	s.app.ServeHTTP(w, req)
+	return w
+}
+
+func TestCache(t *testing.T) {
+	cases := []struct {
+		path     string
+		header   map[string]string
+		cached   bool
+		cachedOK bool
+	}{
+		{"/", nil, true, true},
+		{"/?foo=bar", nil, true, true},
+		{"/nocache", nil, false, false},
+		{"/?code=201", nil, false, false},
+		{"/?code=404", nil, false, false},
+		{"/?error=1", nil, false, false},
+		{"/?cookie=1", nil, false, false},
+		{"/?cache-control=no-store", nil, false, false},
+		{"/?cache-control=no-cache", nil, false, false},
+		{"/?cache-control=private,max-age=60", nil, false, false},
+		{"/?cache-control=max-age=0", nil, false, false},
+		{"/?cache-control=public,max-age=60", nil, true, true},
+		{"/?vary=*", nil, false, false},
+		{"/", map[string]string{"Authorization": "Bearer foo"}, false, false},
+		{"/", map[string]string{"Cookie": "session=foo"}, false, false},
+		{"/?cache-control=public", map[string]string{"Cookie": "session=foo"}, true, true},
+		{"/?cache-control=s-maxage=60", map[string]string{"Authorization": "Bearer foo"}, true, true},
+		{"/?size=1024", nil, true, true},
+		{"/?size=1025", nil, false, false},
+		{"/", map[string]string{"Cache-Control": "no-store"}, false, false},
+		// the response is regenerated, but stored for subsequent requests.
+		{"/", map[string]string{"Cache-Control": "no-cache"}, true, false},
+	}
+	for _, test := range cases {
+		s := newCacheTestServer()
+		w1 := s.do(http.MethodGet, test.path, test.header)
+		w2 := s.do(http.MethodGet, test.path, test.header)
+		w3 := s.do(http.MethodGet, test.path, nil)
+		if test.cachedOK {
+			assert.Equal(t, int32(1), s.calls, test.path)
+			assert.Equal(t, w1.Body.String(), w2.Body.String(), test.path)
+			assert.Equal(t, w1.Code, w2.Code)
+			assert.Equal(t, w1.Header().Get("Content-Type"), w2.Header().Get("Content-Type"))
+			assert.Equal(t, "", w1.Header().Get("Age"))
+			assert.Equal(t, "0", w2.Header().Get("Age"))
+		} else if test.cached {
+			assert.Equal(t, int32(2), s.calls, test.path)
+			assert.Equal(t, w2.Body.String(), w3.Body.String(), test.path)
+		} else {
+			assert.Equal(t, int32(3), s.calls, test.path)
+			assert.Equal(t, "", w3.Header().Get("Age"))
+		}
+	}
+}
+
+func TestCacheMethods(t *testing.T) {
+	s := newCacheTestServer()
+
+	// HEAD does not fill the cache.
+	w := s.do(http.MethodHead, "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), s.calls)
+
+	s.do(http.MethodGet, "/", nil)
+	assert.Equal(t, int32(2), s.calls)
+	w = s.do(http.MethodHead, "/", nil)
+	assert.Equal(t, int32(2), s.calls)
+	assert.Equal(t, "", w.Body.String())
+	assert.Equal(t, "0", w.Header().Get("Age"))
+
+	w = s.do(http.MethodPost, "/", nil)
+	assert.Equal(t, int32(3), s.calls)
+	assert.Equal(t, "response 3", w.Body.String())
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Now()
+	s := newCacheTestServer(CacheTTL(time.Hour), func(m *cache) {
+		m.now = func() time.Time { return now }
+	})
+	s.store.now = func() time.Time { return now }
+
+	s.do(http.MethodGet, "/", nil)
+	s.do(http.MethodGet, "/short", nil)
+	s.do(http.MethodGet, "/?cache-control=max-age=120", nil)
+	assert.Equal(t, int32(3), s.calls)
+
+	now = now.Add(time.Minute)
+	w := s.do(http.MethodGet, "/", nil)
+	assert.Equal(t, "60", w.Header().Get("Age"))
+	s.do(http.MethodGet, "/short", nil)
+	s.do(http.MethodGet, "/?cache-control=max-age=120", nil)
+	assert.Equal(t, int32(4), s.calls)
+
+	// max-age directive of request.
+	w = s.do(http.MethodGet, "/", map[string]string{"Cache-Control": "max-age=30"})
+	assert.Equal(t, int32(5), s.calls)
+	assert.Equal(t, "", w.Header().Get("Age"))
+
+	now = now.Add(time.Minute)
+	s.do(http.MethodGet, "/?cache-control=max-age=120", nil)
+	assert.Equal(t, int32(6), s.calls)
+}
+
+func TestCacheVary(t *testing.T) {
+	s := newCacheTestServer()
+	en := map[string]string{"Accept-Language": "en"}
+	fr := map[string]string{"Accept-Language": "fr"}
+	w := s.do(http.MethodGet, "/?vary=Accept-Language", en)
+	assert.Equal(t, "en", w.Header().Get("X-Lang"))
+	w = s.do(http.MethodGet, "/?vary=Accept-Language", en)
+	assert.Equal(t, int32(1), s.calls)
+
+	w = s.do(http.MethodGet, "/?vary=Accept-Language", fr)
+	assert.Equal(t, int32(2), s.calls)
+	assert.Equal(t, "fr", w.Header().Get("X-Lang"))
+	w = s.do(http.MethodGet, "/?vary=Accept-Language", fr)
+	assert.Equal(t, int32(2), s.calls)
+	assert.Equal(t, "fr", w.Header().Get("X-Lang"))
+}
+
+func TestCacheKeyAndSkipper(t *testing.T) {
+	s := newCacheTestServer(
+		CacheKey(func(c *Context) string { return c.GetHeader("X-User") }),
+		CacheSkipper(func(c *Context) bool { return c.QueryParam("skip") != "" }),
+	)
+	s.do(http.MethodGet, "/?page=1", map[string]string{"X-User": "foo"})
+	w := s.do(http.MethodGet, "/?page=2", map[string]string{"X-User": "foo"})
+	assert.Equal(t, "response 1", w.Body.String())
+	w = s.do(http.MethodGet, "/", map[string]string{"X-User": "bar"})
+	assert.Equal(t, "response 2", w.Body.String())
+	w = s.do(http.MethodGet, "/?skip=1", map[string]string{"X-User": "foo"})
+	assert.Equal(t, "response 3", w.Body.String())
+
+	// the route is part of the key.
+	w = s.do(http.MethodGet, "/short", map[string]string{"X-User": "foo"})
+	assert.Equal(t, "response 4", w.Body.String())
+}
+
+type errorCacheStore struct {
+	*LRUCacheStore
+	err error
+}
+
+func (s errorCacheStore) Get(key string) ([]byte, error) {
+	return nil, s.err
+}
+
+func TestCacheStoreError(t *testing.T) {
+	app := Pure()
+	app.Use(ErrorHandler(), Cache(errorCacheStore{NewLRUCacheStore(100), errors.New("store error")}))
+	app.Get("/", echoHandler("foo"))
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// corrupted entries are treated as missing.
+	store := NewLRUCacheStore(1 << 10)
+	store.Set("GET / /", []byte("corrupted"), time.Minute)
+	app = Pure()
+	app.Use(Cache(store))
+	app.Get("/", echoHandler("foo"))
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "foo", w.Body.String())
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	app := Pure()
+	app.Use(Cache(NewLRUCacheStore(1 << 20)))
+	app.Get("/", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return c.String(http.StatusOK, "foo")
+	})
+	app.Get("/uncacheable", func(c *Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		c.SetHeader("Cache-Control", "no-store")
+		return c.String(http.StatusOK, "foo")
+	})
+
+	for _, path := range []string{"/", "/uncacheable"} {
+		atomic.StoreInt32(&calls, 0)
+		release = make(chan struct{})
+		var wg sync.WaitGroup
+		bodies := make([]string, 5)
+		for i := range bodies {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				bodies[i] = w.Body.String()
+			}(i)
+		}
+		// waits for the leader.
+		for atomic.LoadInt32(&calls) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		for _, body := range bodies {
+			assert.Equal(t, "foo", body)
+		}
+		if path == "/" {
+			assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		} else {
+			assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+		}
+	}
+}
+
+func TestCacheCoalescingVary(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	app := Pure()
+	app.Use(Cache(NewLRUCacheStore(1 << 20)))
+	app.Get("/", func(c *Context) error {
+		lang := c.GetHeader("Accept-Language")
+		if lang == "en" {
+			close(started)
+			<-release
+		}
+		c.SetHeader("Vary", "Accept-Language")
+		return c.String(http.StatusOK, "lang="+lang)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", "en")
+		app.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	body := make(chan string)
+	go func() {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Language", "fr")
+		app.ServeHTTP(w, req)
+		body <- w.Body.String()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.Equal(t, "lang=fr", <-body)
+	<-done
+}
+
+func TestCacheCanceledWaiter(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	app := Pure()
+	app.Logger = log.New(ioutil.Discard, "", 0)
+	app.Use(Cache(NewLRUCacheStore(1 << 20)))
+	app.Get("/", func(c *Context) error {
+		close(started)
+		<-release
+		return c.String(http.StatusOK, "foo")
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-started
+
+	// the waiter gives up without waiting for the leader.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	close(release)
+	<-done
+}
+
+func TestCacheExcludesHeadersOfOtherMiddlewares(t *testing.T) {
+	var ids int32
+	app := Pure()
+	app.Use(func(next Handle) Handle {
+		return func(c *Context) error {
+			c.SetHeader("X-Request-ID", strconv.Itoa(int(atomic.AddInt32(&ids, 1))))
+			return next(c)
+		}
+	}, Cache(NewLRUCacheStore(1<<20)))
+	app.Get("/", func(c *Context) error {
+		c.SetHeader("X-Foo", "bar")
+		return c.String(http.StatusOK, "foo")
+	})
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, strconv.Itoa(i), w.Header().Get("X-Request-ID"))
+		assert.Equal(t, "bar", w.Header().Get("X-Foo"))
+	}
+}
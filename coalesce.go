@@ -0,0 +1,247 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type coalesceKey struct{}
+
+// RouteCoalesce is a route option that indicates whether to coalesce the requests
+// of the route, it takes precedence over the route group, see Coalesce.
+func RouteCoalesce(enabled bool) RouteOption {
+	return RouteValue(coalesceKey{}, enabled)
+}
+
+// RouteGroupCoalesce is a route group option that indicates whether to coalesce
+// the requests of the routes that belong to the group, see RouteCoalesce.
+func RouteGroupCoalesce(enabled bool) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.routeOptions = append(r.routeOptions, RouteCoalesce(enabled))
+	}
+}
+
+// CoalesceOption is a function that receives a coalescing middleware.
+type CoalesceOption func(*coalescer)
+
+// CoalesceKey is an option that sets the function that returns the key of request,
+// defaults to the method, route, parameters and query.
+func CoalesceKey(f func(c *Context) string) CoalesceOption {
+	return func(m *coalescer) {
+		m.key = f
+	}
+}
+
+// CoalesceHeaders is an option that sets the request headers that are part of the
+// key, defaults to Authorization and Cookie, so that the requests of different
+// users are never coalesced.
+func CoalesceHeaders(names ...string) CoalesceOption {
+	return func(m *coalescer) {
+		m.headers = names
+	}
+}
+
+// CoalesceSkipper is an option that sets skipper.
+func CoalesceSkipper(skipper Skipper) CoalesceOption {
+	return func(m *coalescer) {
+		m.skipper = skipper
+	}
+}
+
+// Coalesce returns a middleware that deduplicates the concurrent GET and HEAD requests
+// of the same key, only one of them calls the handler, and its response is replayed
+// to others. It protects backends from the bursts of identical requests, such as the
+// requests of a hot resource whose cache expired.
+//
+// Only the headers that were set by the handler are replayed, the responses that
+// contain Set-Cookie header are never replayed, the waiting requests call the handler
+// by themselves instead, as well as the hijacked responses, the responses whose body
+// is larger than 1MB and the requests whose leading request was canceled. The
+// responses are replayed only to the requests that have the same values of the
+// headers listed in the Vary header, and never if it is "*". The error returned by
+// the handler is shared.
+//
+// Coalescing can be disabled per route, see RouteCoalesce.
+func Coalesce(opts ...CoalesceOption) MiddlewareFunc {
+	m := &coalescer{
+		headers: []string{"Authorization", "Cookie"},
+		calls:   make(map[string]*coalesceCall),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.middleware
+}
+
+type coalescer struct {
+	key     func(c *Context) string
+	headers []string
+	skipper Skipper
+
+	mu    sync.Mutex
+	calls map[string]*coalesceCall
+}
+
+// coalesceCall is an in-flight request whose response will be shared.
+type coalesceCall struct {
+	done   chan struct{}
+	shared bool
+	entry  *cacheEntry
+	err    error
+}
+
+func (m *coalescer) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return next(c)
+		}
+		route, params := lookupRoute(c)
+		if route != nil {
+			if enabled, ok := route.Value(coalesceKey{}).(bool); ok && !enabled {
+				return next(c)
+			}
+		}
+		key := m.requestKey(c, route, params)
+
+		m.mu.Lock()
+		if call, ok := m.calls[key]; ok {
+			m.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-c.Request.Context().Done():
+				return c.Request.Context().Err()
+			}
+			// the response of leader might vary on the headers of request.
+			if !call.shared || (call.entry != nil && !call.entry.matchVary(c.Request)) {
+				return next(c)
+			}
+			if call.entry != nil {
+				if err := replayEntry(c, call.entry); err != nil {
+					return err
+				}
+			}
+			return call.err
+		}
+		call := &coalesceCall{done: make(chan struct{})}
+		m.calls[key] = call
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.calls, key)
+			m.mu.Unlock()
+			close(call.done)
+		}()
+
+		w := newCacheWriter(c.Response, defaultCacheMaxSize)
+		header := cloneHeader(c.Response.Header())
+		defer func(resp http.ResponseWriter) {
+			c.Response = resp
+		}(c.Response)
+		c.Response = w
+
+		err := next(c)
+		if w.hijacked || w.exceeded || c.Request.Context().Err() != nil || w.Header().Get(headerSetCookie) != "" {
+			return err
+		}
+		vary, ok := varyValues(w.Header(), c.Request)
+		if !ok {
+			return err
+		}
+		call.shared = true
+		call.err = err
+		if w.wroteHeader {
+			call.entry = &cacheEntry{
+				Status: w.statusCode,
+				Header: headerDiff(header, w.Header()),
+				Body:   w.buf.Bytes(),
+				Vary:   vary,
+			}
+		}
+		return err
+	}
+}
+
+func (m *coalescer) requestKey(c *Context, route *Route, params Params) string {
+	var b strings.Builder
+	b.WriteString(c.Request.Method)
+	b.WriteByte(' ')
+	if m.key != nil {
+		b.WriteString(m.key(c))
+	} else {
+		if route != nil {
+			b.WriteString(route.path)
+		} else {
+			b.WriteString(c.Request.URL.Path)
+		}
+		for _, param := range params {
+			b.WriteByte(' ')
+			b.WriteString(param.Key)
+			b.WriteByte('=')
+			b.WriteString(param.Value)
+		}
+		b.WriteByte('?')
+		b.WriteString(c.Request.URL.RawQuery)
+	}
+	for _, name := range m.headers {
+		b.WriteByte('\n')
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(c.Request.Header[http.CanonicalHeaderKey(name)], ","))
+	}
+	return b.String()
+}
+
+// replayEntry writes the recorded response.
+func replayEntry(c *Context, entry *cacheEntry) error {
+	header := c.Response.Header()
+	for name, values := range entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	c.Response.WriteHeader(entry.Status)
+	if c.Request.Method == http.MethodHead {
+		return nil
+	}
+	_, err := c.Response.Write(entry.Body)
+	return err
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for name, values := range header {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// headerDiff returns the headers that were added or changed since the snapshot,
+// the headers set by other middlewares, such as request ID, are excluded.
+func headerDiff(snapshot, header http.Header) http.Header {
+	diff := make(http.Header)
+	for name, values := range header {
+		if old, ok := snapshot[name]; ok && equalStrings(old, values) {
+			continue
+		}
+		diff[name] = append([]string(nil), values...)
+	}
+	return diff
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type coalesceTestServer struct {
+	app     *Application
+	calls   int32
+	release chan struct{}
+	ids     int32
+}
+
+func newCoalesceTestServer(opts ...CoalesceOption) *coalesceTestServer {
+	s := &coalesceTestServer{app: Pure(), release: make(chan struct{})}
+	s.app.Use(ErrorHandler(), func(next Handle) Handle {
+		return func(c *Context) error {
+			c.SetHeader("X-Request-ID", strconv.Itoa(int(atomic.AddInt32(&s.ids, 1))))
+			return next(c)
+		}
+	}, Coalesce(opts...))
+	handle := func(c *Context) error {
+		n := atomic.AddInt32(&s.calls, 1)
+		<-s.release
+		if c.QueryParam("cookie") != "" {
+			c.SetCookie(&http.Cookie{Name: "session", Value: strconv.Itoa(int(n))})
+		}
+		if c.QueryParam("error") != "" {
+			return errors.New("error")
+		}
+		if vary := c.QueryParam("vary"); vary != "" {
+			c.SetHeader("Vary", vary)
+			c.SetHeader("X-Lang", c.GetHeader("Accept-Language"))
+		}
+		c.SetHeader("X-Call", strconv.Itoa(int(n)))
+		return c.String(http.StatusOK, "response "+c.Params.String("id"))
+	}
+	s.app.Get("/users/:id", handle)
+	s.app.Head("/users/:id", handle)
+	s.app.Post("/users/:id", handle)
+	s.app.Get("/uncoalesced/:id", handle, RouteCoalesce(false))
+	return s
+}
+
+// do sends the requests concurrently, and releases the handlers once the first one
+// has been called.
+func (s *coalesceTestServer) do(reqs ...*http.Request) []*httptest.ResponseRecorder {
+	ws := make([]*httptest.ResponseRecorder, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		ws[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			s.app.ServeHTTP(w, req)
+		}(ws[i], req)
+	}
+	for atomic.LoadInt32(&s.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(s.release)
+	wg.Wait()
+	return ws
+}
+
+func newCoalesceRequests(n int, method, path string, header map[string]string) []*http.Request {
+	reqs := make([]*http.Request, n)
+	for i := range reqs {
+		reqs[i] = httptest.NewRequest(method, path, nil)
+		for name, value := range header {
+			reqs[i].Header.Set(name, value)
+		}
+	}
+	return reqs
+}
+
+func TestCoalesce(t *testing.T) {
+	s := newCoalesceTestServer()
+	ws := s.do(newCoalesceRequests(5, http.MethodGet, "/users/1?foo=bar", nil)...)
+	assert.Equal(t, int32(1), s.calls)
+	ids := make(map[string]bool)
+	for _, w := range ws {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "response 1", w.Body.String())
+		assert.Equal(t, "1", w.Header().Get("X-Call"))
+		assert.Equal(t, headerContentTypeText, w.Header().Get("Content-Type"))
+		ids[w.Header().Get("X-Request-ID")] = true
+	}
+	// the headers of other middlewares are not replayed.
+	assert.Len(t, ids, 5)
+}
+
+func TestCoalesceKeys(t *testing.T) {
+	cases := []struct {
+		opts  []CoalesceOption
+		reqs  []*http.Request
+		calls int32
+	}{
+		{nil, append(newCoalesceRequests(2, http.MethodGet, "/users/1", nil), newCoalesceRequests(2, http.MethodGet, "/users/2", nil)...), 2},
+		{nil, append(newCoalesceRequests(2, http.MethodGet, "/users/1?page=1", nil), newCoalesceRequests(2, http.MethodGet, "/users/1?page=2", nil)...), 2},
+		{nil, append(newCoalesceRequests(2, http.MethodGet, "/users/1", nil), newCoalesceRequests(2, http.MethodHead, "/users/1", nil)...), 2},
+		{nil, newCoalesceRequests(3, http.MethodPost, "/users/1", nil), 3},
+		{nil, newCoalesceRequests(3, http.MethodGet, "/uncoalesced/1", nil), 3},
+		{
+			nil,
+			append(
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Authorization": "Bearer foo"}),
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Authorization": "Bearer bar"})...,
+			),
+			2,
+		},
+		{
+			nil,
+			append(
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Cookie": "session=foo"}),
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Cookie": "session=bar"})...,
+			),
+			2,
+		},
+		{
+			[]CoalesceOption{CoalesceHeaders()},
+			append(
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Authorization": "Bearer foo"}),
+				newCoalesceRequests(2, http.MethodGet, "/users/1", map[string]string{"Authorization": "Bearer bar"})...,
+			),
+			1,
+		},
+		{
+			[]CoalesceOption{CoalesceKey(func(c *Context) string { return c.Request.URL.Path })},
+			append(newCoalesceRequests(2, http.MethodGet, "/users/1?page=1", nil), newCoalesceRequests(2, http.MethodGet, "/users/1?page=2", nil)...),
+			1,
+		},
+		{
+			[]CoalesceOption{CoalesceSkipper(func(c *Context) bool { return true })},
+			newCoalesceRequests(3, http.MethodGet, "/users/1", nil),
+			3,
+		},
+	}
+	for i, test := range cases {
+		s := newCoalesceTestServer(test.opts...)
+		s.do(test.reqs...)
+		assert.Equal(t, test.calls, s.calls, i)
+	}
+}
+
+func TestCoalesceHead(t *testing.T) {
+	s := newCoalesceTestServer()
+	ws := s.do(newCoalesceRequests(3, http.MethodHead, "/users/1", nil)...)
+	assert.Equal(t, int32(1), s.calls)
+	for _, w := range ws {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "1", w.Header().Get("X-Call"))
+	}
+}
+
+func TestCoalesceSetCookie(t *testing.T) {
+	s := newCoalesceTestServer()
+	ws := s.do(newCoalesceRequests(3, http.MethodGet, "/users/1?cookie=1", nil)...)
+	assert.Equal(t, int32(3), s.calls)
+	sessions := make(map[string]bool)
+	for _, w := range ws {
+		sessions[w.Header().Get("Set-Cookie")] = true
+	}
+	assert.Len(t, sessions, 3)
+}
+
+func TestCoalesceVary(t *testing.T) {
+	cases := []struct {
+		vary  string
+		calls int32
+	}{
+		// the waiters whose language differs from the leader's call the handler.
+		{"Accept-Language", 3},
+		{"*", 4},
+	}
+	for _, test := range cases {
+		s := newCoalesceTestServer()
+		path := "/users/1?vary=" + test.vary
+		en := newCoalesceRequests(2, http.MethodGet, path, map[string]string{"Accept-Language": "en"})
+		fr := newCoalesceRequests(2, http.MethodGet, path, map[string]string{"Accept-Language": "fr"})
+		ws := s.do(append(en, fr...)...)
+		assert.Equal(t, test.calls, s.calls, test.vary)
+		for i, w := range ws {
+			lang := "en"
+			if i >= len(en) {
+				lang = "fr"
+			}
+			assert.Equal(t, lang, w.Header().Get("X-Lang"), test.vary)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+	}
+}
+
+func TestCoalesceError(t *testing.T) {
+	s := newCoalesceTestServer()
+	ws := s.do(newCoalesceRequests(3, http.MethodGet, "/users/1?error=1", nil)...)
+	assert.Equal(t, int32(1), s.calls)
+	for _, w := range ws {
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestCoalesceCanceled(t *testing.T) {
+	s := newCoalesceTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reqs := newCoalesceRequests(3, http.MethodGet, "/users/1", nil)
+	reqs[0] = reqs[0].WithContext(ctx)
+	ws := make([]*httptest.ResponseRecorder, len(reqs))
+	var wg sync.WaitGroup
+	serve := func(i int) {
+		ws[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.app.ServeHTTP(ws[i], reqs[i])
+		}()
+	}
+	// the canceled request leads.
+	serve(0)
+	for atomic.LoadInt32(&s.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	serve(1)
+	serve(2)
+	time.Sleep(50 * time.Millisecond)
+	close(s.release)
+	wg.Wait()
+	// the followers call the handler by themselves.
+	assert.Equal(t, int32(3), s.calls)
+	for _, w := range ws[1:] {
+		assert.Equal(t, "response 1", w.Body.String())
+	}
+}
+
+func TestCoalesceCanceledWaiter(t *testing.T) {
+	s := newCoalesceTestServer()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	}()
+	for atomic.LoadInt32(&s.calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the waiter gives up without waiting for the leader.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&s.calls))
+
+	close(s.release)
+	<-done
+}
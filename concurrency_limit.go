@@ -0,0 +1,359 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	headerRetryAfter = "Retry-After"
+
+	defaultConcurrencyQueueTimeout = time.Second
+	defaultConcurrencyRetryAfter   = time.Second
+)
+
+// LimitAlgorithm is an interface that adjusts the concurrency limit by the observed
+// latency, it is called with the lock of limiter held, so that implementations
+// do not need to be safe for concurrent use.
+type LimitAlgorithm interface {
+	// Update returns the new limit after a request completed, inFlight is the number
+	// of requests being served including the completed one, and dropped indicates
+	// whether the request failed due to overload, that is, 503 and 504 responses or
+	// timeout errors.
+	Update(limit int, rtt time.Duration, inFlight int, dropped bool) int
+}
+
+// AIMDLimit is an additive-increase/multiplicative-decrease algorithm, the limit is
+// increased by one if the requests are fast and the limit is being utilized, and is
+// multiplied by the backoff ratio once a request was dropped or slower than timeout.
+type AIMDLimit struct {
+	Min     int
+	Max     int
+	Timeout time.Duration
+	// Backoff ratio in (0, 1), defaults to 0.9.
+	Backoff float64
+}
+
+// NewAIMDLimit returns an AIMD algorithm with the given bounds and timeout.
+func NewAIMDLimit(min, max int, timeout time.Duration) *AIMDLimit {
+	return &AIMDLimit{Min: min, Max: max, Timeout: timeout, Backoff: 0.9}
+}
+
+// Update implements LimitAlgorithm.Update.
+func (l *AIMDLimit) Update(limit int, rtt time.Duration, inFlight int, dropped bool) int {
+	if dropped || l.Timeout > 0 && rtt > l.Timeout {
+		backoff := l.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = 0.9
+		}
+		limit = int(float64(limit) * backoff)
+	} else if inFlight*2 >= limit {
+		limit++
+	}
+	return clampLimit(limit, l.Min, l.Max)
+}
+
+// GradientLimit is an algorithm that adjusts the limit by the gradient between the
+// minimum latency and the current latency, the limit is decreased once the latency
+// rises since the requests are queuing up somewhere, and is increased by the square
+// root of limit if the latency is stable.
+type GradientLimit struct {
+	Min int
+	Max int
+	// Smoothing factor in (0, 1], defaults to 0.2.
+	Smoothing float64
+	// ProbeInterval is the number of samples after which the minimum latency is
+	// reset, so that the changes of baseline latency can be detected, defaults to 1000.
+	ProbeInterval int
+
+	minRTT   time.Duration
+	samples  int
+	estimate float64
+}
+
+// NewGradientLimit returns a gradient algorithm with the given bounds.
+func NewGradientLimit(min, max int) *GradientLimit {
+	return &GradientLimit{Min: min, Max: max, Smoothing: 0.2, ProbeInterval: 1000}
+}
+
+// Update implements LimitAlgorithm.Update.
+func (l *GradientLimit) Update(limit int, rtt time.Duration, inFlight int, dropped bool) int {
+	if l.estimate == 0 {
+		l.estimate = float64(limit)
+	}
+	l.samples++
+	if l.minRTT == 0 || rtt < l.minRTT || l.ProbeInterval > 0 && l.samples >= l.ProbeInterval {
+		l.minRTT = rtt
+		l.samples = 0
+	}
+	if dropped {
+		l.estimate = math.Max(float64(l.Min), l.estimate*0.9)
+		return clampLimit(int(l.estimate), l.Min, l.Max)
+	}
+	// the limit is not utilized, the latency says nothing about the limit.
+	if inFlight*2 < limit || rtt <= 0 {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, float64(l.minRTT)/float64(rtt)))
+	smoothing := l.Smoothing
+	if smoothing <= 0 || smoothing > 1 {
+		smoothing = 0.2
+	}
+	newLimit := l.estimate*gradient + math.Sqrt(l.estimate)
+	l.estimate = l.estimate*(1-smoothing) + newLimit*smoothing
+	l.estimate = math.Max(float64(l.Min), math.Min(float64(l.Max), l.estimate))
+	return clampLimit(int(l.estimate), l.Min, l.Max)
+}
+
+func clampLimit(limit, min, max int) int {
+	if min < 1 {
+		min = 1
+	}
+	if limit < min {
+		return min
+	}
+	if max > 0 && limit > max {
+		return max
+	}
+	return limit
+}
+
+// ConcurrencyLimitOption is a function that receives a concurrency limiter.
+type ConcurrencyLimitOption func(*concurrencyLimiter)
+
+// ConcurrencyLimitQueue is an option that sets the maximum number of requests that
+// wait for a slot, the requests beyond the queue are rejected immediately, defaults
+// to zero.
+func ConcurrencyLimitQueue(size int) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.queueSize = size
+	}
+}
+
+// ConcurrencyLimitQueueTimeout is an option that sets how long a request waits in
+// the queue before being rejected, defaults to one second.
+func ConcurrencyLimitQueueTimeout(timeout time.Duration) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.queueTimeout = timeout
+	}
+}
+
+// ConcurrencyLimitAlgorithm is an option that sets the algorithm that adjusts the
+// limit adaptively, see AIMDLimit and GradientLimit. The limit is fixed if absent.
+func ConcurrencyLimitAlgorithm(algorithm LimitAlgorithm) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.algorithm = algorithm
+	}
+}
+
+// ConcurrencyLimitRetryAfter is an option that sets the Retry-After header of
+// rejected requests, defaults to one second.
+func ConcurrencyLimitRetryAfter(d time.Duration) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.retryAfter = d
+	}
+}
+
+// ConcurrencyLimitMetrics is an option that registers the following metrics to the
+// registry, the limiter label is the given name:
+//
+//	http_concurrency_limit             gauge    limiter
+//	http_concurrency_in_flight         gauge    limiter
+//	http_concurrency_queued            gauge    limiter
+//	http_concurrency_rejected_total    counter  limiter
+func ConcurrencyLimitMetrics(registry *MetricsRegistry, name string) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.registry = registry
+		l.name = name
+	}
+}
+
+// ConcurrencyLimitSkipper is an option that sets skipper.
+func ConcurrencyLimitSkipper(skipper Skipper) ConcurrencyLimitOption {
+	return func(l *concurrencyLimiter) {
+		l.skipper = skipper
+	}
+}
+
+// RouteGroupConcurrencyLimit is a route group option that limits the concurrent
+// requests of the routes that belong to the group, the limit is shared by the
+// routes, see ConcurrencyLimit.
+func RouteGroupConcurrencyLimit(limit int, opts ...ConcurrencyLimitOption) RouteGroupOption {
+	return RouteGroupMiddleware(ConcurrencyLimit(limit, opts...))
+}
+
+// ConcurrencyLimit returns a middleware that limits the number of requests being
+// served, so that excess load is rejected quickly instead of queuing until timeout.
+//
+// The requests beyond the limit wait in a bounded FIFO queue, and are rejected with
+// ErrServiceUnavailable (503) and Retry-After header if the queue is full or they
+// waited longer than the queue timeout. The limit can be adjusted adaptively by the
+// observed latency, see ConcurrencyLimitAlgorithm.
+//
+// It panics if the limit is not positive.
+func ConcurrencyLimit(limit int, opts ...ConcurrencyLimitOption) MiddlewareFunc {
+	if limit <= 0 {
+		panic("clevergo: concurrency limit must be positive")
+	}
+	l := &concurrencyLimiter{
+		limit:        limit,
+		queueTimeout: defaultConcurrencyQueueTimeout,
+		retryAfter:   defaultConcurrencyRetryAfter,
+		queue:        list.New(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.registry != nil {
+		l.limitGauge = l.registry.GaugeVec("http_concurrency_limit", "Concurrency limit of HTTP requests.", "limiter").With(l.name)
+		l.inFlightGauge = l.registry.GaugeVec("http_concurrency_in_flight", "Number of HTTP requests being served within the concurrency limit.", "limiter").With(l.name)
+		l.queuedGauge = l.registry.GaugeVec("http_concurrency_queued", "Number of HTTP requests waiting for the concurrency limit.", "limiter").With(l.name)
+		l.rejected = l.registry.CounterVec("http_concurrency_rejected_total", "Total number of HTTP requests rejected by the concurrency limit.", "limiter").With(l.name)
+		l.limitGauge.Set(float64(limit))
+	}
+	return l.middleware
+}
+
+type concurrencyLimiter struct {
+	mu           sync.Mutex
+	limit        int
+	inFlight     int
+	queue        *list.List
+	queueSize    int
+	queueTimeout time.Duration
+	algorithm    LimitAlgorithm
+	retryAfter   time.Duration
+	skipper      Skipper
+
+	registry      *MetricsRegistry
+	name          string
+	limitGauge    *Gauge
+	inFlightGauge *Gauge
+	queuedGauge   *Gauge
+	rejected      *Counter
+}
+
+// concurrencyWaiter is a queued request, ready is closed once a slot was granted.
+type concurrencyWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func (l *concurrencyLimiter) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if l.skipper != nil && l.skipper(c) {
+			return next(c)
+		}
+		if !l.acquire(c.Request) {
+			if l.rejected != nil {
+				l.rejected.Inc()
+			}
+			seconds := int64(math.Ceil(l.retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			c.SetHeader(headerRetryAfter, strconv.FormatInt(seconds, 10))
+			return ErrServiceUnavailable
+		}
+
+		start := time.Now()
+		resp := newResponseWriter(c.Response)
+		defer func(w http.ResponseWriter) {
+			c.Response = w
+		}(c.Response)
+		c.Response = resp
+		var err error
+		defer func() {
+			l.release(time.Since(start), isOverloaded(resp.status(err), err))
+		}()
+		err = next(c)
+		return err
+	}
+}
+
+// isOverloaded reports whether the request failed due to overload, the other
+// server errors are not taken into account, since they are unrelated to load.
+func isOverloaded(status int, err error) bool {
+	if status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout {
+		return true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+func (l *concurrencyLimiter) acquire(r *http.Request) bool {
+	l.mu.Lock()
+	if l.inFlight < l.limit {
+		l.inFlight++
+		l.updateGauges()
+		l.mu.Unlock()
+		return true
+	}
+	if l.queue.Len() >= l.queueSize {
+		l.mu.Unlock()
+		return false
+	}
+	waiter := &concurrencyWaiter{ready: make(chan struct{})}
+	e := l.queue.PushBack(waiter)
+	l.updateGauges()
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+		return true
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the slot may be granted while timing out.
+	if waiter.granted {
+		return true
+	}
+	l.queue.Remove(e)
+	l.updateGauges()
+	return false
+}
+
+func (l *concurrencyLimiter) release(rtt time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.algorithm != nil {
+		l.limit = l.algorithm.Update(l.limit, rtt, l.inFlight, dropped)
+	}
+	l.inFlight--
+	for l.inFlight < l.limit && l.queue.Len() > 0 {
+		waiter := l.queue.Remove(l.queue.Front()).(*concurrencyWaiter)
+		waiter.granted = true
+		close(waiter.ready)
+		l.inFlight++
+	}
+	l.updateGauges()
+}
+
+// updateGauges updates the gauges, it must be called with the lock held.
+func (l *concurrencyLimiter) updateGauges() {
+	if l.registry == nil {
+		return
+	}
+	l.limitGauge.Set(float64(l.limit))
+	l.inFlightGauge.Set(float64(l.inFlight))
+	l.queuedGauge.Set(float64(l.queue.Len()))
+}
@@ -0,0 +1,315 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAIMDLimit(t *testing.T) {
+	l := NewAIMDLimit(2, 10, 100*time.Millisecond)
+	cases := []struct {
+		limit    int
+		rtt      time.Duration
+		inFlight int
+		dropped  bool
+		expected int
+	}{
+		{5, 10 * time.Millisecond, 5, false, 6},
+		{5, 10 * time.Millisecond, 3, false, 6},
+		// the limit is not utilized.
+		{5, 10 * time.Millisecond, 2, false, 5},
+		{10, 10 * time.Millisecond, 10, false, 10},
+		{10, 10 * time.Millisecond, 10, true, 9},
+		{10, time.Second, 10, false, 9},
+		{2, time.Second, 2, false, 2},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, l.Update(test.limit, test.rtt, test.inFlight, test.dropped), test)
+	}
+
+	l.Backoff = 0
+	assert.Equal(t, 9, l.Update(10, 0, 10, true))
+	l.Backoff = 0.5
+	assert.Equal(t, 5, l.Update(10, 0, 10, true))
+}
+
+func TestGradientLimit(t *testing.T) {
+	l := NewGradientLimit(1, 100)
+	limit := 10
+	// stable latency increases the limit.
+	for i := 0; i < 50; i++ {
+		limit = l.Update(limit, 10*time.Millisecond, limit, false)
+	}
+	assert.True(t, limit > 20, limit)
+
+	// rising latency decreases the limit.
+	high := limit
+	for i := 0; i < 50; i++ {
+		limit = l.Update(limit, 100*time.Millisecond, limit, false)
+	}
+	assert.True(t, limit < high, limit)
+
+	// the limit is not utilized.
+	assert.Equal(t, limit, l.Update(limit, time.Millisecond, 0, false))
+
+	dropped := l.Update(limit, 10*time.Millisecond, limit, true)
+	assert.True(t, dropped < limit)
+
+	// the bounds.
+	l = NewGradientLimit(5, 20)
+	for i := 0; i < 100; i++ {
+		limit = l.Update(20, 10*time.Millisecond, 20, false)
+	}
+	assert.Equal(t, 20, limit)
+	for i := 0; i < 100; i++ {
+		limit = l.Update(limit, time.Second, limit, true)
+	}
+	assert.Equal(t, 5, limit)
+}
+
+func TestGradientLimitProbe(t *testing.T) {
+	l := NewGradientLimit(1, 100)
+	l.ProbeInterval = 3
+	l.Update(10, 10*time.Millisecond, 10, false)
+	assert.Equal(t, 10*time.Millisecond, l.minRTT)
+	l.Update(10, 50*time.Millisecond, 10, false)
+	l.Update(10, 50*time.Millisecond, 10, false)
+	assert.Equal(t, 10*time.Millisecond, l.minRTT)
+	l.Update(10, 50*time.Millisecond, 10, false)
+	assert.Equal(t, 50*time.Millisecond, l.minRTT)
+}
+
+type concurrencyTestServer struct {
+	app      *Application
+	registry *MetricsRegistry
+	release  chan struct{}
+	started  int32
+}
+
+func newConcurrencyTestServer(limit int, opts ...ConcurrencyLimitOption) *concurrencyTestServer {
+	s := &concurrencyTestServer{app: Pure(), registry: NewMetricsRegistry(), release: make(chan struct{})}
+	opts = append(opts, ConcurrencyLimitMetrics(s.registry, "test"))
+	s.app.Use(ErrorHandler(), ConcurrencyLimit(limit, opts...))
+	s.app.Get("/", func(c *Context) error {
+		atomic.AddInt32(&s.started, 1)
+		<-s.release
+		return c.String(http.StatusOK, "ok")
+	})
+	s.app.Get("/fast", echoHandler("fast"))
+	return s
+}
+
+func (s *concurrencyTestServer) serve(wg *sync.WaitGroup, ctx context.Context) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.app.ServeHTTP(w, req)
+	}()
+	return w
+}
+
+func (s *concurrencyTestServer) gauge(name string) float64 {
+	return s.registry.GaugeVec(name, "", "limiter").With("test").Value()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	s := newConcurrencyTestServer(2, ConcurrencyLimitRetryAfter(1500*time.Millisecond))
+	var wg sync.WaitGroup
+	ws := []*httptest.ResponseRecorder{s.serve(&wg, context.Background()), s.serve(&wg, context.Background())}
+	waitFor(t, func() bool { return atomic.LoadInt32(&s.started) == 2 })
+	assert.Equal(t, float64(2), s.gauge("http_concurrency_in_flight"))
+	assert.Equal(t, float64(2), s.gauge("http_concurrency_limit"))
+
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, float64(1), s.registry.CounterVec("http_concurrency_rejected_total", "", "limiter").With("test").Value())
+
+	close(s.release)
+	wg.Wait()
+	for _, w := range ws {
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, float64(0), s.gauge("http_concurrency_in_flight"))
+
+	w = httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestConcurrencyLimitQueue(t *testing.T) {
+	s := newConcurrencyTestServer(1, ConcurrencyLimitQueue(1), ConcurrencyLimitQueueTimeout(5*time.Second))
+	var wg sync.WaitGroup
+	w1 := s.serve(&wg, context.Background())
+	waitFor(t, func() bool { return atomic.LoadInt32(&s.started) == 1 })
+	w2 := s.serve(&wg, context.Background())
+	waitFor(t, func() bool { return s.gauge("http_concurrency_queued") == 1 })
+
+	// the queue is full.
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(s.release)
+	wg.Wait()
+	assert.Equal(t, http.StatusOK, w1.Code)
+	assert.Equal(t, http.StatusOK, w2.Code)
+	assert.Equal(t, int32(2), s.started)
+	assert.Equal(t, float64(0), s.gauge("http_concurrency_queued"))
+	assert.Equal(t, float64(0), s.gauge("http_concurrency_in_flight"))
+}
+
+func TestConcurrencyLimitQueueTimeout(t *testing.T) {
+	s := newConcurrencyTestServer(1, ConcurrencyLimitQueue(10), ConcurrencyLimitQueueTimeout(20*time.Millisecond))
+	var wg sync.WaitGroup
+	s.serve(&wg, context.Background())
+	waitFor(t, func() bool { return atomic.LoadInt32(&s.started) == 1 })
+
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, float64(0), s.gauge("http_concurrency_queued"))
+
+	// canceled while queuing.
+	ctx, cancel := context.WithCancel(context.Background())
+	w = s.serve(&wg, ctx)
+	waitFor(t, func() bool { return s.gauge("http_concurrency_queued") == 1 })
+	cancel()
+	waitFor(t, func() bool { return s.gauge("http_concurrency_queued") == 0 })
+
+	close(s.release)
+	wg.Wait()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int32(1), s.started)
+}
+
+func TestConcurrencyLimitAlgorithm(t *testing.T) {
+	registry := NewMetricsRegistry()
+	app := Pure()
+	app.Use(ErrorHandler(), ConcurrencyLimit(4,
+		ConcurrencyLimitAlgorithm(NewAIMDLimit(1, 8, time.Second)),
+		ConcurrencyLimitMetrics(registry, "aimd"),
+	))
+	app.Get("/", echoHandler("ok"))
+	app.Get("/error", func(c *Context) error {
+		return ErrServiceUnavailable
+	})
+	limit := registry.GaugeVec("http_concurrency_limit", "", "limiter").With("aimd")
+	for i := 0; i < 3; i++ {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/error", nil))
+	}
+	// 4 * 0.9 = 3, 3 * 0.9 = 2, 2 * 0.9 = 1.
+	assert.Equal(t, float64(1), limit.Value())
+	for i := 0; i < 3; i++ {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	// the limit stops increasing once it is not utilized by sequential requests.
+	assert.Equal(t, float64(3), limit.Value())
+}
+
+func TestIsOverloaded(t *testing.T) {
+	cases := []struct {
+		status int
+		err    error
+		ok     bool
+	}{
+		{http.StatusOK, nil, false},
+		{http.StatusInternalServerError, errors.New("error"), false},
+		{http.StatusBadGateway, ErrBadGateway, false},
+		{http.StatusServiceUnavailable, ErrServiceUnavailable, true},
+		{http.StatusGatewayTimeout, nil, true},
+		{http.StatusInternalServerError, context.DeadlineExceeded, true},
+		{http.StatusInternalServerError, &net.OpError{Op: "read", Err: timeoutError{}}, true},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.ok, isOverloaded(test.status, test.err), test.status)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestConcurrencyLimitInvalid(t *testing.T) {
+	assert.Panics(t, func() { ConcurrencyLimit(0) })
+	assert.Panics(t, func() { ConcurrencyLimit(-1) })
+}
+
+func TestConcurrencyLimitSkipper(t *testing.T) {
+	s := newConcurrencyTestServer(1, ConcurrencyLimitSkipper(func(c *Context) bool {
+		return strings.HasPrefix(c.Request.URL.Path, "/fast")
+	}))
+	var wg sync.WaitGroup
+	s.serve(&wg, context.Background())
+	waitFor(t, func() bool { return atomic.LoadInt32(&s.started) == 1 })
+	w := httptest.NewRecorder()
+	s.app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	close(s.release)
+	wg.Wait()
+}
+
+func TestRouteGroupConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	var started int32
+	app := Pure()
+	app.Use(ErrorHandler())
+	api := app.Group("/api", RouteGroupConcurrencyLimit(1))
+	slow := func(c *Context) error {
+		atomic.AddInt32(&started, 1)
+		<-release
+		return nil
+	}
+	api.Get("/slow", slow)
+	api.Get("/fast", echoHandler("fast"))
+	app.Get("/fast", echoHandler("fast"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/slow", nil))
+	}()
+	waitFor(t, func() bool { return atomic.LoadInt32(&started) == 1 })
+
+	// the limit is shared by the routes of group.
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/fast", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	close(release)
+	wg.Wait()
+}
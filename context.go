@@ -11,8 +11,10 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -107,6 +109,16 @@ func (c *Context) Redirect(code int, url string) error {
 	return nil
 }
 
+// AbsoluteRedirect is similar to Redirect, except that the location is resolved
+// to an absolute URL, see AbsoluteURL.
+func (c *Context) AbsoluteRedirect(code int, location string) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	return c.Redirect(code, c.AbsoluteURL(u).String())
+}
+
 // ServeFile is a shortcut of http.ServeFile.
 func (c *Context) ServeFile(name string) error {
 	http.ServeFile(c.Response, c.Request, name)
@@ -356,6 +368,33 @@ func (c *Context) Render(code int, name string, data interface{}, args ...string
 	return c.Blob(code, contentType, buf.Bytes())
 }
 
+// RenderStream is similar to Render, but it writes the output of renderer to the
+// response directly instead of buffering it, which reduces memory usage and sends
+// the beginning of page earlier, the renderer can flush the written output by
+// http.Flusher, see TemplateRenderer's "flush" function.
+//
+// The header is sent on the first write, errors that happen after that are returned
+// as StreamError, since the status code can no longer be changed.
+func (c *Context) RenderStream(code int, name string, data interface{}, args ...string) error {
+	if c.app.Renderer == nil {
+		return ErrRendererNotRegister
+	}
+
+	contentType := headerContentTypeHTML
+	if len(args) > 0 {
+		contentType = args[0]
+	}
+	w := &streamWriter{c: c, code: code, contentType: contentType}
+	if err := c.app.Renderer.Render(w, name, data, c); err != nil {
+		if w.wroteHeader {
+			return StreamError{Err: err}
+		}
+		return err
+	}
+	w.writeHeader()
+	return nil
+}
+
 // Emit sends a response with the given status code, content type and string body.
 func (c *Context) Emit(code int, contentType string, body string) (err error) {
 	c.SetContentType(contentType)
@@ -387,11 +426,103 @@ func (c *Context) PostFormValue(key string) string {
 	return c.Request.PostFormValue(key)
 }
 
-// Host returns http.Request.Host.
+// Host returns the host of request, the forwarded host takes precedence if the
+// request comes from a trusted proxy, see Application.ForwardedHeaders. The
+// forwarded values are walked from right to left like RealIP, so that the values
+// sent by clients are ignored.
 func (c *Context) Host() string {
+	if c.fromTrustedProxy() {
+		var host string
+		if c.app.ForwardedHeaders == RFC7239Headers {
+			host = c.forwardedElementValue(func(e forwardedElement) string { return e.host })
+		} else {
+			host = c.forwardedHeaderValue(headerXForwardedHost)
+		}
+		if host != "" {
+			return host
+		}
+	}
 	return c.Request.Host
 }
 
+// Scheme returns the scheme of request, either "http" or "https", the forwarded
+// protocol takes precedence if the request comes from a trusted proxy, see Host.
+func (c *Context) Scheme() string {
+	if c.fromTrustedProxy() {
+		var proto string
+		if c.app.ForwardedHeaders == RFC7239Headers {
+			proto = c.forwardedElementValue(func(e forwardedElement) string { return e.proto })
+		} else {
+			proto = strings.ToLower(c.forwardedHeaderValue(headerXForwardedProto))
+		}
+		if proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// IsTLS indicates whether the client connects to the server or the trusted proxy via TLS.
+func (c *Context) IsTLS() bool {
+	return c.Scheme() == "https"
+}
+
+// RealIP returns the client IP address.
+//
+// The forwarding headers will be taken into account only if the request comes from
+// a trusted proxy, in which case the forwarded addresses are walked from right to left,
+// the first address that does not belong to a trusted proxy is the client IP address.
+func (c *Context) RealIP() string {
+	remote := remoteHost(c.Request.RemoteAddr)
+	if !c.fromTrustedProxy() {
+		return remote
+	}
+
+	if addrs := forwardedFor(c.Request.Header, c.app.ForwardedHeaders); len(addrs) > 0 {
+		last := remote
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(addrs[i])
+			if ip == nil {
+				// unknown or obfuscated identifier.
+				return last
+			}
+			if i == 0 || !c.app.isTrustedProxy(ip) {
+				return addrs[i]
+			}
+			last = addrs[i]
+		}
+	}
+
+	if ip := c.GetHeader(headerXRealIP); ip != "" && c.app.ForwardedHeaders == XForwardedHeaders {
+		return ip
+	}
+
+	return remote
+}
+
+func (c *Context) fromTrustedProxy() bool {
+	if c.app == nil || len(c.app.trustedProxies) == 0 {
+		return false
+	}
+	return c.app.isTrustedProxy(net.ParseIP(remoteHost(c.Request.RemoteAddr)))
+}
+
+// BaseURL returns an URL that consists of the scheme and host of request.
+func (c *Context) BaseURL() *url.URL {
+	return &url.URL{
+		Scheme: c.Scheme(),
+		Host:   c.Host(),
+	}
+}
+
+// AbsoluteURL resolves the given URL reference to an absolute URL, based on BaseURL.
+func (c *Context) AbsoluteURL(u *url.URL) *url.URL {
+	return c.BaseURL().ResolveReference(u)
+}
+
 // QueryString returns the raw query of request URL.
 func (c *Context) QueryString() string {
 	return c.Request.URL.RawQuery
@@ -425,6 +556,22 @@ func (c *Context) RouteURL(name string, args ...string) (*url.URL, error) {
 	return c.app.RouteURL(name, args...)
 }
 
+// AbsoluteRouteURL returns the absolute URL of the naming route, see AbsoluteURL.
+func (c *Context) AbsoluteRouteURL(name string, args ...string) (*url.URL, error) {
+	u, err := c.RouteURL(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	return c.AbsoluteURL(u), nil
+}
+
+// CSPNonce returns the content security policy nonce of current request, it is empty
+// unless the nonce is enabled, see Secure and ContentSecurityPolicy.Nonce.
+func (c *Context) CSPNonce() string {
+	nonce, _ := c.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
 // BasicAuth is a shortcut of http.Request.BasicAuth.
 func (c *Context) BasicAuth() (username, password string, ok bool) {
 	return c.Request.BasicAuth()
@@ -7,11 +7,13 @@ package clevergo
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -606,6 +608,168 @@ func TestContext_Host(t *testing.T) {
 	}
 }
 
+func TestContext_ForwardedHost(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	cases := []struct {
+		remoteAddr string
+		header     map[string]string
+		host       string
+	}{
+		{"10.0.0.1:1234", nil, "example.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "foo.com, bar.com"}, "bar.com"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "spoof.com, foo.com", "X-Forwarded-For": "192.0.2.1"}, "foo.com"},
+		// the Forwarded header is ignored by default.
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=192.0.2.2;host=foo.com"}, "example.com"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "host=spoof.com", "X-Forwarded-Host": "foo.com"}, "foo.com"},
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-Host": "foo.com"}, "example.com"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.host, c.Host())
+	}
+}
+
+func TestContext_Scheme(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	cases := []struct {
+		remoteAddr string
+		tls        bool
+		header     map[string]string
+		scheme     string
+	}{
+		{"10.0.0.1:1234", false, nil, "http"},
+		{"10.0.0.1:1234", true, nil, "https"},
+		{"10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "HTTPS"}, "https"},
+		{"10.0.0.1:1234", true, map[string]string{"X-Forwarded-Proto": "http"}, "http"},
+		{"10.0.0.1:1234", false, map[string]string{"Forwarded": "proto=https", "X-Forwarded-Proto": "http"}, "http"},
+		{"10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "https, http"}, "http"},
+		{"10.0.0.1:1234", false, map[string]string{"X-Forwarded-Proto": "javascript"}, "http"},
+		{"10.0.0.1:1234", true, map[string]string{"Forwarded": "proto=ftp"}, "https"},
+		{"192.0.2.1:1234", false, map[string]string{"X-Forwarded-Proto": "https"}, "http"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if !test.tls {
+			req.TLS = nil
+		} else {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.scheme, c.Scheme())
+		assert.Equal(t, test.scheme == "https", c.IsTLS())
+	}
+}
+
+func TestContext_RealIP(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	cases := []struct {
+		remoteAddr string
+		header     map[string]string
+		ip         string
+	}{
+		{"192.0.2.1:1234", nil, "192.0.2.1"},
+		{"192.0.2.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.2"}, "192.0.2.1"},
+		{"192.0.2.1:1234", map[string]string{"X-Real-IP": "192.0.2.2"}, "192.0.2.1"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Real-IP": "192.0.2.2"}, "192.0.2.2"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.2"}, "192.0.2.2"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.3, 192.0.2.2, 10.0.0.2"}, "192.0.2.2"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.2, unknown, 10.0.0.2"}, "10.0.0.2"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:80", for=10.0.0.2`, "X-Forwarded-For": "192.0.2.2"}, "192.0.2.2"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.ip, c.RealIP())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.2")
+	assert.Equal(t, "10.0.0.1", newContext(nil, req).RealIP())
+}
+
+func TestContext_RFC7239Headers(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	app.ForwardedHeaders = RFC7239Headers
+	cases := []struct {
+		remoteAddr string
+		header     map[string]string
+		ip         string
+		host       string
+		scheme     string
+	}{
+		{"10.0.0.1:1234", nil, "10.0.0.1", "example.com", "http"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=192.0.2.2;host=foo.com;proto=https"}, "192.0.2.2", "foo.com", "https"},
+		{"10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:80", for=10.0.0.2`}, "2001:db8::1", "example.com", "http"},
+		// the elements sent by clients are ignored.
+		{"10.0.0.1:1234", map[string]string{"Forwarded": "for=6.6.6.6;host=evil.com;proto=https, for=192.0.2.2;host=foo.com"}, "192.0.2.2", "foo.com", "http"},
+		// the X-Forwarded-* headers are ignored.
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.2", "X-Real-IP": "192.0.2.3", "X-Forwarded-Host": "foo.com", "X-Forwarded-Proto": "https"}, "10.0.0.1", "example.com", "http"},
+		{"192.0.2.1:1234", map[string]string{"Forwarded": "for=192.0.2.2;host=foo.com;proto=https"}, "192.0.2.1", "example.com", "http"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.ip, c.RealIP())
+		assert.Equal(t, test.host, c.Host())
+		assert.Equal(t, test.scheme, c.Scheme())
+	}
+}
+
+func TestContext_AbsoluteURL(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	app.Get("/users/:name", echoHandler("foo"), RouteName("user"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "example.org")
+	w := httptest.NewRecorder()
+	c := newContext(w, req)
+	c.app = app
+
+	assert.Equal(t, "https://example.org", c.BaseURL().String())
+	assert.Equal(t, "https://example.org/foo?bar=baz", c.AbsoluteURL(&url.URL{Path: "/foo", RawQuery: "bar=baz"}).String())
+
+	u, err := c.AbsoluteRouteURL("user", "name", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.org/users/foo", u.String())
+	_, err = c.AbsoluteRouteURL("nonexistent")
+	assert.NotNil(t, err)
+
+	assert.Nil(t, c.AbsoluteRedirect(http.StatusFound, "/login"))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.org/login", w.Header().Get("Location"))
+	assert.NotNil(t, c.AbsoluteRedirect(http.StatusFound, "%zz"))
+}
+
 func TestContext_QueryParams(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/?foo=bar&fizz=buzz", nil)
 	c := newContext(nil, req)
@@ -682,6 +846,54 @@ func TestContext_Render(t *testing.T) {
 	assert.Equal(t, "bar", w.Body.String())
 }
 
+type streamRenderer struct {
+}
+
+func (r *streamRenderer) Render(w io.Writer, name string, data interface{}, c *Context) error {
+	if name == "" {
+		return errors.New("empty template name")
+	}
+	w.Write([]byte("<head></head>"))
+	w.(http.Flusher).Flush()
+	if name == "error" {
+		return errors.New("render error")
+	}
+	w.Write([]byte(name))
+	return nil
+}
+
+func TestContext_RenderStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	app := New()
+	c := newContext(w, nil)
+	c.app = app
+
+	err := c.RenderStream(http.StatusOK, "foo", nil)
+	assert.Equal(t, ErrRendererNotRegister, err)
+
+	app.Renderer = new(streamRenderer)
+
+	// nothing was written.
+	err = c.RenderStream(http.StatusOK, "", nil)
+	assert.EqualError(t, err, "empty template name")
+	assert.False(t, w.Flushed)
+	assert.Equal(t, "", w.Header().Get("Content-Type"))
+
+	err = c.RenderStream(http.StatusForbidden, "foo", nil)
+	assert.Nil(t, err)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, headerContentTypeHTML, w.Header().Get("Content-Type"))
+	assert.Equal(t, "<head></head>foo", w.Body.String())
+
+	w = httptest.NewRecorder()
+	c.Response = w
+	err = c.RenderStream(http.StatusOK, "error", nil, headerContentTypeJavaScript)
+	assert.Equal(t, StreamError{errors.New("render error")}, err)
+	assert.Equal(t, headerContentTypeJavaScript, w.Header().Get("Content-Type"))
+	assert.Equal(t, "<head></head>", w.Body.String())
+}
+
 func TestContext_RouteURL(t *testing.T) {
 	app := New()
 	app.Get("/", echoHandler("foo"), RouteName("foo"))
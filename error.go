@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // Error defines an HTTP response error.
@@ -18,8 +19,14 @@ type Error interface {
 
 // Errors
 var (
-	ErrNotFound         = StatusError{http.StatusNotFound, errors.New(http.StatusText(http.StatusNotFound))}
-	ErrMethodNotAllowed = StatusError{http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed))}
+	ErrUnauthorized          = StatusError{http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized))}
+	ErrForbidden             = StatusError{http.StatusForbidden, errors.New(http.StatusText(http.StatusForbidden))}
+	ErrNotFound              = StatusError{http.StatusNotFound, errors.New(http.StatusText(http.StatusNotFound))}
+	ErrMethodNotAllowed      = StatusError{http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed))}
+	ErrPreconditionFailed    = StatusError{http.StatusPreconditionFailed, errors.New(http.StatusText(http.StatusPreconditionFailed))}
+	ErrRequestEntityTooLarge = StatusError{http.StatusRequestEntityTooLarge, errors.New(http.StatusText(http.StatusRequestEntityTooLarge))}
+	ErrBadGateway            = StatusError{http.StatusBadGateway, errors.New(http.StatusText(http.StatusBadGateway))}
+	ErrServiceUnavailable    = StatusError{http.StatusServiceUnavailable, errors.New(http.StatusText(http.StatusServiceUnavailable))}
 )
 
 type errorHandler struct {
@@ -37,6 +44,8 @@ func (h *errorHandler) middleware(next Handle) Handle {
 func (h *errorHandler) handleError(c *Context, err error) {
 	c.Logger().Errorf("clevergo: error handler catches an error: %s", err.Error())
 	switch e := err.(type) {
+	case StreamError:
+		// the response has been partially sent, nothing can be done.
 	case Error:
 		c.Error(e.Status(), err.Error())
 	default:
@@ -71,6 +80,23 @@ func (e StatusError) Status() int {
 	return e.Code
 }
 
+// StreamError is an error that happened after the response header has been sent,
+// such as a rendering error of Context.RenderStream. ErrorHandler and Application
+// log it without writing response, since the status code can no longer be changed.
+type StreamError struct {
+	Err error
+}
+
+// Error implements error interface.
+func (e StreamError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e StreamError) Unwrap() error {
+	return e.Err
+}
+
 // PanicError is an error that contains panic information.
 type PanicError struct {
 	// Context.
@@ -87,3 +113,28 @@ type PanicError struct {
 func (e PanicError) Error() string {
 	return fmt.Sprintf("Panic: %v\n%s\n", e.Data, e.Stack)
 }
+
+// MultiError is a list of errors, such as the errors of listeners, see
+// Application.Listen.
+type MultiError []error
+
+// Error implements error interface.
+func (e MultiError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// err returns nil if the list is empty, the only error if it contains one error,
+// otherwise the list itself.
+func (e MultiError) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
@@ -39,6 +39,7 @@ func TestErrorHandler(t *testing.T) {
 		{nil, http.StatusOK, ""},
 		{ErrNotFound, http.StatusNotFound, "Not Found\n"},
 		{errors.New("foobar"), http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError) + "\n"},
+		{StreamError{errors.New("foobar")}, http.StatusOK, ""},
 	}
 	for _, test := range cases {
 		handle := m(func(c *Context) error {
@@ -62,3 +63,23 @@ func TestPanicErrorError(t *testing.T) {
 	assert.Contains(t, msg, "foo")
 	assert.Contains(t, msg, "bar")
 }
+
+func TestStreamError(t *testing.T) {
+	err := errors.New("foobar")
+	streamErr := StreamError{err}
+	assert.Equal(t, "foobar", streamErr.Error())
+	assert.Equal(t, err, streamErr.Unwrap())
+}
+
+func TestMultiError(t *testing.T) {
+	var merr MultiError
+	assert.Nil(t, merr.err())
+
+	err1 := errors.New("foo")
+	merr = append(merr, err1)
+	assert.Equal(t, err1, merr.err())
+
+	merr = append(merr, errors.New("bar"))
+	assert.Equal(t, merr, merr.err())
+	assert.Equal(t, "foo; bar", merr.Error())
+}
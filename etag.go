@@ -0,0 +1,361 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	headerIfMatch           = "If-Match"
+	headerIfNoneMatch       = "If-None-Match"
+	headerIfModifiedSince   = "If-Modified-Since"
+	headerIfUnmodifiedSince = "If-Unmodified-Since"
+	headerLastModified      = "Last-Modified"
+)
+
+// SetETag sets the ETag header, the tag will be quoted if it is not, weak tags
+// should be prefixed with "W/", such as W/"v1" or W/v1.
+func (c *Context) SetETag(etag string) {
+	weak := strings.HasPrefix(etag, "W/")
+	if weak {
+		etag = etag[2:]
+	}
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		etag = `"` + etag + `"`
+	}
+	if weak {
+		etag = "W/" + etag
+	}
+	c.SetHeader(headerETag, etag)
+}
+
+// SetLastModified sets the Last-Modified header.
+func (c *Context) SetLastModified(t time.Time) {
+	c.SetHeader(headerLastModified, t.UTC().Format(http.TimeFormat))
+}
+
+// NotModified reports whether the resource was not modified since the client
+// cached it, by comparing the If-None-Match and If-Modified-Since headers with
+// the validators of response, see SetETag and SetLastModified. The 304 status
+// code will be written if it returns true, so that the handler should return
+// immediately:
+//
+//	c.SetETag(post.Version)
+//	if c.NotModified() {
+//		return nil
+//	}
+//	return c.JSON(http.StatusOK, post)
+func (c *Context) NotModified() bool {
+	if !isNotModified(c.Request, c.Response.Header()) {
+		return false
+	}
+	writeNotModified(c.Response)
+	return true
+}
+
+// CheckPreconditions evaluates the If-Match, If-Unmodified-Since and If-None-Match
+// headers of unsafe methods with the validators of response, see SetETag and
+// SetLastModified. ErrPreconditionFailed will be returned if failed, so that the
+// changes based on the stale resource can be rejected:
+//
+//	c.SetETag(post.Version)
+//	if err := c.CheckPreconditions(); err != nil {
+//		return err
+//	}
+//	// updates the post.
+func (c *Context) CheckPreconditions() error {
+	header := c.Response.Header()
+	lastModified, _ := http.ParseTime(header.Get(headerLastModified))
+	if !checkPreconditions(c.Request, header.Get(headerETag), lastModified) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// ETagOption is a function that receives an ETag middleware.
+type ETagOption func(*etagMiddleware)
+
+// ETagWeak is an option that indicates whether to generate weak ETags, it is
+// useful when the response would be modified by the intermediaries, such as
+// compression of proxies.
+func ETagWeak(weak bool) ETagOption {
+	return func(m *etagMiddleware) {
+		m.weak = weak
+	}
+}
+
+// ETagValidator is an option that sets the function that returns the validators
+// of current resource for the unsafe methods, such as PUT and PATCH, an empty ETag
+// and zero time indicate that the resource does not exist. The preconditions are
+// evaluated before calling the handler, and ErrPreconditionFailed will be returned
+// if failed. The middleware should be used as route middleware if the validator
+// depends on route parameters, see RouteMiddleware.
+func ETagValidator(f func(c *Context) (etag string, lastModified time.Time, err error)) ETagOption {
+	return func(m *etagMiddleware) {
+		m.validator = f
+	}
+}
+
+// ETagSkipper is an option that sets skipper.
+func ETagSkipper(skipper Skipper) ETagOption {
+	return func(m *etagMiddleware) {
+		m.skipper = skipper
+	}
+}
+
+// ETag returns a conditional request middleware.
+//
+// The successful responses of GET and HEAD requests are buffered, and an ETag
+// computed from the body will be set if the handler did not set it. The 304 status
+// code without body will be sent if the response matches the If-None-Match or
+// If-Modified-Since header.
+//
+// The preconditions of unsafe methods are enforced if ETagValidator is present,
+// handlers can also enforce them by Context.CheckPreconditions.
+func ETag(opts ...ETagOption) MiddlewareFunc {
+	m := &etagMiddleware{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.middleware
+}
+
+type etagMiddleware struct {
+	weak      bool
+	validator func(c *Context) (string, time.Time, error)
+	skipper   Skipper
+}
+
+func (m *etagMiddleware) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			if m.validator != nil && hasPreconditions(c.Request) {
+				etag, lastModified, err := m.validator(c)
+				if err != nil {
+					return err
+				}
+				if !checkPreconditions(c.Request, etag, lastModified) {
+					return ErrPreconditionFailed
+				}
+			}
+			return next(c)
+		}
+
+		w := &etagWriter{ResponseWriter: c.Response, statusCode: http.StatusOK}
+		defer func(resp http.ResponseWriter) {
+			c.Response = resp
+		}(c.Response)
+		c.Response = w
+
+		err := next(c)
+		if !w.wroteHeader || w.passthrough {
+			return err
+		}
+		if err == nil {
+			header := w.Header()
+			if header.Get(headerETag) == "" && w.buf.Len() > 0 {
+				header.Set(headerETag, m.etag(w.buf.Bytes()))
+			}
+			if isNotModified(c.Request, header) {
+				writeNotModified(w.ResponseWriter)
+				return nil
+			}
+		}
+		w.ResponseWriter.WriteHeader(w.statusCode)
+		if _, werr := w.ResponseWriter.Write(w.buf.Bytes()); werr != nil && err == nil {
+			err = werr
+		}
+		return err
+	}
+}
+
+func (m *etagMiddleware) etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if m.weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// etagWriter buffers the body of successful responses, other responses and
+// flushed responses are written through.
+type etagWriter struct {
+	http.ResponseWriter
+	buf         bytes.Buffer
+	statusCode  int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (w *etagWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+	if statusCode != http.StatusOK {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush implements http.Flusher, the buffered body is written and the subsequent
+// writes are written through.
+func (w *etagWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.passthrough {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.statusCode)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errHijackerNotSupported
+}
+
+// Unwrap returns the original http.ResponseWriter.
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	// RFC 7232 section 4.1: a sender should not generate representation metadata
+	// other than the validators and caching headers.
+	header := w.Header()
+	header.Del(headerContentType)
+	header.Del("Content-Length")
+	header.Del(headerContentEncoding)
+	if header.Get(headerETag) != "" {
+		header.Del(headerLastModified)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// isNotModified evaluates the If-None-Match and If-Modified-Since headers of GET
+// and HEAD requests with the validators of response.
+func isNotModified(r *http.Request, header http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" {
+		return matchETag(inm, header.Get(headerETag), true)
+	}
+	ims, err := http.ParseTime(r.Header.Get(headerIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get(headerLastModified))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get(headerIfMatch) != "" ||
+		r.Header.Get(headerIfUnmodifiedSince) != "" ||
+		r.Header.Get(headerIfNoneMatch) != ""
+}
+
+// checkPreconditions evaluates the If-Match, If-Unmodified-Since and If-None-Match
+// headers of the request in the order of RFC 7232 section 6, an empty ETag and
+// zero time indicate that the resource does not exist.
+func checkPreconditions(r *http.Request, etag string, lastModified time.Time) bool {
+	if im := r.Header.Get(headerIfMatch); im != "" {
+		if !matchETag(im, etag, false) {
+			return false
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get(headerIfUnmodifiedSince)); err == nil {
+		if lastModified.IsZero() || lastModified.Truncate(time.Second).After(ius) {
+			return false
+		}
+	}
+	if inm := r.Header.Get(headerIfNoneMatch); inm != "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if matchETag(inm, etag, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchETag reports whether the list of entity tags matches the ETag, by weak
+// comparison if weak is true, otherwise by strong comparison.
+func matchETag(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	for list != "" {
+		var tag string
+		tag, list = scanETag(list)
+		if tag == "" {
+			return false
+		}
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scanETag returns the first entity tag of the list and the remaining list, the
+// tag is empty if the list is malformed.
+func scanETag(list string) (etag, remain string) {
+	list = strings.TrimLeft(list, " \t,")
+	start := 0
+	if strings.HasPrefix(list, "W/") {
+		start = 2
+	}
+	if len(list[start:]) < 2 || list[start] != '"' {
+		return "", ""
+	}
+	end := strings.IndexByte(list[start+1:], '"')
+	if end == -1 {
+		return "", ""
+	}
+	end += start + 2
+	return list[:end], strings.TrimLeft(list[end:], " \t,")
+}
@@ -0,0 +1,266 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextSetETag(t *testing.T) {
+	cases := map[string]string{
+		"v1":       `"v1"`,
+		`"v1"`:     `"v1"`,
+		`W/"v1"`:   `W/"v1"`,
+		`W/v1`:     `W/"v1"`,
+		`W/`:       `W/""`,
+		`v1"`:      `"v1""`,
+		"":         `""`,
+		`"foo bar`: `""foo bar"`,
+	}
+	for etag, expected := range cases {
+		c := newContext(httptest.NewRecorder(), nil)
+		c.SetETag(etag)
+		assert.Equal(t, expected, c.Response.Header().Get("ETag"), etag)
+	}
+}
+
+func TestContextSetLastModified(t *testing.T) {
+	c := newContext(httptest.NewRecorder(), nil)
+	c.SetLastModified(time.Date(2020, 1, 2, 11, 4, 5, 0, time.FixedZone("", 3600)))
+	assert.Equal(t, "Thu, 02 Jan 2020 10:04:05 GMT", c.Response.Header().Get("Last-Modified"))
+}
+
+func TestMatchETag(t *testing.T) {
+	cases := []struct {
+		list     string
+		etag     string
+		weak     bool
+		expected bool
+	}{
+		{`"v1"`, "", true, false},
+		{`*`, `"v1"`, false, true},
+		{`"v1"`, `"v1"`, false, true},
+		{`"v0", "v1"`, `"v1"`, false, true},
+		{`"v0","v1"`, `"v1"`, false, true},
+		{`"v0"`, `"v1"`, true, false},
+		{`W/"v1"`, `"v1"`, false, false},
+		{`"v1"`, `W/"v1"`, false, false},
+		{`W/"v1"`, `"v1"`, true, true},
+		{`"v1"`, `W/"v1"`, true, true},
+		{`"a,b"`, `"a,b"`, false, true},
+		{`v1`, `"v1"`, true, false},
+		{`"v1`, `"v1"`, true, false},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, matchETag(test.list, test.etag, test.weak), test.list)
+	}
+}
+
+func TestContextNotModified(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		method   string
+		header   map[string]string
+		expected bool
+	}{
+		{http.MethodGet, nil, false},
+		{http.MethodGet, map[string]string{"If-None-Match": `"v1"`}, true},
+		{http.MethodHead, map[string]string{"If-None-Match": `W/"v1"`}, true},
+		{http.MethodGet, map[string]string{"If-None-Match": `"v2"`}, false},
+		{http.MethodPut, map[string]string{"If-None-Match": `"v1"`}, false},
+		{http.MethodGet, map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, true},
+		{http.MethodGet, map[string]string{"If-Modified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}, false},
+		{http.MethodGet, map[string]string{"If-Modified-Since": "invalid"}, false},
+		// If-None-Match takes precedence.
+		{http.MethodGet, map[string]string{"If-None-Match": `"v2"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)}, false},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, "/", nil)
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		c := newContext(w, req)
+		c.SetETag("v1")
+		c.SetLastModified(lastModified)
+		c.SetContentType(headerContentTypeJSON)
+		assert.Equal(t, test.expected, c.NotModified(), test.header)
+		if test.expected {
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+			assert.Equal(t, "", w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestContextCheckPreconditions(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		method   string
+		header   map[string]string
+		etag     string
+		expected error
+	}{
+		{http.MethodPut, nil, "v1", nil},
+		{http.MethodPut, map[string]string{"If-Match": `"v1"`}, "v1", nil},
+		{http.MethodPut, map[string]string{"If-Match": `"v0", "v1"`}, "v1", nil},
+		{http.MethodPut, map[string]string{"If-Match": `"v2"`}, "v1", ErrPreconditionFailed},
+		{http.MethodPut, map[string]string{"If-Match": `W/"v1"`}, "v1", ErrPreconditionFailed},
+		{http.MethodPut, map[string]string{"If-Match": `*`}, "v1", nil},
+		{http.MethodPut, map[string]string{"If-Match": `*`}, "", ErrPreconditionFailed},
+		{http.MethodPut, map[string]string{"If-Unmodified-Since": lastModified.Format(http.TimeFormat)}, "v1", nil},
+		{http.MethodPut, map[string]string{"If-Unmodified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}, "v1", ErrPreconditionFailed},
+		// If-Match takes precedence.
+		{http.MethodPut, map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": lastModified.Add(-time.Second).Format(http.TimeFormat)}, "v1", nil},
+		{http.MethodPut, map[string]string{"If-None-Match": `*`}, "", nil},
+		{http.MethodPut, map[string]string{"If-None-Match": `*`}, "v1", ErrPreconditionFailed},
+		{http.MethodGet, map[string]string{"If-None-Match": `*`}, "v1", nil},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(test.method, "/", nil)
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		c := newContext(httptest.NewRecorder(), req)
+		if test.etag != "" {
+			c.SetETag(test.etag)
+			c.SetLastModified(lastModified)
+		}
+		assert.Equal(t, test.expected, c.CheckPreconditions(), test.header)
+	}
+}
+
+func TestETag(t *testing.T) {
+	body := `{"id":1}`
+	etag := (&etagMiddleware{}).etag([]byte(body))
+	weakETag := (&etagMiddleware{weak: true}).etag([]byte(body))
+	assert.Equal(t, "W/"+etag, weakETag)
+
+	cases := []struct {
+		opts        []ETagOption
+		method      string
+		path        string
+		ifNoneMatch string
+		code        int
+		body        string
+		etag        string
+	}{
+		{nil, http.MethodGet, "/", "", http.StatusOK, body, etag},
+		{nil, http.MethodGet, "/", etag, http.StatusNotModified, "", etag},
+		{nil, http.MethodGet, "/", weakETag, http.StatusNotModified, "", etag},
+		{nil, http.MethodGet, "/", `"foo"`, http.StatusOK, body, etag},
+		{nil, http.MethodPost, "/", etag, http.StatusOK, body, ""},
+		{[]ETagOption{ETagWeak(true)}, http.MethodGet, "/", "", http.StatusOK, body, weakETag},
+		{[]ETagOption{ETagWeak(true)}, http.MethodGet, "/", etag, http.StatusNotModified, "", weakETag},
+		{[]ETagOption{ETagSkipper(func(c *Context) bool { return true })}, http.MethodGet, "/", etag, http.StatusOK, body, ""},
+		{nil, http.MethodGet, "/custom", `"v1"`, http.StatusNotModified, "", `"v1"`},
+		{nil, http.MethodGet, "/custom", etag, http.StatusOK, body, `"v1"`},
+		{nil, http.MethodGet, "/created", "", http.StatusCreated, body, ""},
+		{nil, http.MethodGet, "/empty", "", http.StatusNoContent, "", ""},
+		{nil, http.MethodGet, "/error", "", http.StatusInternalServerError, "Internal Server Error\n", ""},
+		{nil, http.MethodGet, "/shortcut", `"v1"`, http.StatusNotModified, "", `"v1"`},
+		{nil, http.MethodGet, "/shortcut", `"v0"`, http.StatusOK, body, `"v1"`},
+		{nil, http.MethodGet, "/flush", etag, http.StatusOK, body, ""},
+	}
+	for _, test := range cases {
+		app := Pure()
+		app.Use(ErrorHandler(), ETag(test.opts...))
+		app.Any("/", func(c *Context) error {
+			return c.Blob(http.StatusOK, headerContentTypeJSON, []byte(body))
+		})
+		app.Get("/custom", func(c *Context) error {
+			c.SetETag("v1")
+			return c.Blob(http.StatusOK, headerContentTypeJSON, []byte(body))
+		})
+		app.Get("/created", func(c *Context) error {
+			return c.Blob(http.StatusCreated, headerContentTypeJSON, []byte(body))
+		})
+		app.Get("/empty", func(c *Context) error {
+			c.Response.WriteHeader(http.StatusNoContent)
+			return nil
+		})
+		app.Get("/error", func(c *Context) error {
+			return errors.New("error")
+		})
+		app.Get("/shortcut", func(c *Context) error {
+			c.SetETag("v1")
+			if c.NotModified() {
+				return nil
+			}
+			return c.Blob(http.StatusOK, headerContentTypeJSON, []byte(body))
+		})
+		app.Get("/flush", func(c *Context) error {
+			c.Response.Write([]byte(body[:2]))
+			c.Response.(http.Flusher).Flush()
+			c.Response.Write([]byte(body[2:]))
+			return nil
+		})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("If-None-Match", test.ifNoneMatch)
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.etag, w.Header().Get("ETag"), test.path)
+		if test.code == http.StatusNotModified {
+			assert.Equal(t, "", w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestETagValidator(t *testing.T) {
+	lastModified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	errValidator := errors.New("validator error")
+	cases := []struct {
+		method string
+		path   string
+		header map[string]string
+		code   int
+		called bool
+	}{
+		{http.MethodPut, "/posts/1", nil, http.StatusOK, true},
+		{http.MethodPut, "/posts/1", map[string]string{"If-Match": `"v1"`}, http.StatusOK, true},
+		{http.MethodPatch, "/posts/1", map[string]string{"If-Match": `"v0"`}, http.StatusPreconditionFailed, false},
+		{http.MethodDelete, "/posts/1", map[string]string{"If-Unmodified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusPreconditionFailed, false},
+		{http.MethodPut, "/posts/2", map[string]string{"If-Match": `*`}, http.StatusPreconditionFailed, false},
+		{http.MethodPut, "/posts/2", map[string]string{"If-None-Match": `*`}, http.StatusOK, true},
+		{http.MethodPut, "/posts/1", map[string]string{"If-None-Match": `*`}, http.StatusPreconditionFailed, false},
+		{http.MethodPut, "/posts/3", map[string]string{"If-Match": `"v1"`}, http.StatusInternalServerError, false},
+	}
+	for _, test := range cases {
+		called := false
+		app := Pure()
+		app.Use(ErrorHandler())
+		// the validator depends on the route parameters.
+		validator := ETag(ETagValidator(func(c *Context) (string, time.Time, error) {
+			switch c.Params.String("id") {
+			case "1":
+				return `"v1"`, lastModified, nil
+			case "2":
+				return "", time.Time{}, nil
+			default:
+				return "", time.Time{}, errValidator
+			}
+		}))
+		app.Handle(test.method, "/posts/:id", func(c *Context) error {
+			called = true
+			return c.String(http.StatusOK, "updated")
+		}, RouteMiddleware(validator))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.header)
+		assert.Equal(t, test.called, called, test.header)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	headerForwarded       = "Forwarded"
+	headerXForwardedFor   = "X-Forwarded-For"
+	headerXForwardedHost  = "X-Forwarded-Host"
+	headerXForwardedProto = "X-Forwarded-Proto"
+	headerXRealIP         = "X-Real-IP"
+)
+
+// ForwardedHeaders is the family of forwarding headers that trusted proxies set.
+// The headers of the other family are always ignored, since proxies usually pass
+// them through unchanged, which allows clients to spoof them.
+type ForwardedHeaders int
+
+// Families of forwarding headers.
+const (
+	// XForwardedHeaders refers to X-Forwarded-For, X-Forwarded-Host,
+	// X-Forwarded-Proto and X-Real-IP, it is the default.
+	XForwardedHeaders ForwardedHeaders = iota
+	// RFC7239Headers refers to the RFC 7239 Forwarded header.
+	RFC7239Headers
+)
+
+// SetTrustedProxies sets the proxies whose forwarding headers are trusted.
+// Each proxy is either a CIDR notation such as "10.0.0.0/8", or a single IP address.
+//
+// Forwarding headers are ignored unless the request comes from one of the trusted
+// proxies, and only the family of Application.ForwardedHeaders is taken into
+// account.
+func (app *Application) SetTrustedProxies(proxies ...string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		ipNet, err := parseProxy(proxy)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipNet)
+	}
+	app.trustedProxies = nets
+	return nil
+}
+
+func parseProxy(proxy string) (*net.IPNet, error) {
+	if strings.IndexByte(proxy, '/') >= 0 {
+		_, ipNet, err := net.ParseCIDR(proxy)
+		return ipNet, err
+	}
+	ip := net.ParseIP(proxy)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy address %q", proxy)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (app *Application) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range app.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteHost returns the host part of the given address, the address itself
+// will be returned if it does not contain a port.
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// forwardedElement is an element of RFC 7239 Forwarded header.
+type forwardedElement struct {
+	forValue string
+	host     string
+	proto    string
+}
+
+// parseForwarded parses the Forwarded headers, the elements are ordered from
+// the client to the closest proxy.
+func parseForwarded(header http.Header) (elements []forwardedElement) {
+	for _, value := range header[headerForwarded] {
+		for _, part := range strings.Split(value, ",") {
+			var element forwardedElement
+			for _, pair := range strings.Split(part, ";") {
+				i := strings.IndexByte(pair, '=')
+				if i < 0 {
+					continue
+				}
+				key := strings.ToLower(strings.TrimSpace(pair[:i]))
+				val := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+				switch key {
+				case "for":
+					element.forValue = remoteHost(val)
+				case "host":
+					element.host = val
+				case "proto":
+					element.proto = strings.ToLower(val)
+				}
+			}
+			elements = append(elements, element)
+		}
+	}
+	return
+}
+
+// forwardedFor returns the addresses of the client and proxies that forwarded
+// the request, ordered from the client to the closest proxy.
+func forwardedFor(header http.Header, headers ForwardedHeaders) (addrs []string) {
+	if headers == RFC7239Headers {
+		for _, element := range parseForwarded(header) {
+			addrs = append(addrs, element.forValue)
+		}
+		return
+	}
+
+	for _, value := range header[headerXForwardedFor] {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, remoteHost(strings.TrimSpace(addr)))
+		}
+	}
+	return
+}
+
+// forwardedElementValue returns the value of Forwarded elements that was appended
+// by the outermost trusted proxy, the elements are walked from right to left until
+// the one whose "for" parameter is not a trusted proxy. The closer non-empty value
+// is returned if the outermost one is empty.
+func (c *Context) forwardedElementValue(get func(forwardedElement) string) (value string) {
+	elements := parseForwarded(c.Request.Header)
+	for i := len(elements) - 1; i >= 0; i-- {
+		if v := get(elements[i]); v != "" {
+			value = v
+		}
+		if !c.app.isTrustedProxy(net.ParseIP(elements[i].forValue)) {
+			break
+		}
+	}
+	return
+}
+
+// forwardedHeaderValue returns the value of comma separated header, such as
+// X-Forwarded-Host, that was appended by the outermost trusted proxy. The number
+// of trusted proxies is determined by X-Forwarded-For.
+func (c *Context) forwardedHeaderValue(key string) string {
+	var values []string
+	for _, value := range c.Request.Header[key] {
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	i := len(values) - c.trustedHops()
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+// trustedHops returns the number of trusted proxies that forwarded the request,
+// including the closest one.
+func (c *Context) trustedHops() int {
+	var addrs []string
+	for _, value := range c.Request.Header[headerXForwardedFor] {
+		for _, addr := range strings.Split(value, ",") {
+			addrs = append(addrs, remoteHost(strings.TrimSpace(addr)))
+		}
+	}
+	hops := 1
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !c.app.isTrustedProxy(net.ParseIP(addrs[i])) {
+			break
+		}
+		hops++
+	}
+	return hops
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicationSetTrustedProxies(t *testing.T) {
+	app := Pure()
+	assert.Nil(t, app.SetTrustedProxies("10.0.0.0/8", "192.168.1.1", "::1", "fd00::/8"))
+	assert.Len(t, app.trustedProxies, 4)
+
+	cases := []struct {
+		ip      string
+		trusted bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::1", true},
+		{"fd12::1", true},
+		{"127.0.0.1", false},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.trusted, app.isTrustedProxy(net.ParseIP(test.ip)), test.ip)
+	}
+	assert.False(t, app.isTrustedProxy(nil))
+
+	assert.NotNil(t, app.SetTrustedProxies("10.0.0.0/33"))
+	assert.NotNil(t, app.SetTrustedProxies("invalid"))
+}
+
+func TestRemoteHost(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.1:1234":    "192.0.2.1",
+		"192.0.2.1":         "192.0.2.1",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"[2001:db8::1]":     "2001:db8::1",
+		"2001:db8::1":       "2001:db8::1",
+	}
+	for addr, expected := range cases {
+		assert.Equal(t, expected, remoteHost(addr), addr)
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	header := http.Header{}
+	header.Add("Forwarded", `for=192.0.2.60;proto=HTTPS;host=example.com, for="[2001:db8:cafe::17]:4711"`)
+	header.Add("Forwarded", `for=unknown;by=203.0.113.43, invalid`)
+	elements := parseForwarded(header)
+	assert.Equal(t, []forwardedElement{
+		{forValue: "192.0.2.60", host: "example.com", proto: "https"},
+		{forValue: "2001:db8:cafe::17"},
+		{forValue: "unknown"},
+		{},
+	}, elements)
+}
+
+func TestForwardedFor(t *testing.T) {
+	header := http.Header{}
+	assert.Empty(t, forwardedFor(header, XForwardedHeaders))
+	assert.Empty(t, forwardedFor(header, RFC7239Headers))
+
+	header.Add("X-Forwarded-For", "192.0.2.1, 10.0.0.1")
+	header.Add("X-Forwarded-For", "10.0.0.2")
+	header.Set("Forwarded", "for=192.0.2.2, for=10.0.0.3")
+	assert.Equal(t, []string{"192.0.2.1", "10.0.0.1", "10.0.0.2"}, forwardedFor(header, XForwardedHeaders))
+	assert.Equal(t, []string{"192.0.2.2", "10.0.0.3"}, forwardedFor(header, RFC7239Headers))
+}
+
+func TestContext_ForwardedHeaderValue(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	cases := []struct {
+		header http.Header
+		value  string
+	}{
+		{http.Header{}, ""},
+		{http.Header{"X-Forwarded-Host": {"foo.com"}}, "foo.com"},
+		{http.Header{"X-Forwarded-Host": {"spoof.com, foo.com"}}, "foo.com"},
+		{http.Header{"X-Forwarded-Host": {"spoof.com", "foo.com"}}, "foo.com"},
+		{http.Header{"X-Forwarded-Host": {"spoof.com, foo.com, bar.com"}, "X-Forwarded-For": {"192.0.2.1, 10.0.0.2"}}, "foo.com"},
+		{http.Header{"X-Forwarded-Host": {"foo.com, bar.com"}, "X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "foo.com"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = test.header
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.value, c.forwardedHeaderValue(headerXForwardedHost))
+	}
+}
+
+func TestContext_ForwardedElementValue(t *testing.T) {
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	host := func(e forwardedElement) string { return e.host }
+	cases := []struct {
+		forwarded string
+		value     string
+	}{
+		{"", ""},
+		{"host=foo.com", "foo.com"},
+		{"for=192.0.2.1;host=spoof.com, for=192.0.2.1;host=foo.com", "foo.com"},
+		{"for=192.0.2.1;host=foo.com, for=10.0.0.2;host=bar.com", "foo.com"},
+		{"for=192.0.2.1;host=foo.com, for=10.0.0.2", "foo.com"},
+		{"for=192.0.2.1, for=10.0.0.2;host=bar.com", "bar.com"},
+	}
+	for _, test := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.forwarded != "" {
+			req.Header.Set(headerForwarded, test.forwarded)
+		}
+		c := newContext(nil, req)
+		c.app = app
+		assert.Equal(t, test.value, c.forwardedElementValue(host), test.forwarded)
+	}
+}
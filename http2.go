@@ -0,0 +1,54 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTP2Config contains the settings of HTTP/2, zero values mean the defaults of
+// http2.Server. It requires go1.17 or later, running a server with the settings
+// fails on earlier versions.
+type HTTP2Config struct {
+	// H2C enables HTTP/2 over cleartext TCP, both with prior knowledge and via the
+	// "Upgrade: h2c" header. It is ignored by TLS servers, which negotiate HTTP/2
+	// via ALPN.
+	H2C bool
+
+	// MaxConcurrentStreams is the number of concurrent streams that each client
+	// may have open at a time.
+	MaxConcurrentStreams uint32
+
+	// MaxReadFrameSize is the largest frame size that the server will read, valid
+	// values range from 16KB to 16MB.
+	MaxReadFrameSize uint32
+
+	// IdleTimeout is how long an idle connection is kept open, defaults to the
+	// IdleTimeout or ReadTimeout of Server.
+	IdleTimeout time.Duration
+
+	// MaxUploadBufferPerConnection and MaxUploadBufferPerStream are the flow
+	// control windows of connections and streams.
+	MaxUploadBufferPerConnection int32
+	MaxUploadBufferPerStream     int32
+}
+
+// Push initiates an HTTP/2 server push of the target, it returns
+// http.ErrNotSupported if the connection does not support server push, see
+// http.Pusher.
+func (c *Context) Push(target string, opts *http.PushOptions) error {
+	w := c.Response
+	for {
+		if pusher, ok := w.(http.Pusher); ok {
+			return pusher.Push(target, opts)
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return http.ErrNotSupported
+		}
+		w = u.Unwrap()
+	}
+}
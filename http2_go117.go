@@ -0,0 +1,42 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.17
+// +build go1.17
+
+package clevergo
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func (cfg *HTTP2Config) server() *http2.Server {
+	return &http2.Server{
+		MaxConcurrentStreams:         cfg.MaxConcurrentStreams,
+		MaxReadFrameSize:             cfg.MaxReadFrameSize,
+		IdleTimeout:                  cfg.IdleTimeout,
+		MaxUploadBufferPerConnection: cfg.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     cfg.MaxUploadBufferPerStream,
+	}
+}
+
+// configureHTTP2 applies the HTTP/2 settings to the server, the handler is wrapped
+// to serve h2c if enabled.
+func (app *Application) configureHTTP2(tls bool) error {
+	if app.HTTP2 == nil {
+		return nil
+	}
+	h2s := app.HTTP2.server()
+	if app.http2Server != app.Server {
+		if err := http2.ConfigureServer(app.Server, h2s); err != nil {
+			return err
+		}
+		app.http2Server = app.Server
+	}
+	if app.HTTP2.H2C && !tls {
+		app.Server.Handler = h2c.NewHandler(app, h2s)
+	}
+	return nil
+}
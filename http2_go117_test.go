@@ -0,0 +1,121 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.17
+// +build go1.17
+
+package clevergo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func serveHTTP2TestApp(t *testing.T, app *Application) (string, func()) {
+	assert.Nil(t, app.initServer(false))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go app.Server.Serve(ln)
+	return ln.Addr().String(), func() {
+		app.Server.Close()
+	}
+}
+
+func newHTTP2TestApp() *Application {
+	app := Pure()
+	app.Get("/", func(c *Context) error {
+		return c.String(http.StatusOK, c.Request.Proto)
+	})
+	return app
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	app := newHTTP2TestApp()
+	app.HTTP2 = &HTTP2Config{H2C: true, MaxConcurrentStreams: 10}
+	addr, closeFunc := serveHTTP2TestApp(t, app)
+	defer closeFunc()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+	resp, err := client.Get("http://" + addr + "/")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "HTTP/2.0", string(body))
+
+	// HTTP/1.1 is still supported.
+	resp, err = http.Get("http://" + addr + "/")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	app := newHTTP2TestApp()
+	app.HTTP2 = &HTTP2Config{H2C: true}
+	addr, closeFunc := serveHTTP2TestApp(t, app)
+	defer closeFunc()
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+addr+"\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+}
+
+func TestH2CDisabled(t *testing.T) {
+	app := newHTTP2TestApp()
+	app.HTTP2 = &HTTP2Config{MaxReadFrameSize: 1 << 20}
+	addr, closeFunc := serveHTTP2TestApp(t, app)
+	defer closeFunc()
+
+	conn, err := net.Dial("tcp", addr)
+	assert.Nil(t, err)
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+addr+"\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAARAAAAAAAIAAAAA\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, app, app.Server.Handler)
+
+	// configures once.
+	assert.Nil(t, app.initServer(false))
+
+	// configures the replaced server.
+	app.Server = &http.Server{}
+	assert.Nil(t, app.initServer(false))
+	assert.NotNil(t, app.Server.TLSNextProto[http2.NextProtoTLS])
+}
+
+func TestHTTP2Config(t *testing.T) {
+	cfg := &HTTP2Config{
+		MaxConcurrentStreams:         100,
+		MaxReadFrameSize:             1 << 20,
+		MaxUploadBufferPerConnection: 1 << 20,
+		MaxUploadBufferPerStream:     1 << 16,
+	}
+	s := cfg.server()
+	assert.Equal(t, uint32(100), s.MaxConcurrentStreams)
+	assert.Equal(t, uint32(1<<20), s.MaxReadFrameSize)
+	assert.Equal(t, int32(1<<20), s.MaxUploadBufferPerConnection)
+	assert.Equal(t, int32(1<<16), s.MaxUploadBufferPerStream)
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build !go1.17
+// +build !go1.17
+
+package clevergo
+
+import "errors"
+
+// configureHTTP2 fails if HTTP/2 settings are present, since golang.org/x/net
+// requires go1.17 or later.
+func (app *Application) configureHTTP2(tls bool) error {
+	if app.HTTP2 == nil {
+		return nil
+	}
+	return errors.New("clevergo: HTTP2Config requires go1.17 or later")
+}
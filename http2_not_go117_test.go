@@ -0,0 +1,22 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build !go1.17
+// +build !go1.17
+
+package clevergo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTP2ConfigUnsupported(t *testing.T) {
+	app := Pure()
+	assert.Nil(t, app.initServer(false))
+
+	app.HTTP2 = &HTTP2Config{H2C: true}
+	assert.NotNil(t, app.initServer(false))
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePusher struct {
+	http.ResponseWriter
+	targets []string
+}
+
+func (p *fakePusher) Push(target string, opts *http.PushOptions) error {
+	p.targets = append(p.targets, target)
+	return nil
+}
+
+func TestContextPush(t *testing.T) {
+	c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.ErrNotSupported, c.Push("/app.js", nil))
+
+	pusher := &fakePusher{ResponseWriter: httptest.NewRecorder()}
+	c.Response = newResponseWriter(pusher)
+	assert.Nil(t, c.Push("/app.js", nil))
+	assert.Nil(t, c.Push("/app.css", &http.PushOptions{}))
+	assert.Equal(t, []string{"/app.js", "/app.css"}, pusher.targets)
+}
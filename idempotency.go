@@ -0,0 +1,298 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	headerIdempotencyKey      = "Idempotency-Key"
+	headerIdempotentReplayed  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	defaultIdempotencyTTL     = 24 * time.Hour
+	defaultIdempotencyLockTTL = time.Minute
+	defaultIdempotencyMaxBody = 1 << 20
+)
+
+// Idempotency errors.
+var (
+	ErrIdempotencyKeyMissing    = StatusError{http.StatusBadRequest, errors.New("idempotency key is missing or invalid")}
+	ErrIdempotencyKeyInProgress = StatusError{http.StatusConflict, errors.New("a request with the same idempotency key is being processed")}
+	ErrIdempotencyKeyMismatch   = StatusError{http.StatusUnprocessableEntity, errors.New("idempotency key was used by a different request")}
+)
+
+// IdempotencyRecord is the state of an idempotency key.
+type IdempotencyRecord struct {
+	// Fingerprint of the request that used the key.
+	Fingerprint string `json:"fingerprint"`
+
+	// Completed indicates whether the response has been stored, the request is
+	// being processed if false.
+	Completed bool `json:"completed"`
+
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore is an interface that stores the records of idempotency keys.
+type IdempotencyStore interface {
+	// Acquire creates an in-progress record with the fingerprint that expires after
+	// the TTL if the key does not exist, and reports whether the record was created,
+	// otherwise, it returns the existing record. It must be atomic.
+	Acquire(key, fingerprint string, ttl time.Duration) (record *IdempotencyRecord, acquired bool, err error)
+
+	// Save saves the completed record that expires after the TTL.
+	Save(key string, record *IdempotencyRecord, ttl time.Duration) error
+
+	// Delete deletes the record of the key, so that the key can be used again.
+	Delete(key string) error
+}
+
+// IdempotencyOption is a function that receives an idempotency middleware.
+type IdempotencyOption func(*idempotency)
+
+// IdempotencyHeader is an option that sets the header name of keys, defaults to
+// "Idempotency-Key".
+func IdempotencyHeader(name string) IdempotencyOption {
+	return func(m *idempotency) {
+		m.header = name
+	}
+}
+
+// IdempotencyMethods is an option that sets the methods that support idempotency
+// keys, defaults to POST and PATCH.
+func IdempotencyMethods(methods ...string) IdempotencyOption {
+	return func(m *idempotency) {
+		m.methods = methods
+	}
+}
+
+// IdempotencyRequired is an option that indicates whether the key is required,
+// ErrIdempotencyKeyMissing will be returned if it is absent.
+func IdempotencyRequired(required bool) IdempotencyOption {
+	return func(m *idempotency) {
+		m.required = required
+	}
+}
+
+// IdempotencyScope is an option that sets the function that returns the scope of
+// keys, such as the user ID, so that the keys of different clients never conflict.
+// It defaults to the hash of Authorization and Cookie headers.
+func IdempotencyScope(f func(c *Context) string) IdempotencyOption {
+	return func(m *idempotency) {
+		m.scope = f
+	}
+}
+
+// IdempotencyTTL is an option that sets how long the responses are kept, defaults
+// to 24 hours.
+func IdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(m *idempotency) {
+		m.ttl = ttl
+	}
+}
+
+// IdempotencyLockTTL is an option that sets how long a key is locked by the request
+// that is being processed, defaults to one minute. The lock will be released once
+// the request completed, the TTL prevents keys from being locked forever if the
+// process crashed, it should be longer than the processing time of requests.
+func IdempotencyLockTTL(ttl time.Duration) IdempotencyOption {
+	return func(m *idempotency) {
+		m.lockTTL = ttl
+	}
+}
+
+// IdempotencyMaxBodySize is an option that sets the max size of request body that
+// is buffered for fingerprinting, defaults to 1MB, the larger requests are rejected
+// with ErrRequestEntityTooLarge (413).
+func IdempotencyMaxBodySize(size int64) IdempotencyOption {
+	return func(m *idempotency) {
+		m.maxBodySize = size
+	}
+}
+
+// IdempotencySkipper is an option that sets skipper.
+func IdempotencySkipper(skipper Skipper) IdempotencyOption {
+	return func(m *idempotency) {
+		m.skipper = skipper
+	}
+}
+
+// Idempotency returns a middleware that implements the Idempotency-Key header, so
+// that clients can safely retry unsafe requests, such as creating payments.
+//
+// The first response of a key is stored along with the fingerprint of request,
+// which is computed from method, URI and body, and replayed on retries with the
+// "Idempotent-Replayed: true" header. The concurrent requests of the key are
+// rejected with ErrIdempotencyKeyInProgress (409), and the requests that reuse the
+// key with different fingerprints are rejected with ErrIdempotencyKeyMismatch (422).
+//
+// The keys are scoped to the credentials of clients by default, see IdempotencyScope.
+// The key is released if the handler returned an error, the response status code
+// is 5xx or the response body is larger than 1MB, so that the request can be retried.
+func Idempotency(store IdempotencyStore, opts ...IdempotencyOption) MiddlewareFunc {
+	m := &idempotency{
+		store:       store,
+		header:      headerIdempotencyKey,
+		methods:     []string{http.MethodPost, http.MethodPatch},
+		ttl:         defaultIdempotencyTTL,
+		lockTTL:     defaultIdempotencyLockTTL,
+		maxBodySize: defaultIdempotencyMaxBody,
+		scope:       credentialScope,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.middleware
+}
+
+type idempotency struct {
+	store       IdempotencyStore
+	header      string
+	methods     []string
+	required    bool
+	scope       func(c *Context) string
+	ttl         time.Duration
+	lockTTL     time.Duration
+	maxBodySize int64
+	skipper     Skipper
+}
+
+func (m *idempotency) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+		if !m.isMethodSupported(c.Request.Method) {
+			return next(c)
+		}
+		key := c.GetHeader(m.header)
+		if key == "" && !m.required {
+			return next(c)
+		}
+		if key == "" || len(key) > maxIdempotencyKeyLength {
+			return ErrIdempotencyKeyMissing
+		}
+		if m.scope != nil {
+			if scope := m.scope(c); scope != "" {
+				key = scope + "\n" + key
+			}
+		}
+
+		fingerprint, err := m.fingerprint(c.Request)
+		if err != nil {
+			return err
+		}
+		record, acquired, err := m.store.Acquire(key, fingerprint, m.lockTTL)
+		if err != nil {
+			return err
+		}
+		if !acquired {
+			switch {
+			case record.Fingerprint != fingerprint:
+				return ErrIdempotencyKeyMismatch
+			case !record.Completed:
+				return ErrIdempotencyKeyInProgress
+			}
+			c.SetHeader(headerIdempotentReplayed, "true")
+			return replayEntry(c, &cacheEntry{Status: record.Status, Header: record.Header, Body: record.Body})
+		}
+
+		return m.handle(c, next, key, fingerprint)
+	}
+}
+
+// handle calls the handler and stores the response, the key is released if the
+// response should not be stored.
+func (m *idempotency) handle(c *Context, next Handle, key, fingerprint string) (err error) {
+	saved := false
+	defer func() {
+		if !saved {
+			if derr := m.store.Delete(key); derr != nil && err == nil {
+				err = derr
+			}
+		}
+	}()
+
+	w := newCacheWriter(c.Response, defaultCacheMaxSize)
+	snapshot := cloneHeader(c.Response.Header())
+	defer func(resp http.ResponseWriter) {
+		c.Response = resp
+	}(c.Response)
+	c.Response = w
+
+	if err = next(c); err != nil {
+		return err
+	}
+	if !w.wroteHeader || w.hijacked || w.exceeded || w.statusCode >= http.StatusInternalServerError {
+		return nil
+	}
+	record := &IdempotencyRecord{
+		Fingerprint: fingerprint,
+		Completed:   true,
+		Status:      w.statusCode,
+		Header:      headerDiff(snapshot, w.Header()),
+		Body:        w.buf.Bytes(),
+	}
+	if err = m.store.Save(key, record, m.ttl); err != nil {
+		return err
+	}
+	saved = true
+	return nil
+}
+
+// credentialScope returns the hash of Authorization and Cookie headers, so that the
+// credentials are not exposed to the store, an empty string will be returned if
+// neither of them is present.
+func credentialScope(c *Context) string {
+	authorization := c.Request.Header["Authorization"]
+	cookie := c.Request.Header["Cookie"]
+	if len(authorization) == 0 && len(cookie) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	io.WriteString(h, strings.Join(authorization, ",")+"\n"+strings.Join(cookie, ","))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m *idempotency) isMethodSupported(method string) bool {
+	for _, v := range m.methods {
+		if v == method {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprint returns the hash of method, URI and body, the body is buffered so
+// that the handler can read it again, ErrRequestEntityTooLarge will be returned if
+// the body exceeds the max size.
+func (m *idempotency) fingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, m.maxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if int64(len(body)) > m.maxBodySize {
+			return "", ErrRequestEntityTooLarge
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryIdempotencyStore is an idempotency store that stores records in memory,
+// expired records are evicted on access and periodically on acquiring.
+type MemoryIdempotencyStore struct {
+	mu         sync.Mutex
+	records    map[string]memoryIdempotencyRecord
+	gcInterval time.Duration
+	lastGC     time.Time
+	now        func() time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a memory idempotency store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records:    make(map[string]memoryIdempotencyRecord),
+		gcInterval: time.Minute,
+		now:        time.Now,
+	}
+}
+
+// Acquire implements IdempotencyStore.Acquire.
+func (s *MemoryIdempotencyStore) Acquire(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastGC) >= s.gcInterval {
+		s.gc(now)
+	}
+	if r, ok := s.records[key]; ok && now.Before(r.expires) {
+		return r.record, false, nil
+	}
+	s.records[key] = memoryIdempotencyRecord{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+	return nil, true, nil
+}
+
+// Save implements IdempotencyStore.Save.
+func (s *MemoryIdempotencyStore) Save(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	s.records[key] = memoryIdempotencyRecord{
+		record:  record,
+		expires: s.now().Add(ttl),
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete implements IdempotencyStore.Delete.
+func (s *MemoryIdempotencyStore) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.records, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// GC evicts expired records.
+func (s *MemoryIdempotencyStore) GC() {
+	s.mu.Lock()
+	s.gc(s.now())
+	s.mu.Unlock()
+}
+
+func (s *MemoryIdempotencyStore) gc(now time.Time) {
+	s.lastGC = now
+	for key, r := range s.records {
+		if !now.Before(r.expires) {
+			delete(s.records, key)
+		}
+	}
+}
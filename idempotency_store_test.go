@@ -0,0 +1,63 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryIdempotencyStore()
+	store.now = func() time.Time { return now }
+
+	record, acquired, err := store.Acquire("foo", "fp", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Nil(t, record)
+
+	record, acquired, err = store.Acquire("foo", "other", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, &IdempotencyRecord{Fingerprint: "fp"}, record)
+
+	completed := &IdempotencyRecord{Fingerprint: "fp", Completed: true, Status: 201, Body: []byte("created")}
+	assert.Nil(t, store.Save("foo", completed, time.Hour))
+	now = now.Add(30 * time.Minute)
+	record, acquired, _ = store.Acquire("foo", "fp", time.Minute)
+	assert.False(t, acquired)
+	assert.Equal(t, completed, record)
+
+	// expired.
+	now = now.Add(30 * time.Minute)
+	_, acquired, _ = store.Acquire("foo", "fp", time.Minute)
+	assert.True(t, acquired)
+
+	assert.Nil(t, store.Delete("foo"))
+	_, acquired, _ = store.Acquire("foo", "fp", time.Minute)
+	assert.True(t, acquired)
+}
+
+func TestMemoryIdempotencyStoreGC(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryIdempotencyStore()
+	store.now = func() time.Time { return now }
+	store.Acquire("foo", "fp", time.Minute)
+	store.Acquire("bar", "fp", time.Hour)
+
+	now = now.Add(time.Minute)
+	store.GC()
+	assert.Len(t, store.records, 1)
+	assert.Contains(t, store.records, "bar")
+
+	// periodically on acquiring.
+	now = now.Add(time.Hour)
+	store.Acquire("baz", "fp", time.Hour)
+	assert.Len(t, store.records, 1)
+	assert.Contains(t, store.records, "baz")
+}
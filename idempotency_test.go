@@ -0,0 +1,301 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type idempotencyTestServer struct {
+	app   *Application
+	store *MemoryIdempotencyStore
+	calls int32
+}
+
+func newIdempotencyTestServer(opts ...IdempotencyOption) *idempotencyTestServer {
+	s := &idempotencyTestServer{app: Pure(), store: NewMemoryIdempotencyStore()}
+	s.app.Use(ErrorHandler(), Idempotency(s.store, opts...))
+	s.app.Post("/payments", func(c *Context) error {
+		n := atomic.AddInt32(&s.calls, 1)
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		switch string(body) {
+		case "error":
+			return errors.New("error")
+		case "unavailable":
+			return c.String(http.StatusServiceUnavailable, "unavailable")
+		case "invalid":
+			return c.String(http.StatusBadRequest, "invalid")
+		}
+		c.SetHeader("Location", "/payments/"+strconv.Itoa(int(n)))
+		return c.String(http.StatusCreated, "payment "+strconv.Itoa(int(n))+": "+string(body))
+	})
+	s.app.Put("/payments", func(c *Context) error {
+		atomic.AddInt32(&s.calls, 1)
+		return c.String(http.StatusOK, "updated")
+	})
+	return s
+}
+
+func (s *idempotencyTestServer) do(method, path, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	s.app.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	s := newIdempotencyTestServer()
+	w1 := s.do(http.MethodPost, "/payments", "key1", "100")
+	assert.Equal(t, http.StatusCreated, w1.Code)
+	assert.Equal(t, "payment 1: 100", w1.Body.String())
+	assert.Equal(t, "", w1.Header().Get("Idempotent-Replayed"))
+
+	w2 := s.do(http.MethodPost, "/payments", "key1", "100")
+	assert.Equal(t, int32(1), s.calls)
+	assert.Equal(t, http.StatusCreated, w2.Code)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.Equal(t, "/payments/1", w2.Header().Get("Location"))
+	assert.Equal(t, headerContentTypeText, w2.Header().Get("Content-Type"))
+	assert.Equal(t, "true", w2.Header().Get("Idempotent-Replayed"))
+
+	// mismatched payload.
+	w := s.do(http.MethodPost, "/payments", "key1", "200")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	// mismatched URI.
+	w = s.do(http.MethodPost, "/payments?foo=bar", "key1", "100")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = s.do(http.MethodPost, "/payments", "key2", "100")
+	assert.Equal(t, "payment 2: 100", w.Body.String())
+
+	// without key.
+	w = s.do(http.MethodPost, "/payments", "", "100")
+	assert.Equal(t, "payment 3: 100", w.Body.String())
+	w = s.do(http.MethodPost, "/payments", "", "100")
+	assert.Equal(t, "payment 4: 100", w.Body.String())
+
+	// unsupported methods.
+	s.do(http.MethodPut, "/payments", "key1", "")
+	s.do(http.MethodPut, "/payments", "key1", "")
+	assert.Equal(t, int32(6), s.calls)
+}
+
+func TestIdempotencyReleasesKey(t *testing.T) {
+	cases := []struct {
+		body     string
+		code     int
+		released bool
+	}{
+		{"error", http.StatusInternalServerError, true},
+		{"unavailable", http.StatusServiceUnavailable, true},
+		{"invalid", http.StatusBadRequest, false},
+	}
+	for _, test := range cases {
+		s := newIdempotencyTestServer()
+		w := s.do(http.MethodPost, "/payments", "key", test.body)
+		assert.Equal(t, test.code, w.Code)
+		w = s.do(http.MethodPost, "/payments", "key", test.body)
+		assert.Equal(t, test.code, w.Code)
+		if test.released {
+			assert.Equal(t, int32(2), s.calls, test.body)
+			assert.Equal(t, "", w.Header().Get("Idempotent-Replayed"))
+		} else {
+			assert.Equal(t, int32(1), s.calls, test.body)
+			assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+		}
+	}
+}
+
+func TestIdempotencyConcurrentRequests(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	app := Pure()
+	app.Use(ErrorHandler(), Idempotency(store))
+	app.Post("/", func(c *Context) error {
+		close(started)
+		<-release
+		return c.String(http.StatusCreated, "created")
+	})
+
+	var wg sync.WaitGroup
+	w1 := httptest.NewRecorder()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foo"))
+		req.Header.Set("Idempotency-Key", "key")
+		app.ServeHTTP(w1, req)
+	}()
+	<-started
+
+	w2 := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foo"))
+	req.Header.Set("Idempotency-Key", "key")
+	app.ServeHTTP(w2, req)
+	assert.Equal(t, http.StatusConflict, w2.Code)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, http.StatusCreated, w1.Code)
+}
+
+func TestIdempotencyOptions(t *testing.T) {
+	s := newIdempotencyTestServer(
+		IdempotencyRequired(true),
+		IdempotencyHeader("X-Request-Key"),
+		IdempotencyMethods(http.MethodPost, http.MethodPut),
+		IdempotencyScope(func(c *Context) string { return c.GetHeader("X-User") }),
+		IdempotencySkipper(func(c *Context) bool { return c.QueryParam("skip") != "" }),
+	)
+	do := func(method, path, key, user, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Request-Key", key)
+		req.Header.Set("X-User", user)
+		s.app.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/payments", "", "foo", "100")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do(http.MethodPost, "/payments", strings.Repeat("k", 256), "foo", "100")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, int32(0), s.calls)
+
+	do(http.MethodPost, "/payments", "key", "foo", "100")
+	// the scopes are different.
+	w = do(http.MethodPost, "/payments", "key", "bar", "200")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, int32(2), s.calls)
+
+	do(http.MethodPut, "/payments", "put", "foo", "")
+	do(http.MethodPut, "/payments", "put", "foo", "")
+	assert.Equal(t, int32(3), s.calls)
+
+	do(http.MethodPost, "/payments?skip=1", "", "foo", "100")
+	assert.Equal(t, int32(4), s.calls)
+}
+
+func TestIdempotencyCredentialScope(t *testing.T) {
+	s := newIdempotencyTestServer()
+	do := func(name, value string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader("100"))
+		req.Header.Set("Idempotency-Key", "key")
+		if name != "" {
+			req.Header.Set(name, value)
+		}
+		s.app.ServeHTTP(w, req)
+		return w
+	}
+
+	cases := []struct {
+		name  string
+		value string
+	}{
+		{"Authorization", "Bearer foo"},
+		{"Authorization", "Bearer bar"},
+		{"Cookie", "session=foo"},
+		{"", ""},
+	}
+	for i, test := range cases {
+		w := do(test.name, test.value)
+		assert.Equal(t, "payment "+strconv.Itoa(i+1)+": 100", w.Body.String(), test.value)
+		assert.Equal(t, "", w.Header().Get("Idempotent-Replayed"), test.value)
+	}
+	// the responses are replayed to the same clients.
+	for i, test := range cases {
+		w := do(test.name, test.value)
+		assert.Equal(t, "payment "+strconv.Itoa(i+1)+": 100", w.Body.String(), test.value)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"), test.value)
+	}
+	assert.Equal(t, int32(len(cases)), s.calls)
+}
+
+func TestIdempotencyMaxBodySize(t *testing.T) {
+	s := newIdempotencyTestServer(IdempotencyMaxBodySize(3))
+	w := s.do(http.MethodPost, "/payments", "key1", "100")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = s.do(http.MethodPost, "/payments", "key2", "1000")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, int32(1), s.calls)
+
+	// the key was not acquired.
+	w = s.do(http.MethodPost, "/payments", "key2", "200")
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestIdempotencyTTL(t *testing.T) {
+	now := time.Now()
+	s := newIdempotencyTestServer(IdempotencyTTL(time.Hour), IdempotencyLockTTL(time.Second))
+	s.store.now = func() time.Time { return now }
+	s.do(http.MethodPost, "/payments", "key", "100")
+	now = now.Add(59 * time.Minute)
+	s.do(http.MethodPost, "/payments", "key", "100")
+	assert.Equal(t, int32(1), s.calls)
+	now = now.Add(time.Minute)
+	s.do(http.MethodPost, "/payments", "key", "100")
+	assert.Equal(t, int32(2), s.calls)
+}
+
+type errorIdempotencyStore struct {
+	*MemoryIdempotencyStore
+	acquireErr error
+	saveErr    error
+}
+
+func (s errorIdempotencyStore) Acquire(key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	if s.acquireErr != nil {
+		return nil, false, s.acquireErr
+	}
+	return s.MemoryIdempotencyStore.Acquire(key, fingerprint, ttl)
+}
+
+func (s errorIdempotencyStore) Save(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	return s.saveErr
+}
+
+func TestIdempotencyStoreError(t *testing.T) {
+	storeErr := errors.New("store error")
+	cases := []errorIdempotencyStore{
+		{MemoryIdempotencyStore: NewMemoryIdempotencyStore(), acquireErr: storeErr},
+		{MemoryIdempotencyStore: NewMemoryIdempotencyStore(), saveErr: storeErr},
+	}
+	for _, store := range cases {
+		var err error
+		app := Pure()
+		app.Use(func(next Handle) Handle {
+			return func(c *Context) error {
+				err = next(c)
+				return nil
+			}
+		}, Idempotency(store))
+		app.Post("/", echoHandler("foo"))
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Idempotency-Key", "key")
+		app.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, storeErr, err)
+		// the key is released.
+		assert.Len(t, store.records, 0)
+	}
+}
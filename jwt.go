@@ -0,0 +1,596 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"clevergo.tech/log"
+)
+
+// JWT algorithms.
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmES256 = "ES256"
+)
+
+const defaultJWTRolesClaim = "roles"
+
+// JWT errors.
+var (
+	ErrJWTMalformed        = errors.New("jwt: malformed token")
+	ErrJWTUnsupportedAlg   = errors.New("jwt: unsupported algorithm")
+	ErrJWTKeyNotFound      = errors.New("jwt: key not found")
+	ErrJWTInvalidSignature = errors.New("jwt: invalid signature")
+	ErrJWTExpired          = errors.New("jwt: token is expired")
+	ErrJWTNotValidYet      = errors.New("jwt: token is not valid yet")
+	ErrJWTInvalidIssuer    = errors.New("jwt: invalid issuer")
+	ErrJWTInvalidAudience  = errors.New("jwt: invalid audience")
+)
+
+var jwtErrors = []error{
+	ErrJWTMalformed, ErrJWTUnsupportedAlg, ErrJWTKeyNotFound, ErrJWTInvalidSignature,
+	ErrJWTExpired, ErrJWTNotValidYet, ErrJWTInvalidIssuer, ErrJWTInvalidAudience,
+}
+
+// isJWTError indicates whether the error is caused by an invalid token, rather
+// than an internal error, such as failing to load keys.
+func isJWTError(err error) bool {
+	for _, e := range jwtErrors {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTClaims is the claims set of JSON Web Token.
+type JWTClaims map[string]interface{}
+
+// String returns the string value of the given claim.
+func (c JWTClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Issuer returns the "iss" claim.
+func (c JWTClaims) Issuer() string {
+	return c.String("iss")
+}
+
+// Subject returns the "sub" claim.
+func (c JWTClaims) Subject() string {
+	return c.String("sub")
+}
+
+// Audience returns the "aud" claim, which can be either a string or an array of strings.
+func (c JWTClaims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		return stringValues(aud)
+	}
+	return nil
+}
+
+func stringValues(values []interface{}) []string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			s = append(s, str)
+		}
+	}
+	return s
+}
+
+// Time returns the time value of the given numeric date claim, such as "exp", "nbf" and "iat",
+// false if the claim is absent or not a number.
+func (c JWTClaims) Time(name string) (time.Time, bool) {
+	switch v := c[name].(type) {
+	case float64:
+		sec, frac := int64(v), v-float64(int64(v))
+		return time.Unix(sec, int64(frac*float64(time.Second))), true
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return JWTClaims{name: f}.Time(name)
+		}
+	}
+	return time.Time{}, false
+}
+
+// JWT is a verified JSON Web Token.
+type JWT struct {
+	Raw    string
+	Header map[string]interface{}
+	Claims JWTClaims
+
+	rolesClaim string
+}
+
+// Roles implements RoleHolder.Roles, the roles are read from the claim specified
+// by JWTVerifier.RolesClaim, which can be either an array of strings or a space
+// separated string, such as "scope".
+func (jwt *JWT) Roles() []string {
+	name := jwt.rolesClaim
+	if name == "" {
+		name = defaultJWTRolesClaim
+	}
+	switch roles := jwt.Claims[name].(type) {
+	case string:
+		return strings.Fields(roles)
+	case []interface{}:
+		return stringValues(roles)
+	}
+	return nil
+}
+
+// JWTKey is a verification key.
+type JWTKey struct {
+	// ID is the key ID that matches the "kid" header, empty ID matches any token.
+	ID string
+
+	// Algorithm restricts the key to the given algorithm, it is optional.
+	Algorithm string
+
+	// Key is a []byte for HS256, *rsa.PublicKey for RS256 and *ecdsa.PublicKey for ES256.
+	Key interface{}
+}
+
+func (k JWTKey) supports(alg string) bool {
+	if k.Algorithm != "" && k.Algorithm != alg {
+		return false
+	}
+	switch key := k.Key.(type) {
+	case []byte:
+		return alg == JWTAlgorithmHS256
+	case *rsa.PublicKey:
+		return alg == JWTAlgorithmRS256
+	case *ecdsa.PublicKey:
+		return alg == JWTAlgorithmES256 && key.Curve == elliptic.P256()
+	}
+	return false
+}
+
+func (k JWTKey) verify(alg string, signingInput, signature []byte) bool {
+	digest := sha256.Sum256(signingInput)
+	switch alg {
+	case JWTAlgorithmHS256:
+		mac := hmac.New(sha256.New, k.Key.([]byte))
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case JWTAlgorithmRS256:
+		return rsa.VerifyPKCS1v15(k.Key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case JWTAlgorithmES256:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k.Key.(*ecdsa.PublicKey), digest[:], r, s)
+	}
+	return false
+}
+
+// JWTKeyProvider provides verification keys.
+type JWTKeyProvider interface {
+	// JWTKeys returns the candidate keys of the given key ID, the key ID may be empty.
+	JWTKeys(kid string) ([]JWTKey, error)
+}
+
+// JWTKeySet is a set of keys which can be rotated at runtime, it implements JWTKeyProvider.
+type JWTKeySet struct {
+	mu   sync.RWMutex
+	keys []JWTKey
+}
+
+// NewJWTKeySet returns a key set with the given keys.
+func NewJWTKeySet(keys ...JWTKey) *JWTKeySet {
+	return &JWTKeySet{keys: keys}
+}
+
+// SetKeys replaces the keys, it is useful for rotating keys.
+func (s *JWTKeySet) SetKeys(keys ...JWTKey) {
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+// JWTKeys implements JWTKeyProvider.JWTKeys.
+func (s *JWTKeySet) JWTKeys(kid string) ([]JWTKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return matchJWTKeys(s.keys, kid), nil
+}
+
+func matchJWTKeys(keys []JWTKey, kid string) []JWTKey {
+	matched := make([]JWTKey, 0, len(keys))
+	for _, key := range keys {
+		if kid == "" || key.ID == "" || key.ID == kid {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// JWKSFile is a key provider that loads keys from a local JSON Web Key Set file,
+// the file is reloaded when it has been modified, the modification is checked at
+// most once every interval, so that keys can be rotated by replacing the file.
+// If the file cannot be reloaded, the last good keys are kept and the error is
+// logged.
+type JWKSFile struct {
+	// Logger logs reload errors, defaults to the logger of package.
+	Logger log.Logger
+
+	filename string
+	interval time.Duration
+
+	mu          sync.Mutex
+	keys        []JWTKey
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+// NewJWKSFile loads the keys from the given file, and returns a JWKSFile.
+func NewJWKSFile(filename string, interval time.Duration) (*JWKSFile, error) {
+	f := &JWKSFile{Logger: logger, filename: filename, interval: interval}
+	if err := f.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *JWKSFile) reload(now time.Time) error {
+	f.lastChecked = now
+	info, err := os.Stat(f.filename)
+	if err != nil {
+		return err
+	}
+	if !info.ModTime().After(f.modTime) && f.keys != nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	f.keys = keys
+	f.modTime = info.ModTime()
+	return nil
+}
+
+// JWTKeys implements JWTKeyProvider.JWTKeys.
+func (f *JWKSFile) JWTKeys(kid string) ([]JWTKey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if now := time.Now(); now.Sub(f.lastChecked) >= f.interval {
+		if err := f.reload(now); err != nil {
+			f.Logger.Errorf("clevergo: failed to reload JWKS file %s: %s", f.filename, err)
+		}
+	}
+	return matchJWTKeys(f.keys, kid), nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS parses JSON Web Key Set, keys that are not intended for signature,
+// or with unsupported type are ignored.
+func ParseJWKS(data []byte) ([]JWTKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]JWTKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.key()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: invalid key %q: %s", jwk.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		keys = append(keys, JWTKey{ID: jwk.Kid, Algorithm: jwk.Alg, Key: key})
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) key() (interface{}, error) {
+	switch jwk.Kty {
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 2 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// JWTVerifier verifies JSON Web Tokens.
+type JWTVerifier struct {
+	// Keys provides verification keys.
+	Keys JWTKeyProvider
+
+	// Issuer is the expected "iss" claim, it is optional.
+	Issuer string
+
+	// Audience contains the acceptable "aud" claims, the token's audience must contain
+	// one of them, it is optional.
+	Audience []string
+
+	// Leeway is the allowed clock skew when checking "exp" and "nbf" claims.
+	Leeway time.Duration
+
+	// RolesClaim is the claim that contains the roles of token, defaults to "roles",
+	// see JWT.Roles.
+	RolesClaim string
+
+	// now returns current time, it is used for testing.
+	now func() time.Time
+}
+
+// Verify parses the token, verifies the signature and validates the claims.
+func (v *JWTVerifier) Verify(token string) (*JWT, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+	jwt := &JWT{Raw: token}
+	if err := decodeJWTSegment(parts[0], &jwt.Header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	alg, _ := jwt.Header["alg"].(string)
+	if alg != JWTAlgorithmHS256 && alg != JWTAlgorithmRS256 && alg != JWTAlgorithmES256 {
+		return nil, ErrJWTUnsupportedAlg
+	}
+	kid, _ := jwt.Header["kid"].(string)
+	keys, err := v.Keys.JWTKeys(kid)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	found, verified := false, false
+	for _, key := range keys {
+		if !key.supports(alg) {
+			continue
+		}
+		found = true
+		if key.verify(alg, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrJWTKeyNotFound
+	}
+	if !verified {
+		return nil, ErrJWTInvalidSignature
+	}
+
+	if err = decodeJWTSegment(parts[1], &jwt.Claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err = v.validate(jwt.Claims); err != nil {
+		return nil, err
+	}
+	jwt.rolesClaim = v.RolesClaim
+	return jwt, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+func (v *JWTVerifier) validate(claims JWTClaims) error {
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	for _, name := range []string{"exp", "nbf"} {
+		if _, ok := claims[name]; ok {
+			if _, ok := claims.Time(name); !ok {
+				return ErrJWTMalformed
+			}
+		}
+	}
+	if exp, ok := claims.Time("exp"); ok && !now.Before(exp.Add(v.Leeway)) {
+		return ErrJWTExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return ErrJWTNotValidYet
+	}
+	if v.Issuer != "" && claims.Issuer() != v.Issuer {
+		return ErrJWTInvalidIssuer
+	}
+	if len(v.Audience) > 0 && !containsAny(claims.Audience(), v.Audience) {
+		return ErrJWTInvalidAudience
+	}
+	return nil
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JWTOption is a function that receives a JWT auth instance.
+type JWTOption func(*jwtAuth)
+
+// JWTIssuer is an option that sets the expected issuer.
+func JWTIssuer(issuer string) JWTOption {
+	return func(a *jwtAuth) {
+		a.verifier.Issuer = issuer
+	}
+}
+
+// JWTAudience is an option that sets the acceptable audience.
+func JWTAudience(audience ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.verifier.Audience = audience
+	}
+}
+
+// JWTLeeway is an option that sets the allowed clock skew.
+func JWTLeeway(leeway time.Duration) JWTOption {
+	return func(a *jwtAuth) {
+		a.verifier.Leeway = leeway
+	}
+}
+
+// JWTRolesClaim is an option that sets the claim that contains roles, such as
+// "scope", see JWT.Roles.
+func JWTRolesClaim(name string) JWTOption {
+	return func(a *jwtAuth) {
+		a.verifier.RolesClaim = name
+	}
+}
+
+// JWTExtractor is an option that sets token extractor, defaults to the Authorization
+// bearer header.
+func JWTExtractor(extractor KeyExtractor) JWTOption {
+	return func(a *jwtAuth) {
+		a.extractor = extractor
+	}
+}
+
+// JWTRealm is an option that sets realm.
+func JWTRealm(realm string) JWTOption {
+	return func(a *jwtAuth) {
+		a.realm = realm
+	}
+}
+
+// JWTSkipper is an option that sets skipper.
+func JWTSkipper(skipper Skipper) JWTOption {
+	return func(a *jwtAuth) {
+		a.skipper = skipper
+	}
+}
+
+// JWTAuth returns a JSON Web Token authentication middleware, which supports HS256,
+// RS256 and ES256 algorithms, the verified token *JWT will be stored in context,
+// see Context.Principal.
+// Unauthenticated requests will be rejected by ErrUnauthorized with WWW-Authenticate header.
+func JWTAuth(keys JWTKeyProvider, opts ...JWTOption) MiddlewareFunc {
+	a := &jwtAuth{
+		verifier:  &JWTVerifier{Keys: keys},
+		extractor: defaultKeyExtractor,
+		realm:     defaultRealm,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a.middleware
+}
+
+type jwtAuth struct {
+	verifier  *JWTVerifier
+	extractor KeyExtractor
+	realm     string
+	skipper   Skipper
+}
+
+func (a *jwtAuth) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if a.skipper != nil && a.skipper(c) {
+			setChallenge(c, "Bearer", "realm", a.realm)
+			return next(c)
+		}
+
+		token := a.extractor(c)
+		if token == "" {
+			return unauthorized(c, "Bearer", "realm", a.realm)
+		}
+		jwt, err := a.verifier.Verify(token)
+		if err != nil {
+			if !isJWTError(err) {
+				return err
+			}
+			return unauthorized(c, "Bearer", "realm", a.realm, "error", "invalid_token", "error_description", strings.TrimPrefix(err.Error(), "jwt: "))
+		}
+		c.SetPrincipal(jwt)
+		return next(c)
+	}
+}
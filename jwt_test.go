@@ -0,0 +1,346 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"clevergo.tech/log"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testHMACKey  = []byte("secret")
+	testRSAKey   *rsa.PrivateKey
+	testECDSAKey *ecdsa.PrivateKey
+)
+
+func init() {
+	var err error
+	if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testECDSAKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		panic(err)
+	}
+}
+
+func signTestJWT(alg, kid string, claims map[string]interface{}) string {
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	h, _ := json.Marshal(header)
+	p, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(signingInput))
+	var signature []byte
+	switch alg {
+	case JWTAlgorithmHS256:
+		mac := hmac.New(sha256.New, testHMACKey)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case JWTAlgorithmRS256:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, digest[:])
+	case JWTAlgorithmES256:
+		r, s, _ := ecdsa.Sign(rand.Reader, testECDSAKey, digest[:])
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTClaims(t *testing.T) {
+	claims := JWTClaims{
+		"iss": "issuer",
+		"sub": "subject",
+		"aud": []interface{}{"a", 1, "b"},
+		"exp": json.Number("1600000000.5"),
+		"nbf": float64(1600000000),
+		"iat": "invalid",
+	}
+	assert.Equal(t, "issuer", claims.Issuer())
+	assert.Equal(t, "subject", claims.Subject())
+	assert.Equal(t, []string{"a", "b"}, claims.Audience())
+	assert.Equal(t, []string{"c"}, JWTClaims{"aud": "c"}.Audience())
+	assert.Nil(t, JWTClaims{}.Audience())
+
+	exp, ok := claims.Time("exp")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1600000000, int64(time.Second/2)), exp)
+	nbf, ok := claims.Time("nbf")
+	assert.True(t, ok)
+	assert.Equal(t, time.Unix(1600000000, 0), nbf)
+	_, ok = claims.Time("iat")
+	assert.False(t, ok)
+	_, ok = claims.Time("nonexistent")
+	assert.False(t, ok)
+}
+
+func TestJWTRoles(t *testing.T) {
+	cases := []struct {
+		claim  string
+		claims JWTClaims
+		roles  []string
+	}{
+		{"", JWTClaims{}, nil},
+		{"", JWTClaims{"roles": []interface{}{"admin", 1, "author"}}, []string{"admin", "author"}},
+		{"", JWTClaims{"roles": "admin author"}, []string{"admin", "author"}},
+		{"scope", JWTClaims{"roles": "admin", "scope": "read write"}, []string{"read", "write"}},
+	}
+	for _, test := range cases {
+		var holder RoleHolder = &JWT{Claims: test.claims, rolesClaim: test.claim}
+		assert.Equal(t, test.roles, holder.Roles())
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	keys := NewJWTKeySet(
+		JWTKey{ID: "hmac", Key: testHMACKey},
+		JWTKey{ID: "rsa", Key: &testRSAKey.PublicKey},
+		JWTKey{ID: "ecdsa", Algorithm: JWTAlgorithmES256, Key: &testECDSAKey.PublicKey},
+	)
+	v := &JWTVerifier{
+		Keys:     keys,
+		Issuer:   "clevergo",
+		Audience: []string{"api"},
+		Leeway:   time.Minute,
+		now:      func() time.Time { return now },
+	}
+	valid := map[string]interface{}{
+		"iss": "clevergo",
+		"aud": "api",
+		"sub": "foo",
+		"exp": now.Add(time.Hour).Unix(),
+		"nbf": now.Unix(),
+	}
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	cases := []struct {
+		token string
+		err   error
+	}{
+		{signTestJWT(JWTAlgorithmHS256, "hmac", valid), nil},
+		{signTestJWT(JWTAlgorithmRS256, "rsa", valid), nil},
+		{signTestJWT(JWTAlgorithmES256, "ecdsa", valid), nil},
+		{signTestJWT(JWTAlgorithmES256, "", valid), nil},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("exp", nil)), nil},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("exp", now.Add(-30*time.Second).Unix())), nil},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("exp", now.Add(-time.Hour).Unix())), ErrJWTExpired},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("nbf", now.Add(30*time.Second).Unix())), nil},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("nbf", now.Add(time.Hour).Unix())), ErrJWTNotValidYet},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("exp", "tomorrow")), ErrJWTMalformed},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("nbf", true)), ErrJWTMalformed},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("iss", "unknown")), ErrJWTInvalidIssuer},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("aud", []string{"web", "api"})), nil},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("aud", "web")), ErrJWTInvalidAudience},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", with("aud", nil)), ErrJWTInvalidAudience},
+		{signTestJWT(JWTAlgorithmHS256, "rsa", valid), ErrJWTKeyNotFound},
+		{signTestJWT(JWTAlgorithmHS256, "unknown", valid), ErrJWTKeyNotFound},
+		{signTestJWT("none", "", valid), ErrJWTUnsupportedAlg},
+		{signTestJWT("HS512", "", valid), ErrJWTUnsupportedAlg},
+		{signTestJWT(JWTAlgorithmHS256, "hmac", valid) + "x", ErrJWTInvalidSignature},
+		{"a.b", ErrJWTMalformed},
+		{"!.b.c", ErrJWTMalformed},
+		{base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + ".b.!", ErrJWTMalformed},
+	}
+	for i, test := range cases {
+		jwt, err := v.Verify(test.token)
+		assert.Equal(t, test.err, err, fmt.Sprintf("case %d", i))
+		if err == nil {
+			assert.Equal(t, test.token, jwt.Raw)
+			assert.Equal(t, "foo", jwt.Claims.Subject())
+		}
+	}
+
+	// malformed payload with valid signature.
+	h := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`))
+	mac := hmac.New(sha256.New, testHMACKey)
+	mac.Write([]byte(h + ".!"))
+	_, err := v.Verify(h + ".!." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)))
+	assert.Equal(t, ErrJWTMalformed, err)
+}
+
+func TestJWTKeySetRotation(t *testing.T) {
+	keys := NewJWTKeySet(JWTKey{ID: "old", Key: []byte("old")})
+	v := &JWTVerifier{Keys: keys}
+	token := signTestJWT(JWTAlgorithmHS256, "new", map[string]interface{}{})
+	_, err := v.Verify(token)
+	assert.Equal(t, ErrJWTKeyNotFound, err)
+
+	keys.SetKeys(JWTKey{ID: "old", Key: []byte("old")}, JWTKey{ID: "new", Key: testHMACKey})
+	_, err = v.Verify(token)
+	assert.Nil(t, err)
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func testJWKS(hmacKey []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(hmacKey)},
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeBigInt(testRSAKey.N), "e": encodeBigInt(big.NewInt(int64(testRSAKey.E)))},
+			{"kty": "EC", "kid": "ecdsa", "crv": "P-256", "x": encodeBigInt(testECDSAKey.X), "y": encodeBigInt(testECDSAKey.Y)},
+			{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "", "y": ""},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": "", "e": ""},
+			{"kty": "OKP", "kid": "okp"},
+		},
+	})
+	return data
+}
+
+func TestParseJWKS(t *testing.T) {
+	keys, err := ParseJWKS(testJWKS(testHMACKey))
+	assert.Nil(t, err)
+	assert.Len(t, keys, 3)
+	assert.Equal(t, JWTKey{ID: "hmac", Algorithm: "HS256", Key: testHMACKey}, keys[0])
+	assert.Equal(t, &testRSAKey.PublicKey, keys[1].Key)
+	assert.Equal(t, testECDSAKey.PublicKey.X, keys[2].Key.(*ecdsa.PublicKey).X)
+
+	invalid := []string{
+		`{`,
+		`{"keys":[{"kty":"oct","k":"!"}]}`,
+		`{"keys":[{"kty":"RSA","n":"!"}]}`,
+		`{"keys":[{"kty":"RSA","n":"AQAB","e":"!"}]}`,
+		`{"keys":[{"kty":"RSA","n":"AQAB","e":"AQ"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"!"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"!"}]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+	}
+	for _, data := range invalid {
+		_, err = ParseJWKS([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestJWKSFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jwks.json")
+	_, err = NewJWKSFile(filename, 0)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(filename, testJWKS([]byte("old")), 0644))
+	f, err := NewJWKSFile(filename, 0)
+	assert.Nil(t, err)
+	v := &JWTVerifier{Keys: f}
+	token := signTestJWT(JWTAlgorithmHS256, "hmac", map[string]interface{}{})
+	_, err = v.Verify(token)
+	assert.Equal(t, ErrJWTInvalidSignature, err)
+	_, err = v.Verify(signTestJWT(JWTAlgorithmES256, "ecdsa", map[string]interface{}{}))
+	assert.Nil(t, err)
+
+	// rotates keys.
+	assert.Nil(t, ioutil.WriteFile(filename, testJWKS(testHMACKey), 0644))
+	future := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(filename, future, future))
+	_, err = v.Verify(token)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ioutil.WriteFile(filename, []byte("{"), 0644))
+	future = future.Add(time.Second)
+	assert.Nil(t, os.Chtimes(filename, future, future))
+	logs := &bytes.Buffer{}
+	f.Logger = log.New(logs, "", 0)
+	_, err = v.Verify(token)
+	assert.Nil(t, err, "keeps the last good keys")
+	assert.Contains(t, logs.String(), "failed to reload JWKS file")
+
+	assert.Nil(t, os.Remove(filename))
+	_, err = v.Verify(token)
+	assert.Nil(t, err)
+}
+
+type errJWTKeyProvider struct {
+	err error
+}
+
+func (p errJWTKeyProvider) JWTKeys(kid string) ([]JWTKey, error) {
+	return nil, p.err
+}
+
+func TestJWTAuth(t *testing.T) {
+	keys := NewJWTKeySet(JWTKey{Key: testHMACKey})
+	valid := signTestJWT(JWTAlgorithmHS256, "", map[string]interface{}{"sub": "foo", "iss": "clevergo", "aud": "api"})
+	expired := signTestJWT(JWTAlgorithmHS256, "", map[string]interface{}{"sub": "foo", "exp": 1})
+	handle := func(c *Context) error {
+		if c.Principal() == nil {
+			return c.String(http.StatusOK, "skipped")
+		}
+		return c.String(http.StatusOK, c.Principal().(*JWT).Claims.Subject())
+	}
+
+	cases := []struct {
+		opts      []JWTOption
+		keys      JWTKeyProvider
+		token     string
+		err       error
+		challenge string
+		body      string
+	}{
+		{nil, keys, "", ErrUnauthorized, `Bearer realm="Restricted"`, ""},
+		{nil, keys, valid, nil, "", "foo"},
+		{[]JWTOption{JWTRealm("API")}, keys, expired, ErrUnauthorized, `Bearer realm="API", error="invalid_token", error_description="token is expired"`, ""},
+		{[]JWTOption{JWTIssuer("clevergo"), JWTAudience("api", "web"), JWTLeeway(time.Second)}, keys, valid, nil, "", "foo"},
+		{[]JWTOption{JWTIssuer("foo")}, keys, valid, ErrUnauthorized, `Bearer realm="Restricted", error="invalid_token", error_description="invalid issuer"`, ""},
+		{[]JWTOption{JWTAudience("web")}, keys, valid, ErrUnauthorized, `Bearer realm="Restricted", error="invalid_token", error_description="invalid audience"`, ""},
+		{[]JWTOption{JWTSkipper(func(c *Context) bool { return true })}, keys, "", nil, "", "skipped"},
+		{[]JWTOption{JWTExtractor(QueryKeyExtractor("token"))}, keys, valid, ErrUnauthorized, `Bearer realm="Restricted"`, ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		c := newContext(w, req)
+		err := JWTAuth(test.keys, test.opts...)(handle)(c)
+		assert.Equal(t, test.err, err)
+		assert.Equal(t, test.challenge, w.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, test.body, w.Body.String())
+	}
+
+	providerErr := errors.New("failed to load keys")
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+valid)
+	c := newContext(httptest.NewRecorder(), req)
+	assert.Equal(t, providerErr, JWTAuth(errJWTKeyProvider{providerErr})(handle)(c))
+}
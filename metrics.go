@@ -0,0 +1,564 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const headerContentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultMetricsBuckets is the default histogram buckets in seconds.
+var DefaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultMetricsRegistry is the default metrics registry.
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+var metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+const (
+	metricTypeCounter   = "counter"
+	metricTypeGauge     = "gauge"
+	metricTypeHistogram = "histogram"
+)
+
+// MetricsRegistry is a collection of metrics that can be exposed in
+// Prometheus text exposition format.
+type MetricsRegistry struct {
+	mu       sync.RWMutex
+	families map[string]*metricFamily
+}
+
+// NewMetricsRegistry returns an empty metrics registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// register registers the given metric family, the registered one will be returned
+// if the family of the same name, type and labels is already registered.
+func (r *MetricsRegistry) register(f *metricFamily) *metricFamily {
+	if !metricNameRegexp.MatchString(f.name) {
+		panic("invalid metric name " + f.name)
+	}
+	for _, label := range f.labelNames {
+		if !metricNameRegexp.MatchString(label) || strings.ContainsRune(label, ':') || label == "le" {
+			panic("invalid label name " + label + " of metric " + f.name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registered, ok := r.families[f.name]; ok {
+		if registered.typ != f.typ || strings.Join(registered.labelNames, ",") != strings.Join(f.labelNames, ",") {
+			panic("metric " + f.name + " is already registered with different type or labels")
+		}
+		return registered
+	}
+	r.families[f.name] = f
+	return f
+}
+
+// Counter registers a counter without labels.
+func (r *MetricsRegistry) Counter(name, help string) *Counter {
+	return r.CounterVec(name, help).With()
+}
+
+// CounterVec registers a counter vector with the given label names.
+func (r *MetricsRegistry) CounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(newMetricFamily(name, help, metricTypeCounter, labelNames, func() metric {
+		return new(Counter)
+	}))}
+}
+
+// Gauge registers a gauge without labels.
+func (r *MetricsRegistry) Gauge(name, help string) *Gauge {
+	return r.GaugeVec(name, help).With()
+}
+
+// GaugeVec registers a gauge vector with the given label names.
+func (r *MetricsRegistry) GaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(newMetricFamily(name, help, metricTypeGauge, labelNames, func() metric {
+		return new(Gauge)
+	}))}
+}
+
+// Histogram registers a histogram without labels, DefaultMetricsBuckets will be
+// used if buckets is empty.
+func (r *MetricsRegistry) Histogram(name, help string, buckets []float64) *Histogram {
+	return r.HistogramVec(name, help, buckets).With()
+}
+
+// HistogramVec registers a histogram vector with the given buckets and label names,
+// DefaultMetricsBuckets will be used if buckets is empty.
+func (r *MetricsRegistry) HistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultMetricsBuckets
+	}
+	upperBounds := make([]float64, len(buckets))
+	copy(upperBounds, buckets)
+	sort.Float64s(upperBounds)
+	return &HistogramVec{r.register(newMetricFamily(name, help, metricTypeHistogram, labelNames, func() metric {
+		return newHistogram(upperBounds)
+	}))}
+}
+
+// WriteTo writes all metrics to w in Prometheus text exposition format.
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	families := make([]*metricFamily, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.RUnlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// MetricsHandler returns a handle that exposes the metrics of the given registry,
+// DefaultMetricsRegistry will be used if registry is nil.
+func MetricsHandler(registry *MetricsRegistry) Handle {
+	if registry == nil {
+		registry = DefaultMetricsRegistry
+	}
+	return func(c *Context) error {
+		c.SetContentType(headerContentTypeMetrics)
+		_, err := registry.WriteTo(c.Response)
+		return err
+	}
+}
+
+type metric interface {
+	write(w *bufio.Writer, name, labels string)
+}
+
+type metricFamily struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	newMetric  func() metric
+
+	mu      sync.RWMutex
+	metrics map[string]*labeledMetric
+}
+
+type labeledMetric struct {
+	labels string
+	metric metric
+}
+
+func newMetricFamily(name, help, typ string, labelNames []string, newMetric func() metric) *metricFamily {
+	return &metricFamily{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		newMetric:  newMetric,
+		metrics:    make(map[string]*labeledMetric),
+	}
+}
+
+func (f *metricFamily) get(labelValues []string) metric {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.RLock()
+	m, ok := f.metrics[key]
+	f.mu.RUnlock()
+	if ok {
+		return m.metric
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok = f.metrics[key]; !ok {
+		m = &labeledMetric{
+			labels: formatLabels(f.labelNames, labelValues),
+			metric: f.newMetric(),
+		}
+		f.metrics[key] = m
+	}
+	return m.metric
+}
+
+func (f *metricFamily) write(w *bufio.Writer) {
+	f.mu.RLock()
+	metrics := make([]*labeledMetric, 0, len(f.metrics))
+	for _, m := range f.metrics {
+		metrics = append(metrics, m)
+	}
+	f.mu.RUnlock()
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].labels < metrics[j].labels
+	})
+
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeMetricHelp(f.help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, m := range metrics {
+		m.metric.write(w, f.name, m.labels)
+	}
+}
+
+var (
+	metricHelpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	metricLabelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeMetricHelp(s string) string {
+	return metricHelpReplacer.Replace(s)
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + metricLabelValueReplacer.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatMetricValue(value))
+	w.WriteByte('\n')
+}
+
+// atomicFloat is a float64 that can be updated atomically.
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		if atomic.CompareAndSwapUint64(&f.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	value atomicFloat
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add adds the given value to the counter, it panics if the value is negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("counter cannot decrease in value")
+	}
+	c.value.add(v)
+}
+
+// Value returns the current value.
+func (c *Counter) Value() float64 {
+	return c.value.load()
+}
+
+func (c *Counter) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+// CounterVec is a collection of counters that partitioned by label values.
+type CounterVec struct {
+	family *metricFamily
+}
+
+// With returns the counter of the given label values, the values are ordered as label names.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.family.get(labelValues).(*Counter)
+}
+
+// Gauge is a metric that can arbitrarily go up and down.
+type Gauge struct {
+	value atomicFloat
+}
+
+// Set sets the gauge to the given value.
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+// Add adds the given value to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+// Sub subtracts the given value from the gauge.
+func (g *Gauge) Sub(v float64) {
+	g.value.add(-v)
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	return g.value.load()
+}
+
+func (g *Gauge) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+// GaugeVec is a collection of gauges that partitioned by label values.
+type GaugeVec struct {
+	family *metricFamily
+}
+
+// With returns the gauge of the given label values, the values are ordered as label names.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.family.get(labelValues).(*Gauge)
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	upperBounds []float64
+	// counts of each bucket, the last one is the +Inf bucket.
+	counts []uint64
+	sum    atomicFloat
+}
+
+func newHistogram(upperBounds []float64) *Histogram {
+	return &Histogram{
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+	}
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(v float64) {
+	atomic.AddUint64(&h.counts[sort.SearchFloat64s(h.upperBounds, v)], 1)
+	h.sum.add(v)
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() (count uint64) {
+	for i := range h.counts {
+		count += atomic.LoadUint64(&h.counts[i])
+	}
+	return
+}
+
+// Sum returns the sum of observations.
+func (h *Histogram) Sum() float64 {
+	return h.sum.load()
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	var cumulative uint64
+	for i, upperBound := range h.upperBounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		writeSample(w, name+"_bucket", prefix+`le="`+formatMetricValue(upperBound)+`"`, float64(cumulative))
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.upperBounds)])
+	writeSample(w, name+"_bucket", prefix+`le="+Inf"`, float64(cumulative))
+	writeSample(w, name+"_sum", labels, h.Sum())
+	writeSample(w, name+"_count", labels, float64(cumulative))
+}
+
+// HistogramVec is a collection of histograms that partitioned by label values.
+type HistogramVec struct {
+	family *metricFamily
+}
+
+// With returns the histogram of the given label values, the values are ordered as label names.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.family.get(labelValues).(*Histogram)
+}
+
+// MetricsOption is a function that receives a metrics middleware instance.
+type MetricsOption func(*metrics)
+
+// MetricsWithRegistry is an option that sets the registry which the metrics are registered to.
+func MetricsWithRegistry(registry *MetricsRegistry) MetricsOption {
+	return func(m *metrics) {
+		m.registry = registry
+	}
+}
+
+// MetricsNamespace is an option that sets the prefix of metric names.
+func MetricsNamespace(namespace string) MetricsOption {
+	return func(m *metrics) {
+		m.namespace = namespace
+	}
+}
+
+// MetricsBuckets is an option that sets the buckets of request duration histogram.
+func MetricsBuckets(buckets []float64) MetricsOption {
+	return func(m *metrics) {
+		m.buckets = buckets
+	}
+}
+
+// MetricsSkipper is an option that sets the skipper.
+func MetricsSkipper(skipper Skipper) MetricsOption {
+	return func(m *metrics) {
+		m.skipper = skipper
+	}
+}
+
+// Metrics returns a middleware that collects the following metrics:
+//
+//	http_requests_total                counter    method, route, status
+//	http_request_duration_seconds      histogram  method, route, status
+//	http_requests_in_flight            gauge      method
+//
+// The route label is the name of matched route, or the route pattern if the
+// route is unnamed, such as "/users/{name}", it is empty if no route was matched.
+// The method label is "OTHER" for non-standard methods, which keeps the number of
+// series bounded.
+// Metric names are prefixed by the namespace if present.
+func Metrics(opts ...MetricsOption) MiddlewareFunc {
+	m := &metrics{
+		registry: DefaultMetricsRegistry,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	prefix := ""
+	if m.namespace != "" {
+		prefix = m.namespace + "_"
+	}
+	m.requests = m.registry.CounterVec(prefix+"http_requests_total", "Total number of HTTP requests.", "method", "route", "status")
+	m.durations = m.registry.HistogramVec(prefix+"http_request_duration_seconds", "HTTP request latencies in seconds.", m.buckets, "method", "route", "status")
+	m.inFlight = m.registry.GaugeVec(prefix+"http_requests_in_flight", "Number of HTTP requests being served.", "method")
+	return m.middleware
+}
+
+type metrics struct {
+	registry  *MetricsRegistry
+	namespace string
+	buckets   []float64
+	skipper   Skipper
+	requests  *CounterVec
+	durations *HistogramVec
+	inFlight  *GaugeVec
+}
+
+func (m *metrics) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+
+		start := time.Now()
+		method := methodLabel(c.Request.Method)
+		inFlight := m.inFlight.With(method)
+		inFlight.Inc()
+		resp := newResponseWriter(c.Response)
+		defer func(w http.ResponseWriter) {
+			c.Response = w
+			inFlight.Dec()
+		}(c.Response)
+		c.Response = resp
+
+		err := next(c)
+		labels := []string{method, routeLabel(c.Route), strconv.Itoa(resp.status(err))}
+		m.requests.With(labels...).Inc()
+		m.durations.With(labels...).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+func routeLabel(route *Route) string {
+	if route == nil {
+		return ""
+	}
+	if route.name != "" {
+		return route.name
+	}
+	return route.pattern
+}
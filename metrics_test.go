@@ -0,0 +1,145 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsRegistryRegister(t *testing.T) {
+	r := NewMetricsRegistry()
+	c1 := r.CounterVec("foo_total", "", "a")
+	c2 := r.CounterVec("foo_total", "", "a")
+	assert.Equal(t, c1.family, c2.family)
+
+	assert.NotNil(t, catchPanic(func() { r.GaugeVec("foo_total", "", "a") }))
+	assert.NotNil(t, catchPanic(func() { r.CounterVec("foo_total", "", "b") }))
+	assert.NotNil(t, catchPanic(func() { r.Counter("0foo", "") }))
+	assert.NotNil(t, catchPanic(func() { r.CounterVec("bar", "", "le") }))
+	assert.NotNil(t, catchPanic(func() { r.CounterVec("bar", "", "a:b") }))
+	assert.NotNil(t, catchPanic(func() { c1.With() }))
+}
+
+func TestCounter(t *testing.T) {
+	c := NewMetricsRegistry().Counter("foo_total", "")
+	c.Inc()
+	c.Add(1.5)
+	assert.Equal(t, 2.5, c.Value())
+	assert.NotNil(t, catchPanic(func() { c.Add(-1) }))
+}
+
+func TestGauge(t *testing.T) {
+	g := NewMetricsRegistry().Gauge("foo", "")
+	g.Set(3)
+	g.Inc()
+	g.Dec()
+	g.Dec()
+	g.Add(0.5)
+	g.Sub(1)
+	assert.Equal(t, 1.5, g.Value())
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewMetricsRegistry().Histogram("foo", "", []float64{1, 0.5})
+	assert.Equal(t, []float64{0.5, 1}, h.upperBounds)
+	for _, v := range []float64{0.1, 0.5, 0.7, 2} {
+		h.Observe(v)
+	}
+	assert.Equal(t, uint64(4), h.Count())
+	assert.Equal(t, 3.3, h.Sum())
+	assert.Equal(t, []uint64{2, 1, 1}, h.counts)
+
+	assert.Equal(t, DefaultMetricsBuckets, NewMetricsRegistry().Histogram("bar", "", nil).upperBounds)
+}
+
+func TestMetricsRegistryWriteTo(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.CounterVec("requests_total", "Total\nrequests.", "path").With(`/"foo"`).Add(3)
+	r.Gauge("temperature", "").Set(math.Inf(-1))
+	h := r.HistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "method")
+	h.With("GET").Observe(0.05)
+	h.With("GET").Observe(2)
+
+	buf := &bytes.Buffer{}
+	n, err := r.WriteTo(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="GET",le="0.1"} 1
+latency_seconds_bucket{method="GET",le="1"} 1
+latency_seconds_bucket{method="GET",le="+Inf"} 2
+latency_seconds_sum{method="GET"} 2.05
+latency_seconds_count{method="GET"} 2
+# HELP requests_total Total\nrequests.
+# TYPE requests_total counter
+requests_total{path="/\"foo\""} 3
+# TYPE temperature gauge
+temperature -Inf
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestFormatMetricValue(t *testing.T) {
+	assert.Equal(t, "+Inf", formatMetricValue(math.Inf(1)))
+	assert.Equal(t, "-Inf", formatMetricValue(math.Inf(-1)))
+	assert.Equal(t, "NaN", formatMetricValue(math.NaN()))
+	assert.Equal(t, "0.25", formatMetricValue(0.25))
+	assert.Equal(t, "1e+06", formatMetricValue(1000000))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.Counter("foo_total", "").Inc()
+	w := httptest.NewRecorder()
+	c := newContext(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Nil(t, MetricsHandler(r)(c))
+	assert.Equal(t, headerContentTypeMetrics, w.Header().Get("Content-Type"))
+	assert.Equal(t, "# TYPE foo_total counter\nfoo_total 1\n", w.Body.String())
+
+	assert.NotNil(t, MetricsHandler(nil))
+}
+
+func TestMetrics(t *testing.T) {
+	r := NewMetricsRegistry()
+	app := Pure()
+	app.Use(Metrics(MetricsWithRegistry(r), MetricsNamespace("app"), MetricsBuckets([]float64{1}), MetricsSkipper(PathSkipper("/metrics"))))
+	app.Get("/users/:name", func(c *Context) error {
+		assert.Equal(t, 1.0, r.GaugeVec("app_http_requests_in_flight", "", "method").With(http.MethodGet).Value())
+		return c.String(http.StatusCreated, "created")
+	})
+	app.Get("/error", func(c *Context) error {
+		return errors.New("error")
+	}, RouteName("error"))
+	app.Get("/metrics", MetricsHandler(r))
+
+	for _, path := range []string{"/users/foo", "/users/bar", "/error", "/nonexistent", "/metrics"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOOBAR", "/nonexistent", nil))
+
+	requests := r.CounterVec("app_http_requests_total", "", "method", "route", "status")
+	assert.Equal(t, 1.0, requests.With("OTHER", "", "404").Value())
+	assert.Equal(t, 2.0, requests.With(http.MethodGet, "/users/{name}", "201").Value())
+	assert.Equal(t, 1.0, requests.With(http.MethodGet, "error", "500").Value())
+	assert.Equal(t, 1.0, requests.With(http.MethodGet, "", "404").Value())
+	assert.Equal(t, 0.0, requests.With(http.MethodGet, "/metrics", "200").Value())
+	durations := r.HistogramVec("app_http_request_duration_seconds", "", []float64{1}, "method", "route", "status")
+	assert.Equal(t, uint64(2), durations.With(http.MethodGet, "/users/{name}", "201").Count())
+	assert.Equal(t, 0.0, r.GaugeVec("app_http_requests_in_flight", "", "method").With(http.MethodGet).Value())
+
+	buf := &bytes.Buffer{}
+	r.WriteTo(buf)
+	assert.True(t, strings.Contains(buf.String(), `app_http_requests_total{method="GET",route="/users/{name}",status="201"} 2`))
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const mountPathParam = "mountpath"
+
+// Mount implements Router.Mount.
+func (app *Application) Mount(prefix string, handler http.Handler, opts ...RouteOption) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if strings.ContainsAny(prefix, ":*") {
+		panic("prefix must not contain parameters in prefix '" + prefix + "'")
+	}
+	if sub, ok := handler.(*Application); ok {
+		sub.mountParent = app
+		sub.mountPrefix = prefix
+	}
+
+	handle := mountHandle(handler)
+	app.Any(prefix+"/*"+mountPathParam, handle, append([]RouteOption(nil), opts...)...)
+	if prefix != "" {
+		app.Any(prefix, handle, withoutRouteName(opts)...)
+	}
+}
+
+func withoutRouteName(opts []RouteOption) []RouteOption {
+	filtered := make([]RouteOption, 0, len(opts))
+	for _, opt := range opts {
+		if !isRouteNameOption(opt) {
+			filtered = append(filtered, opt)
+		}
+	}
+	return filtered
+}
+
+// mountPath returns the path prefix that the application is mounted on, including
+// the prefixes of ancestors.
+func (app *Application) mountPath() string {
+	if app.mountParent == nil {
+		return ""
+	}
+	return app.mountParent.mountPath() + app.mountPrefix
+}
+
+// mountHandle returns a handle that strips the mount prefix from the request URL
+// and passes the request to the handler.
+func mountHandle(handler http.Handler) Handle {
+	return func(c *Context) error {
+		path := c.Params.String(mountPathParam)
+		if path == "" {
+			path = "/"
+		}
+
+		req := new(http.Request)
+		*req = *c.Request
+		req.URL = new(url.URL)
+		*req.URL = *c.Request.URL
+		req.URL.Path = path
+		req.URL.RawPath = stripRawPath(c.Request.URL.RawPath, path)
+
+		handler.ServeHTTP(c.Response, req)
+		return nil
+	}
+}
+
+// stripRawPath returns the suffix of raw path which is the escaped form of the
+// given path, it returns an empty string if not found.
+func stripRawPath(rawPath, path string) string {
+	for i := 0; i < len(rawPath); i++ {
+		if rawPath[i] != '/' {
+			continue
+		}
+		if p, err := url.PathUnescape(rawPath[i:]); err == nil && p == path {
+			return rawPath[i:]
+		}
+	}
+	return ""
+}
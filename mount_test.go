@@ -0,0 +1,150 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pathHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method + " " + r.URL.Path + " " + r.URL.RawPath))
+	})
+}
+
+func TestApplicationMount(t *testing.T) {
+	app := Pure()
+	app.Mount("/static/", pathHandler())
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodGet, "/static", "GET / "},
+		{http.MethodGet, "/static/", "GET / "},
+		{http.MethodPost, "/static/css/app.css", "POST /css/app.css "},
+		{http.MethodDelete, "/static/a%2Fb/c%20d", "DELETE /a/b/c d /a%2Fb/c%20d"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+		assert.Equal(t, http.StatusOK, w.Code, test.target)
+		assert.Equal(t, test.body, w.Body.String(), test.target)
+	}
+
+	root := Pure()
+	root.Mount("/", pathHandler())
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/foo", nil))
+	assert.Equal(t, "PUT /foo ", w.Body.String())
+
+	assert.Panics(t, func() {
+		app.Mount("/users/:id", pathHandler())
+	})
+	assert.Panics(t, func() {
+		app.Mount("/files/*filepath", pathHandler())
+	})
+}
+
+func TestApplicationMountUseRawPath(t *testing.T) {
+	app := Pure()
+	app.UseRawPath = true
+	app.Mount("/api", pathHandler())
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/a%2Fb/c", nil))
+	assert.Equal(t, "GET /a/b/c /a%2Fb/c", w.Body.String())
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/a/b", nil))
+	assert.Equal(t, "GET /a/b ", w.Body.String())
+}
+
+func TestApplicationMountApplication(t *testing.T) {
+	admin := Pure()
+	admin.Get("/users/:id", func(c *Context) error {
+		u, err := c.RouteURL("user", "id", c.Params.String("id"))
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, u.String())
+	}, RouteName("user"))
+	admin.Get("/", echoHandler("index"))
+
+	v2 := Pure()
+	v2.Get("/posts", echoHandler("posts"), RouteName("posts"))
+
+	app := Pure()
+	app.Mount("/admin", admin, RouteName("admin"))
+	api := app.Group("/api", RouteGroupMiddleware(func(next Handle) Handle {
+		return func(c *Context) error {
+			c.SetHeader("X-API", "true")
+			return next(c)
+		}
+	}))
+	api.Mount("/v2", v2)
+
+	cases := []struct {
+		target string
+		code   int
+		body   string
+		api    string
+	}{
+		{"/admin/users/1", http.StatusOK, "/admin/users/1", ""},
+		{"/admin", http.StatusOK, "index", ""},
+		{"/admin/missing", http.StatusNotFound, "Not Found\n", ""},
+		{"/api/v2/posts", http.StatusOK, "posts", "true"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		assert.Equal(t, test.code, w.Code, test.target)
+		assert.Equal(t, test.body, w.Body.String(), test.target)
+		assert.Equal(t, test.api, w.Header().Get("X-API"), test.target)
+	}
+
+	u, err := v2.RouteURL("posts")
+	assert.Nil(t, err)
+	assert.Equal(t, "/api/v2/posts", u.String())
+
+	_, err = admin.RouteURL("user")
+	assert.NotNil(t, err)
+
+	// the name option is applied once.
+	u, err = app.RouteURL("admin", "mountpath", "users")
+	assert.Nil(t, err)
+	assert.Equal(t, "/admin/users", u.String())
+
+	// nested mounting.
+	root := Pure()
+	root.Mount("/root", app)
+	u, err = v2.RouteURL("posts")
+	assert.Nil(t, err)
+	assert.Equal(t, "/root/api/v2/posts", u.String())
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/root/admin/users/2", nil))
+	assert.Equal(t, "/root/admin/users/2", w.Body.String())
+}
+
+func TestStripRawPath(t *testing.T) {
+	cases := []struct {
+		rawPath  string
+		path     string
+		expected string
+	}{
+		{"", "/foo", ""},
+		{"/prefix/a%2Fb", "/a/b", "/a%2Fb"},
+		{"/prefix/a%2Fb", "/b", ""},
+		{"/pre%20fix/a%2Fb", "/a/b", "/a%2Fb"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, stripRawPath(test.rawPath, test.path))
+	}
+}
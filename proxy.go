@@ -0,0 +1,620 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultProxyHealthInterval = 10 * time.Second
+	defaultProxyHealthTimeout  = 5 * time.Second
+	defaultProxyMaxRetryBody   = 1 << 20
+)
+
+// ProxyBackend is a backend server of proxy.
+type ProxyBackend struct {
+	conns int64 // accessed atomically, must be 64-bit aligned.
+
+	// URL is the target URL, the path of which is prepended to the request path.
+	URL *url.URL
+
+	mu        sync.Mutex
+	unhealthy bool
+	fails     int
+	downUntil time.Time
+}
+
+// Conns returns the number of requests being proxied to the backend.
+func (b *ProxyBackend) Conns() int64 {
+	return atomic.LoadInt64(&b.conns)
+}
+
+// Healthy reports whether the backend passed the health checks.
+func (b *ProxyBackend) Healthy() bool {
+	return b.available(time.Now())
+}
+
+func (b *ProxyBackend) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.unhealthy && !now.Before(b.downUntil)
+}
+
+func (b *ProxyBackend) setHealthy(healthy bool) {
+	b.mu.Lock()
+	b.unhealthy = !healthy
+	b.mu.Unlock()
+}
+
+// LoadBalancer is an interface that picks a backend for requests.
+type LoadBalancer interface {
+	// Next returns one of the given healthy backends, which is never empty.
+	Next(c *Context, backends []*ProxyBackend) *ProxyBackend
+}
+
+// RoundRobin returns a load balancer that picks backends in turn.
+func RoundRobin() LoadBalancer {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	counter uint32
+}
+
+func (b *roundRobin) Next(c *Context, backends []*ProxyBackend) *ProxyBackend {
+	n := atomic.AddUint32(&b.counter, 1) - 1
+	return backends[n%uint32(len(backends))]
+}
+
+// LeastConn returns a load balancer that picks the backend that has the fewest
+// requests in flight, it fits the requests whose durations vary greatly, such as
+// WebSocket connections.
+func LeastConn() LoadBalancer {
+	return leastConn{}
+}
+
+type leastConn struct{}
+
+func (leastConn) Next(c *Context, backends []*ProxyBackend) *ProxyBackend {
+	backend := backends[0]
+	for _, b := range backends[1:] {
+		if b.Conns() < backend.Conns() {
+			backend = b
+		}
+	}
+	return backend
+}
+
+// ConsistentHash returns a load balancer that picks backends by the hash of the
+// key returned by the given function, so that the requests of the same key are
+// proxied to the same backend, only the keys of a backend are moved to others
+// once it went down. The requests with empty key are balanced in turn.
+//
+// It is implemented by rendezvous hashing.
+func ConsistentHash(key func(c *Context) string) LoadBalancer {
+	return &consistentHash{key: key}
+}
+
+// ConsistentHashHeader returns a consistent hash load balancer whose key is the
+// value of the given request header, see ConsistentHash.
+func ConsistentHashHeader(name string) LoadBalancer {
+	return ConsistentHash(func(c *Context) string {
+		return c.Request.Header.Get(name)
+	})
+}
+
+// ConsistentHashParam returns a consistent hash load balancer whose key is the
+// value of the given route parameter, see ConsistentHash.
+func ConsistentHashParam(name string) LoadBalancer {
+	return ConsistentHash(func(c *Context) string {
+		return c.Params.String(name)
+	})
+}
+
+type consistentHash struct {
+	roundRobin
+	key func(c *Context) string
+}
+
+func (b *consistentHash) Next(c *Context, backends []*ProxyBackend) *ProxyBackend {
+	key := b.key(c)
+	if key == "" {
+		return b.roundRobin.Next(c, backends)
+	}
+	var backend *ProxyBackend
+	var max uint64
+	for _, v := range backends {
+		h := fnv.New64a()
+		io.WriteString(h, key)
+		h.Write([]byte{0})
+		io.WriteString(h, v.URL.String())
+		if score := h.Sum64(); backend == nil || score > max {
+			backend, max = v, score
+		}
+	}
+	return backend
+}
+
+// ProxyOption is a function that receives a proxy.
+type ProxyOption func(*Proxy)
+
+// ProxyLoadBalancer is an option that sets the load balancer, defaults to RoundRobin.
+func ProxyLoadBalancer(balancer LoadBalancer) ProxyOption {
+	return func(p *Proxy) {
+		p.balancer = balancer
+	}
+}
+
+// ProxyRetries is an option that sets the maximum number of retries of idempotent
+// requests, a request is retried on another backend if the backend could not be
+// reached. The bodies of retryable requests are buffered in memory, the requests
+// whose body is larger than the max size are never retried, see
+// ProxyMaxRetryBodySize.
+func ProxyRetries(retries int) ProxyOption {
+	return func(p *Proxy) {
+		p.retries = retries
+	}
+}
+
+// ProxyMaxRetryBodySize is an option that sets the max size of request body that
+// can be buffered for retries, defaults to 1MB.
+func ProxyMaxRetryBodySize(size int64) ProxyOption {
+	return func(p *Proxy) {
+		p.maxRetryBody = size
+	}
+}
+
+// ProxyRewrite is an option that sets the path of proxied requests, the parameters
+// in form of ":name" and "*name" are replaced with the route parameters, for example,
+// app.Get("/users/:id", p.Handle) with ProxyRewrite("/v1/users/:id").
+func ProxyRewrite(path string) ProxyOption {
+	return func(p *Proxy) {
+		p.rewrite = path
+	}
+}
+
+// ProxyPassiveHealthCheck is an option that marks a backend as unhealthy for the
+// fail timeout after the given number of consecutive failures, including connection
+// errors, 502, 503 and 504 responses.
+func ProxyPassiveHealthCheck(maxFails int, failTimeout time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.maxFails = maxFails
+		p.failTimeout = failTimeout
+	}
+}
+
+// ProxyHealthCheck is an option that checks the health of backends by requesting
+// the given path periodically, the backends that respond with 2xx or 3xx status
+// code are healthy. Zero interval and timeout mean ten seconds and five seconds.
+func ProxyHealthCheck(path string, interval, timeout time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.healthPath = path
+		p.healthInterval = interval
+		p.healthTimeout = timeout
+	}
+}
+
+// ProxyTransport is an option that sets the transport, defaults to
+// http.DefaultTransport.
+func ProxyTransport(transport http.RoundTripper) ProxyOption {
+	return func(p *Proxy) {
+		p.transport = transport
+	}
+}
+
+// ProxyFlushInterval is an option that sets the flush interval of responses, see
+// httputil.ReverseProxy.FlushInterval.
+func ProxyFlushInterval(interval time.Duration) ProxyOption {
+	return func(p *Proxy) {
+		p.flushInterval = interval
+	}
+}
+
+// Proxy is a reverse proxy that balances requests among backends, it can be used
+// as a handle, such as app.Any("/api/*path", p.Handle), or a http.Handler, such as
+// app.Mount("/api", p). The request path is proxied as is unless it is rewritten,
+// see ProxyRewrite, and the mount prefix is stripped.
+//
+// The Forwarded, X-Forwarded-For and X-Real-IP headers sent by clients are removed
+// unless the request comes from a trusted proxy, the client address is appended to
+// Forwarded and X-Forwarded-For, and X-Forwarded-Host and X-Forwarded-Proto are
+// overwritten, see SetTrustedProxies.
+//
+// WebSocket and other upgrade requests are passed through.
+type Proxy struct {
+	backends       []*ProxyBackend
+	balancer       LoadBalancer
+	retries        int
+	maxRetryBody   int64
+	rewrite        string
+	maxFails       int
+	failTimeout    time.Duration
+	healthPath     string
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	transport      http.RoundTripper
+	flushInterval  time.Duration
+	now            func() time.Time
+
+	proxy  *httputil.ReverseProxy
+	client *http.Client
+	stop   chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewProxy returns a proxy of the given target URLs, the health checks are started
+// if present, which should be stopped by Close.
+func NewProxy(targets []string, opts ...ProxyOption) (*Proxy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("clevergo: proxy requires at least one target")
+	}
+	p := &Proxy{
+		balancer:     RoundRobin(),
+		maxRetryBody: defaultProxyMaxRetryBody,
+		transport:    http.DefaultTransport,
+		now:          time.Now,
+		stop:         make(chan struct{}),
+	}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("clevergo: invalid proxy target %q", target)
+		}
+		p.backends = append(p.backends, &ProxyBackend{URL: u})
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Director:       p.director,
+		Transport:      p.transport,
+		FlushInterval:  p.flushInterval,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+	if p.healthPath != "" {
+		if p.healthInterval <= 0 {
+			p.healthInterval = defaultProxyHealthInterval
+		}
+		if p.healthTimeout <= 0 {
+			p.healthTimeout = defaultProxyHealthTimeout
+		}
+		p.client = &http.Client{
+			Transport: p.transport,
+			Timeout:   p.healthTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		p.wg.Add(1)
+		go p.healthCheck()
+	}
+	return p, nil
+}
+
+// Backends returns the backends.
+func (p *Proxy) Backends() []*ProxyBackend {
+	return append([]*ProxyBackend(nil), p.backends...)
+}
+
+// Close stops the health checks.
+func (p *Proxy) Close() error {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+	return nil
+}
+
+type proxyAttemptKey struct{}
+
+// proxyAttempt is the state of proxying a request to a backend.
+type proxyAttempt struct {
+	backend *ProxyBackend
+	path    string
+	rawPath string
+	host    string
+	proto   string
+	// trusted indicates whether the request comes from a trusted proxy.
+	trusted bool
+	// forwarded is the element appended to the Forwarded header.
+	forwarded string
+	status    int
+	err       error
+}
+
+// Handle proxies the request to one of the healthy backends, it returns
+// ErrServiceUnavailable if none of backends is healthy, and ErrBadGateway if
+// the backends could not be reached.
+func (p *Proxy) Handle(c *Context) error {
+	path, rawPath := c.Request.URL.Path, c.Request.URL.RawPath
+	if p.rewrite != "" {
+		path, rawPath = rewriteProxyPath(p.rewrite, c.Params), ""
+	}
+
+	retries := 0
+	var body []byte
+	if p.retries > 0 && isIdempotentMethod(c.Request.Method) {
+		retries = p.retries
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			buf, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, p.maxRetryBody+1))
+			if err != nil {
+				c.Request.Body.Close()
+				return err
+			}
+			if int64(len(buf)) > p.maxRetryBody {
+				// the body is too large to be buffered, streams it without retries.
+				retries = 0
+				c.Request.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(buf), c.Request.Body), c.Request.Body}
+			} else {
+				c.Request.Body.Close()
+				body = buf
+			}
+		}
+	}
+
+	// the forwarded headers sent by clients are removed or overwritten, unless the
+	// request comes from a trusted proxy, see Context.Host, Context.Scheme and
+	// Context.RealIP.
+	host, proto, trusted := c.Host(), c.Scheme(), c.fromTrustedProxy()
+	forwarded := forwardedElementString(c.Request)
+	var tried []*ProxyBackend
+	for {
+		backend := p.next(c, tried)
+		if backend == nil {
+			if len(tried) == 0 {
+				return ErrServiceUnavailable
+			}
+			return ErrBadGateway
+		}
+		tried = append(tried, backend)
+		attempt := &proxyAttempt{
+			backend:   backend,
+			path:      path,
+			rawPath:   rawPath,
+			host:      host,
+			proto:     proto,
+			trusted:   trusted,
+			forwarded: forwarded,
+		}
+		req := c.Request.WithContext(context.WithValue(c.Request.Context(), proxyAttemptKey{}, attempt))
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		p.serve(c.Response, req, backend)
+		if attempt.err == nil {
+			p.report(backend, attempt.status >= http.StatusBadGateway && attempt.status <= http.StatusGatewayTimeout)
+			return nil
+		}
+		if attempt.status != 0 {
+			// the backend responded, but the response could not be written.
+			return attempt.err
+		}
+		p.report(backend, true)
+		if len(tried) > retries || c.Request.Context().Err() != nil {
+			return ErrBadGateway
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := p.Handle(newContext(w, r)); err != nil {
+		code := http.StatusInternalServerError
+		if e, ok := err.(Error); ok {
+			code = e.Status()
+		}
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request, backend *ProxyBackend) {
+	atomic.AddInt64(&backend.conns, 1)
+	defer atomic.AddInt64(&backend.conns, -1)
+	p.proxy.ServeHTTP(w, r)
+}
+
+// next returns a healthy backend that has not been tried.
+func (p *Proxy) next(c *Context, tried []*ProxyBackend) *ProxyBackend {
+	now := p.now()
+	backends := make([]*ProxyBackend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.available(now) && !containsBackend(tried, b) {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		return nil
+	}
+	return p.balancer.Next(c, backends)
+}
+
+func containsBackend(backends []*ProxyBackend, backend *ProxyBackend) bool {
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// report records the result of passive health check.
+func (p *Proxy) report(b *ProxyBackend, failed bool) {
+	if p.maxFails <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.fails >= p.maxFails {
+		b.fails = 0
+		b.downUntil = p.now().Add(p.failTimeout)
+	}
+}
+
+func (p *Proxy) director(r *http.Request) {
+	attempt := r.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	target := attempt.backend.URL
+	r.URL.Scheme = target.Scheme
+	r.URL.Host = target.Host
+	r.URL.Path = singleJoiningSlash(target.Path, attempt.path)
+	r.URL.RawPath = ""
+	if attempt.rawPath != "" {
+		r.URL.RawPath = singleJoiningSlash(target.EscapedPath(), attempt.rawPath)
+	}
+	if target.RawQuery == "" || r.URL.RawQuery == "" {
+		r.URL.RawQuery = target.RawQuery + r.URL.RawQuery
+	} else {
+		r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+	}
+	if _, ok := r.Header["User-Agent"]; !ok {
+		// prevents the default User-Agent of Go client from being set.
+		r.Header.Set("User-Agent", "")
+	}
+	if !attempt.trusted {
+		// the X-Forwarded-For header will be set to the client address by
+		// httputil.ReverseProxy.
+		r.Header.Del(headerForwarded)
+		r.Header.Del(headerXForwardedFor)
+		r.Header.Del(headerXRealIP)
+	}
+	r.Header.Add(headerForwarded, attempt.forwarded)
+	r.Header.Set(headerXForwardedHost, attempt.host)
+	r.Header.Set(headerXForwardedProto, attempt.proto)
+}
+
+// forwardedElementString returns the Forwarded element of the request received
+// by the proxy, see RFC 7239.
+func forwardedElementString(r *http.Request) string {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	addr := remoteHost(r.RemoteAddr)
+	if strings.IndexByte(addr, ':') >= 0 {
+		addr = "[" + addr + "]"
+	}
+	return "for=" + quoteString(addr) + ";host=" + quoteString(r.Host) + ";proto=" + proto
+}
+
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	attempt := resp.Request.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	attempt.status = resp.StatusCode
+	return nil
+}
+
+// errorHandler records the error instead of writing the response, so that the
+// request can be retried.
+func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	attempt := r.Context().Value(proxyAttemptKey{}).(*proxyAttempt)
+	attempt.err = err
+}
+
+func (p *Proxy) healthCheck() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.healthInterval)
+	defer ticker.Stop()
+	for {
+		p.checkBackends()
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Proxy) checkBackends() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func(b *ProxyBackend) {
+			defer wg.Done()
+			b.setHealthy(p.check(b))
+		}(b)
+	}
+	wg.Wait()
+}
+
+func (p *Proxy) check(b *ProxyBackend) bool {
+	u := *b.URL
+	u.Path = singleJoiningSlash(u.Path, p.healthPath)
+	u.RawPath = ""
+	u.RawQuery = ""
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// rewriteProxyPath replaces the parameters of the pattern with route parameters.
+func rewriteProxyPath(pattern string, params Params) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if len(segment) < 2 {
+			continue
+		}
+		switch segment[0] {
+		case ':':
+			segments[i] = params.String(segment[1:])
+		case '*':
+			// the value of catch-all parameter starts with a slash.
+			segments[i] = strings.TrimPrefix(params.String(segment[1:]), "/")
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash && b != "":
+		return a + "/" + b
+	}
+	return a + b
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
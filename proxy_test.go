@@ -0,0 +1,463 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newProxyTestBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Backend", name)
+		fmt.Fprintf(w, "%s %s %s?%s %s %s", name, r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get(headerXForwardedHost), body)
+	}))
+}
+
+func newProxyTestBackends(names ...string) ([]*httptest.Server, []string) {
+	servers := make([]*httptest.Server, len(names))
+	urls := make([]string, len(names))
+	for i, name := range names {
+		servers[i] = newProxyTestBackend(name)
+		urls[i] = servers[i].URL
+	}
+	return servers, urls
+}
+
+func closeProxyTestBackends(servers []*httptest.Server) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+func proxyTestBackend(p *Proxy, c *Context) string {
+	return p.next(c, nil).URL.String()
+}
+
+func TestNewProxy(t *testing.T) {
+	_, err := NewProxy(nil)
+	assert.NotNil(t, err)
+	_, err = NewProxy([]string{"localhost"})
+	assert.NotNil(t, err)
+	_, err = NewProxy([]string{":%"})
+	assert.NotNil(t, err)
+
+	p, err := NewProxy([]string{"http://localhost:8080", "http://localhost:8081/api"})
+	assert.Nil(t, err)
+	backends := p.Backends()
+	assert.Len(t, backends, 2)
+	assert.Equal(t, "/api", backends[1].URL.Path)
+	assert.True(t, backends[0].Healthy())
+	assert.Nil(t, p.Close())
+}
+
+func TestProxy(t *testing.T) {
+	servers, urls := newProxyTestBackends("foo", "bar")
+	defer closeProxyTestBackends(servers)
+	p, err := NewProxy(urls)
+	assert.Nil(t, err)
+
+	app := Pure()
+	app.Any("/api/*path", p.Handle)
+	var got []string
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/users?page=2", strings.NewReader("body"))
+		req.Host = "example.com"
+		app.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		got = append(got, w.Body.String())
+	}
+	assert.Equal(t, []string{
+		"foo POST /api/users?page=2 example.com body",
+		"bar POST /api/users?page=2 example.com body",
+		"foo POST /api/users?page=2 example.com body",
+		"bar POST /api/users?page=2 example.com body",
+	}, got)
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s\n%s\n%s", r.Header.Get(headerXForwardedHost), r.Header.Get(headerXForwardedProto),
+			strings.Join(r.Header[headerXForwardedFor], ", "), strings.Join(r.Header[headerForwarded], ", "))
+	}))
+	defer s.Close()
+	p, err := NewProxy([]string{s.URL})
+	assert.Nil(t, err)
+
+	app := Pure()
+	app.SetTrustedProxies("10.0.0.0/8")
+	app.Any("/*path", p.Handle)
+	cases := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		// the spoofed headers of clients are removed or overwritten.
+		{"192.0.2.1:1234", "example.com http\n192.0.2.1\n" + `for="192.0.2.1";host="example.com";proto=http`},
+		{"[2001:db8::1]:1234", "example.com http\n2001:db8::1\n" + `for="[2001:db8::1]";host="example.com";proto=http`},
+		{"10.0.0.1:1234", "foo.com https\n6.6.6.6, 10.0.0.1\n" + `for=6.6.6.6;host=foo.com;proto=https, for="10.0.0.1";host="example.com";proto=http`},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set(headerForwarded, "for=6.6.6.6;host=foo.com;proto=https")
+		req.Header.Set(headerXForwardedFor, "6.6.6.6")
+		req.Header.Set(headerXForwardedHost, "foo.com")
+		req.Header.Set(headerXForwardedProto, "https")
+		req.Header.Set(headerXRealIP, "6.6.6.6")
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.expected, w.Body.String(), test.remoteAddr)
+	}
+}
+
+func TestProxySpoofedForwarded(t *testing.T) {
+	for _, headers := range []ForwardedHeaders{XForwardedHeaders, RFC7239Headers} {
+		backend := Pure()
+		backend.SetTrustedProxies("127.0.0.1")
+		backend.ForwardedHeaders = headers
+		backend.Get("/", func(c *Context) error {
+			return c.String(http.StatusOK, c.RealIP()+" "+c.Host()+" "+c.Scheme())
+		})
+		s := httptest.NewServer(backend)
+		p, err := NewProxy([]string{s.URL})
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(headerForwarded, "for=6.6.6.6;host=evil.example;proto=https")
+		req.Header.Set(headerXForwardedFor, "6.6.6.6")
+		req.Header.Set(headerXRealIP, "6.6.6.6")
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "192.0.2.1 example.com http", w.Body.String())
+		s.Close()
+	}
+}
+
+func TestProxyTargetPath(t *testing.T) {
+	s := newProxyTestBackend("foo")
+	defer s.Close()
+	p, err := NewProxy([]string{s.URL + "/v1/?token=abc"})
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?page=2", nil))
+	assert.Equal(t, "foo GET /v1/users?token=abc&page=2 example.com ", w.Body.String())
+}
+
+func TestProxyRewrite(t *testing.T) {
+	s := newProxyTestBackend("foo")
+	defer s.Close()
+	p, err := NewProxy([]string{s.URL}, ProxyRewrite("/v1/users/:id/*path"))
+	assert.Nil(t, err)
+	app := Pure()
+	app.Get("/users/:id/*path", p.Handle)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/foo/posts/1", nil))
+	assert.Equal(t, "foo GET /v1/users/foo/posts/1? example.com ", w.Body.String())
+
+	cases := []struct {
+		pattern  string
+		params   Params
+		expected string
+	}{
+		{"/", nil, "/"},
+		{"/users/:id", Params{{"id", "bar"}}, "/users/bar"},
+		{"/files/*filepath", Params{{"filepath", "/a/b"}}, "/files/a/b"},
+		{"/files/*filepath", Params{{"filepath", "/"}}, "/files/"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, rewriteProxyPath(test.pattern, test.params))
+	}
+}
+
+func TestProxyMount(t *testing.T) {
+	s := newProxyTestBackend("foo")
+	defer s.Close()
+	p, err := NewProxy([]string{s.URL})
+	assert.Nil(t, err)
+	app := Pure()
+	app.Mount("/api", p)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	assert.Equal(t, "foo GET /users? example.com ", w.Body.String())
+}
+
+func TestLeastConn(t *testing.T) {
+	p, err := NewProxy([]string{"http://foo", "http://bar", "http://baz"}, ProxyLoadBalancer(LeastConn()))
+	assert.Nil(t, err)
+	backends := p.Backends()
+	backends[0].conns = 2
+	backends[1].conns = 1
+	backends[2].conns = 3
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "http://bar", proxyTestBackend(p, c))
+	backends[1].conns = 2
+	assert.Equal(t, "http://foo", proxyTestBackend(p, c))
+}
+
+func TestConsistentHash(t *testing.T) {
+	targets := []string{"http://foo", "http://bar", "http://baz", "http://qux"}
+	p, err := NewProxy(targets, ProxyLoadBalancer(ConsistentHashHeader("X-User")))
+	assert.Nil(t, err)
+	newCtx := func(user string) *Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-User", user)
+		return newContext(nil, req)
+	}
+
+	mapping := make(map[string]string)
+	used := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user%d", i)
+		backend := proxyTestBackend(p, newCtx(user))
+		mapping[user] = backend
+		used[backend] = true
+		// the same key is always proxied to the same backend.
+		assert.Equal(t, backend, proxyTestBackend(p, newCtx(user)))
+	}
+	assert.Len(t, used, len(targets))
+
+	// only the keys of the down backend are moved.
+	p.Backends()[1].setHealthy(false)
+	for user, backend := range mapping {
+		actual := proxyTestBackend(p, newCtx(user))
+		if backend == "http://bar" {
+			assert.NotEqual(t, backend, actual)
+		} else {
+			assert.Equal(t, backend, actual)
+		}
+	}
+
+	// empty keys are balanced in turn.
+	c := newCtx("")
+	assert.NotEqual(t, proxyTestBackend(p, c), proxyTestBackend(p, c))
+
+	p, err = NewProxy(targets, ProxyLoadBalancer(ConsistentHashParam("id")))
+	assert.Nil(t, err)
+	c = newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	c.Params = Params{{"id", "foo"}}
+	backend := proxyTestBackend(p, c)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, backend, proxyTestBackend(p, c))
+	}
+}
+
+func TestProxyRetries(t *testing.T) {
+	s := newProxyTestBackend("foo")
+	defer s.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	p, err := NewProxy([]string{down.URL, s.URL}, ProxyRetries(1))
+	assert.Nil(t, err)
+	app := Pure()
+	app.Use(ErrorHandler())
+	app.Any("/*path", p.Handle)
+
+	// retried on another backend.
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users", strings.NewReader("body")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo PUT /users? example.com body", w.Body.String())
+
+	// non-idempotent requests are never retried.
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	p, err = NewProxy([]string{down.URL})
+	assert.Nil(t, err)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestProxyMaxRetryBodySize(t *testing.T) {
+	s := newProxyTestBackend("foo")
+	defer s.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	p, err := NewProxy([]string{down.URL, s.URL}, ProxyRetries(1), ProxyMaxRetryBodySize(4))
+	assert.Nil(t, err)
+	app := Pure()
+	app.Use(ErrorHandler())
+	app.Any("/*path", p.Handle)
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users", strings.NewReader("body")))
+	assert.Equal(t, "foo PUT /users? example.com body", w.Body.String())
+
+	// the larger body is streamed without retries.
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users", strings.NewReader("large body")))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users", strings.NewReader("large body")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "foo PUT /users? example.com large body", w.Body.String())
+}
+
+func TestProxyPassiveHealthCheck(t *testing.T) {
+	var failing int32 = 1
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+
+	now := time.Now()
+	p, err := NewProxy([]string{s.URL}, ProxyPassiveHealthCheck(2, time.Minute), func(p *Proxy) {
+		p.now = func() time.Time { return now }
+	})
+	assert.Nil(t, err)
+	app := Pure()
+	app.Use(ErrorHandler())
+	app.Get("/", p.Handle)
+	serve := func() int {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	atomic.StoreInt32(&failing, 0)
+	// the backend is down for one minute.
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.False(t, p.Backends()[0].available(now))
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, http.StatusOK, serve())
+
+	// successes reset the failures.
+	atomic.StoreInt32(&failing, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	atomic.StoreInt32(&failing, 0)
+	assert.Equal(t, http.StatusOK, serve())
+	atomic.StoreInt32(&failing, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+	assert.True(t, p.Backends()[0].available(now))
+}
+
+func TestProxyHealthCheck(t *testing.T) {
+	var healthy int32 = 1
+	var checks int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/health" {
+			atomic.AddInt32(&checks, 1)
+			if atomic.LoadInt32(&healthy) == 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+
+	p, err := NewProxy([]string{s.URL + "/api", "http://127.0.0.1:1"}, ProxyHealthCheck("/health", time.Hour, time.Second))
+	assert.Nil(t, err)
+	defer p.Close()
+	p.checkBackends()
+	assert.True(t, atomic.LoadInt32(&checks) > 0)
+	backends := p.Backends()
+	assert.True(t, backends[0].Healthy())
+	assert.False(t, backends[1].Healthy())
+
+	atomic.StoreInt32(&healthy, 0)
+	p.checkBackends()
+	assert.False(t, backends[0].Healthy())
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	atomic.StoreInt32(&healthy, 1)
+	p.checkBackends()
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Nil(t, p.Close())
+	assert.Nil(t, p.Close())
+}
+
+func TestProxyWebSocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+		line, _ := rw.ReadString('\n')
+		rw.WriteString("echo " + line)
+		rw.Flush()
+	}))
+	defer backend.Close()
+
+	p, err := NewProxy([]string{backend.URL}, ProxyLoadBalancer(LeastConn()))
+	assert.Nil(t, err)
+	app := Pure()
+	app.Use(ErrorHandler())
+	app.Get("/ws", p.Handle)
+	s := httptest.NewServer(app)
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	conn, err := net.Dial("tcp", u.Host)
+	assert.Nil(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+u.Host+"\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, int64(1), p.Backends()[0].Conns())
+
+	io.WriteString(conn, "hello\n")
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "echo hello\n", line)
+}
+
+func TestSingleJoiningSlash(t *testing.T) {
+	cases := []struct {
+		a, b, expected string
+	}{
+		{"", "/foo", "/foo"},
+		{"/api", "/foo", "/api/foo"},
+		{"/api/", "/foo", "/api/foo"},
+		{"/api", "foo", "/api/foo"},
+		{"/api", "", "/api"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, singleJoiningSlash(test.a, test.b))
+	}
+}
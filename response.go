@@ -0,0 +1,115 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps a http.ResponseWriter, and records the status code and
+// the number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	statusCode  int
+	size        int64
+
+	// beforeWriteHeader is called before writing header if present, it is useful
+	// for modifying header lazily.
+	beforeWriteHeader func()
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+	}
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		if w.beforeWriteHeader != nil {
+			w.beforeWriteHeader()
+		}
+		w.wroteHeader = true
+		w.statusCode = statusCode
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// status returns the status code of response, if the response has not been
+// written, it returns the status code that the given error will be reported as.
+func (w *responseWriter) status(err error) int {
+	if err == nil || w.wroteHeader {
+		return w.statusCode
+	}
+	if e, ok := err.(Error); ok {
+		return e.Status()
+	}
+	return http.StatusInternalServerError
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+var errHijackerNotSupported = errors.New("response writer does not implement http.Hijacker")
+
+// Hijack implements http.Hijacker.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errHijackerNotSupported
+}
+
+// Unwrap returns the original http.ResponseWriter.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// streamWriter writes the header lazily on the first write or flush.
+type streamWriter struct {
+	c           *Context
+	code        int
+	contentType string
+	wroteHeader bool
+}
+
+func (w *streamWriter) writeHeader() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.c.SetContentType(w.contentType)
+		w.c.Response.WriteHeader(w.code)
+	}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.writeHeader()
+	return w.c.Response.Write(p)
+}
+
+// Flush implements http.Flusher.
+func (w *streamWriter) Flush() {
+	w.writeHeader()
+	if f, ok := w.c.Response.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	resp := newResponseWriter(w)
+	assert.Equal(t, w, resp.Unwrap())
+	assert.Equal(t, http.StatusOK, resp.statusCode)
+	assert.False(t, resp.wroteHeader)
+
+	resp.WriteHeader(http.StatusNotFound)
+	resp.WriteHeader(http.StatusOK)
+	assert.Equal(t, http.StatusNotFound, resp.statusCode)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	resp.Write([]byte("foo"))
+	resp.Write([]byte("bar"))
+	assert.Equal(t, int64(6), resp.size)
+	assert.Equal(t, "foobar", w.Body.String())
+
+	resp.Flush()
+	assert.True(t, w.Flushed)
+
+	_, _, err := resp.Hijack()
+	assert.Equal(t, errHijackerNotSupported, err)
+}
+
+func TestResponseWriterImplicitWriteHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	resp := newResponseWriter(w)
+	resp.Write([]byte("foo"))
+	assert.True(t, resp.wroteHeader)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestResponseWriterBeforeWriteHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	resp := newResponseWriter(w)
+	calls := 0
+	resp.beforeWriteHeader = func() {
+		calls++
+		resp.Header().Set("X-Foo", "bar")
+	}
+	resp.Write([]byte("foo"))
+	resp.WriteHeader(http.StatusNotFound)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "bar", w.Header().Get("X-Foo"))
+}
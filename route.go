@@ -21,17 +21,29 @@ type Route struct {
 	pattern string
 	params  []routeParam
 	handle  Handle
+	values  map[interface{}]interface{}
+	// policies that authorize requests, see RequirePolicy.
+	policies []Policy
 }
 
 func newRoute(path string, handle Handle, opts ...RouteOption) *Route {
 	r := &Route{
 		path:    path,
 		pattern: path,
-		handle:  handle,
+	}
+	// the endpoint is resolved after applying options, so that the policies are
+	// always checked after the route middlewares, regardless of the options order.
+	var endpoint Handle
+	r.handle = func(c *Context) error {
+		return endpoint(c)
 	}
 	for _, opt := range opts {
 		opt(r)
 	}
+	endpoint = handle
+	if len(r.policies) > 0 {
+		endpoint = authorize(AllPolicies(r.policies...), handle)
+	}
 	r.parse()
 	return r
 }
@@ -84,6 +96,32 @@ func (r *Route) URL(args ...string) (*url.URL, error) {
 	}, nil
 }
 
+// Value returns the value associated with the given key, see RouteValue.
+func (r *Route) Value(key interface{}) interface{} {
+	return r.values[key]
+}
+
+func (r *Route) setValue(key, value interface{}) {
+	if r.values == nil {
+		r.values = make(map[interface{}]interface{})
+	}
+	r.values[key] = value
+}
+
+// lookupRoute returns the matched route and parameters, the route will be looked up
+// in advance if it is called by a global middleware.
+func lookupRoute(c *Context) (*Route, Params) {
+	if c.Route != nil || c.app == nil {
+		return c.Route, c.Params
+	}
+	path := c.Request.URL.Path
+	if c.app.UseRawPath && c.Request.URL.RawPath != "" {
+		path = c.Request.URL.RawPath
+	}
+	route, params, _ := c.app.Lookup(c.Request.Method, path)
+	return route, params
+}
+
 type routeParam struct {
 	name     string
 	required bool
@@ -100,6 +138,14 @@ func RouteName(name string) RouteOption {
 	}
 }
 
+// RouteValue is a route option that associates the value with the key,
+// the value can be retrieved by Route.Value.
+func RouteValue(key, value interface{}) RouteOption {
+	return func(r *Route) {
+		r.setValue(key, value)
+	}
+}
+
 func isRouteNameOption(opt RouteOption) bool {
 	r := &Route{}
 	opt(r)
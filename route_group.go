@@ -4,7 +4,10 @@
 
 package clevergo
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
 
 // RouteGroupOption applies options to a route group.
 type RouteGroupOption func(*RouteGroup)
@@ -23,6 +26,28 @@ func RouteGroupMiddleware(middlewares ...MiddlewareFunc) RouteGroupOption {
 	}
 }
 
+// RouteGroupNotFound is an option that sets the handler for the requests whose path
+// starts with the path of route group but does not match any routes. The handler
+// runs through the middlewares of route group, and takes precedence over
+// Application.NotFound. The handler is inherited by sub groups, and the handler of
+// the route group that has the longest matching path among the groups that have
+// the handler is used, the same applies to RouteGroupMethodNotAllowed.
+func RouteGroupNotFound(handle Handle) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.notFound = handle
+	}
+}
+
+// RouteGroupMethodNotAllowed is an option that sets the handler for the requests whose
+// path starts with the path of route group and matches routes of other methods only.
+// The handler runs through the middlewares of route group, and takes precedence over
+// Application.MethodNotAllowed.
+func RouteGroupMethodNotAllowed(handle Handle) RouteGroupOption {
+	return func(r *RouteGroup) {
+		r.methodNotAllowed = handle
+	}
+}
+
 // RouteGroup implements an nested route group,
 // see https://github.com/julienschmidt/httprouter/pull/89.
 type RouteGroup struct {
@@ -30,6 +55,12 @@ type RouteGroup struct {
 	path        string
 	name        string
 	middlewares []MiddlewareFunc
+	// route options that applied to all routes of the group, prior to the
+	// options of particular route.
+	routeOptions []RouteOption
+	// fallback handlers of unmatched requests.
+	notFound         Handle
+	methodNotAllowed Handle
 }
 
 func newRouteGroup(app *Application, path string, opts ...RouteGroupOption) *RouteGroup {
@@ -46,6 +77,7 @@ func newRouteGroup(app *Application, path string, opts ...RouteGroupOption) *Rou
 	for _, opt := range opts {
 		opt(route)
 	}
+	app.groups = append(app.groups, route)
 
 	return route
 }
@@ -56,6 +88,15 @@ func (r *RouteGroup) Group(path string, opts ...RouteGroupOption) Router {
 
 	// inherit middlewares.
 	router.middlewares = append(r.middlewares, router.middlewares...)
+	// inherit route options.
+	router.routeOptions = append(append([]RouteOption{}, r.routeOptions...), router.routeOptions...)
+	// inherit fallback handlers.
+	if router.notFound == nil {
+		router.notFound = r.notFound
+	}
+	if router.methodNotAllowed == nil {
+		router.methodNotAllowed = r.methodNotAllowed
+	}
 
 	return router
 }
@@ -77,8 +118,10 @@ func (r RouteGroup) middlewareOption() RouteOption {
 }
 
 func (r *RouteGroup) combineOptions(opts []RouteOption) []RouteOption {
-	opts = append(opts, r.nameOption(), r.middlewareOption())
-	return opts
+	combined := make([]RouteOption, 0, len(r.routeOptions)+len(opts)+2)
+	combined = append(combined, r.routeOptions...)
+	combined = append(combined, opts...)
+	return append(combined, r.nameOption(), r.middlewareOption())
 }
 
 // Handle implements Router.Handle.
@@ -136,6 +179,49 @@ func (r *RouteGroup) Any(path string, handle Handle, opts ...RouteOption) {
 	r.parent.Any(r.subPath(path), handle, r.combineOptions(opts)...)
 }
 
+// Mount implements Router.Mount.
+func (r *RouteGroup) Mount(prefix string, handler http.Handler, opts ...RouteOption) {
+	r.parent.Mount(r.subPath(prefix), handler, r.combineOptions(opts)...)
+}
+
 func (r *RouteGroup) subPath(path string) string {
 	return r.path + path
 }
+
+// match reports whether the path starts with the path of route group, parameters
+// of the group path match any segments.
+func (r *RouteGroup) match(path string) bool {
+	prefix := r.path
+	for {
+		if prefix == "" || prefix == "/" {
+			return true
+		}
+		if path == "" || path[0] != '/' || prefix[0] != '/' {
+			return false
+		}
+		prefix, path = prefix[1:], path[1:]
+
+		var segment, expected string
+		if i := strings.IndexByte(prefix, '/'); i >= 0 {
+			expected, prefix = prefix[:i], prefix[i:]
+		} else {
+			expected, prefix = prefix, ""
+		}
+		if i := strings.IndexByte(path, '/'); i >= 0 {
+			segment, path = path[:i], path[i:]
+		} else {
+			segment, path = path, ""
+		}
+
+		switch {
+		case expected != "" && expected[0] == '*':
+			return true
+		case expected != "" && expected[0] == ':':
+			if segment == "" {
+				return false
+			}
+		case expected != segment:
+			return false
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -92,3 +93,173 @@ func TestRouteGroupName(t *testing.T) {
 		assert.Equal(t, name, g.name)
 	}
 }
+
+func TestRouteGroupRouteOptions(t *testing.T) {
+	app := Pure()
+	api := app.Group("/api").(*RouteGroup)
+	api.routeOptions = append(api.routeOptions, RouteValue("version", "v0"), RouteValue("foo", "bar"))
+	v1 := api.Group("/v1").(*RouteGroup)
+	v1.routeOptions = append(v1.routeOptions, RouteValue("version", "v1"))
+	api.Get("/", echoHandler(""))
+	v1.Get("/", echoHandler(""))
+	v1.Get("/users", echoHandler(""), RouteValue("version", "v2"))
+	assert.Len(t, api.routeOptions, 2)
+
+	cases := []struct {
+		path    string
+		version string
+	}{
+		{"/api/", "v0"},
+		{"/api/v1/", "v1"},
+		{"/api/v1/users", "v2"},
+	}
+	for _, test := range cases {
+		route, _, _ := app.Lookup(http.MethodGet, test.path)
+		assert.Equal(t, test.version, route.Value("version"))
+		assert.Equal(t, "bar", route.Value("foo"))
+	}
+}
+
+func TestRouteGroupMatch(t *testing.T) {
+	cases := []struct {
+		group string
+		path  string
+		match bool
+	}{
+		{"/", "/", true},
+		{"/", "/foo", true},
+		{"/api", "/api", true},
+		{"/api", "/api/", true},
+		{"/api", "/api/users", true},
+		{"/api", "/apis", false},
+		{"/api", "/", false},
+		{"/api/v1", "/api", false},
+		{"/users/:id", "/users/1/posts", true},
+		{"/users/:id", "/users/", false},
+		{"/users/:id/posts", "/users/1/posts/2", true},
+		{"/users/:id/posts", "/users/1/comments", false},
+		{"/files/*filepath", "/files/a/b", true},
+	}
+	app := Pure()
+	for _, test := range cases {
+		g := app.Group(test.group).(*RouteGroup)
+		assert.Equal(t, test.match, g.match(test.path), test.group+" "+test.path)
+	}
+}
+
+func TestRouteGroupFallbacks(t *testing.T) {
+	jsonError := func(code int) Handle {
+		return func(c *Context) error {
+			return c.JSON(code, map[string]string{"error": http.StatusText(code)})
+		}
+	}
+	header := func(value string) MiddlewareFunc {
+		return func(next Handle) Handle {
+			return func(c *Context) error {
+				c.Response.Header().Add("X-Middleware", value)
+				return next(c)
+			}
+		}
+	}
+
+	app := Pure()
+	app.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("html"))
+	})
+	app.Get("/", echoHandler("home"))
+	api := app.Group("/api",
+		RouteGroupMiddleware(header("api")),
+		RouteGroupNotFound(jsonError(http.StatusNotFound)),
+		RouteGroupMethodNotAllowed(jsonError(http.StatusMethodNotAllowed)),
+	)
+	api.Get("/users", echoHandler("users"))
+	v1 := api.Group("/v1", RouteGroupMiddleware(header("v1")))
+	v1.Get("/posts", echoHandler("posts"))
+	users := app.Group("/users/:id", RouteGroupNotFound(echoHandler("user not found")))
+	users.Get("/profile", echoHandler("profile"))
+	// sibling groups without fallback handlers do not hide the handlers of /api.
+	app.Group("/api/v2", RouteGroupMiddleware(header("v2"))).Get("/posts", echoHandler("posts"))
+	app.Group("/api/v3", RouteGroupNotFound(echoHandler("v3 not found"))).Get("/posts", echoHandler("posts"))
+
+	cases := []struct {
+		method     string
+		path       string
+		code       int
+		body       string
+		middleware []string
+		allow      string
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound, "html", nil, ""},
+		{http.MethodGet, "/api/missing", http.StatusNotFound, `{"error":"Not Found"}`, []string{"api"}, ""},
+		{http.MethodPost, "/api/users", http.StatusMethodNotAllowed, `{"error":"Method Not Allowed"}`, []string{"api"}, "GET, OPTIONS"},
+		{http.MethodGet, "/api/v1/missing", http.StatusNotFound, `{"error":"Not Found"}`, []string{"api", "v1"}, ""},
+		{http.MethodGet, "/users/1/missing", http.StatusOK, "user not found", nil, ""},
+		{http.MethodGet, "/users/1/profile", http.StatusOK, "profile", nil, ""},
+		{http.MethodGet, "/apis", http.StatusNotFound, "html", nil, ""},
+		{http.MethodGet, "/api/v2/missing", http.StatusNotFound, `{"error":"Not Found"}`, []string{"api"}, ""},
+		{http.MethodPost, "/api/v2/posts", http.StatusMethodNotAllowed, `{"error":"Method Not Allowed"}`, []string{"api"}, "GET, OPTIONS"},
+		{http.MethodGet, "/api/v3/missing", http.StatusOK, "v3 not found", nil, ""},
+		{http.MethodPost, "/api/v3/posts", http.StatusMethodNotAllowed, `{"error":"Method Not Allowed"}`, []string{"api"}, "GET, OPTIONS"},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, strings.TrimSpace(w.Body.String()), test.path)
+		assert.Equal(t, test.middleware, w.Header()["X-Middleware"], test.path)
+		assert.Equal(t, test.allow, w.Header().Get("Allow"), test.path)
+	}
+}
+
+func TestRouteGroupMiddlewaresOfUnmatchedRequests(t *testing.T) {
+	cors := func(next Handle) Handle {
+		return func(c *Context) error {
+			c.SetHeader("Access-Control-Allow-Origin", "*")
+			return next(c)
+		}
+	}
+	auth := func(next Handle) Handle {
+		return func(c *Context) error {
+			if c.GetHeader("Authorization") == "" {
+				return ErrUnauthorized
+			}
+			return next(c)
+		}
+	}
+
+	app := Pure()
+	app.Get("/", echoHandler("home"))
+	api := app.Group("/api", RouteGroupMiddleware(cors))
+	api.Get("/users", echoHandler("users"))
+	admin := api.Group("/admin", RouteGroupMiddleware(auth))
+	admin.Get("/users", echoHandler("admin users"))
+
+	cases := []struct {
+		method        string
+		path          string
+		authorization string
+		code          int
+		origin        string
+		allow         string
+	}{
+		{http.MethodOptions, "/api/users", "", http.StatusOK, "*", "GET, OPTIONS"},
+		{http.MethodPost, "/api/users", "", http.StatusMethodNotAllowed, "*", "GET, OPTIONS"},
+		{http.MethodGet, "/api/missing", "", http.StatusNotFound, "*", ""},
+		{http.MethodGet, "/missing", "", http.StatusNotFound, "", ""},
+		{http.MethodOptions, "/", "", http.StatusOK, "", "GET, OPTIONS"},
+		{http.MethodGet, "/api/admin/missing", "", http.StatusUnauthorized, "*", ""},
+		{http.MethodGet, "/api/admin/missing", "token", http.StatusNotFound, "*", ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.method+" "+test.path)
+		assert.Equal(t, test.origin, w.Header().Get("Access-Control-Allow-Origin"), test.method+" "+test.path)
+		assert.Equal(t, test.allow, w.Header().Get("Allow"), test.method+" "+test.path)
+	}
+}
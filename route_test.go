@@ -160,6 +160,14 @@ func TestRouteMiddleware(t *testing.T) {
 	assert.Equal(t, "m1 m2 hello", w.Body.String())
 }
 
+func TestRouteValue(t *testing.T) {
+	route := newRoute("/", echoHandler(""), RouteValue("foo", "bar"), RouteValue("fizz", 1))
+	assert.Equal(t, "bar", route.Value("foo"))
+	assert.Equal(t, 1, route.Value("fizz"))
+	assert.Nil(t, route.Value("buzz"))
+	assert.Nil(t, newRoute("/", echoHandler("")).Value("foo"))
+}
+
 func TestNestedRouteGroup(t *testing.T) {
 	m1 := echoMiddleware("m1")
 	m2 := echoMiddleware("m2")
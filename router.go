@@ -51,4 +51,10 @@ type Router interface {
 
 	// HandlerFunc is an adapter for registering http.HandlerFunc.
 	HandlerFunc(method, path string, f http.HandlerFunc, opts ...RouteOption)
+
+	// Mount mounts the handler under the given prefix for any HTTP methods, the prefix
+	// is stripped from the request URL before passing it to the handler, and must not
+	// contain parameters. If the handler is an *Application, its RouteURL generates
+	// URLs that start with the prefix.
+	Mount(prefix string, handler http.Handler, opts ...RouteOption)
 }
@@ -0,0 +1,270 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerStrictTransportSecurity         = "Strict-Transport-Security"
+	headerContentSecurityPolicy           = "Content-Security-Policy"
+	headerContentSecurityPolicyReportOnly = "Content-Security-Policy-Report-Only"
+	headerXFrameOptions                   = "X-Frame-Options"
+	headerXContentTypeOptions             = "X-Content-Type-Options"
+	headerReferrerPolicy                  = "Referrer-Policy"
+	headerPermissionsPolicy               = "Permissions-Policy"
+	headerCrossOriginOpenerPolicy         = "Cross-Origin-Opener-Policy"
+	headerCrossOriginEmbedderPolicy       = "Cross-Origin-Embedder-Policy"
+	headerCrossOriginResourcePolicy       = "Cross-Origin-Resource-Policy"
+
+	cspDirectiveDefaultSrc = "default-src"
+	cspDirectiveScriptSrc  = "script-src"
+	cspDirectiveStyleSrc   = "style-src"
+)
+
+// HSTS is the HTTP Strict Transport Security policy.
+type HSTS struct {
+	// MaxAge is the time that the browser should remember that the site is only
+	// to be accessed using HTTPS, the header will not be sent if it is zero.
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+// String returns the value of Strict-Transport-Security header.
+func (h HSTS) String() string {
+	s := "max-age=" + strconv.FormatInt(int64(h.MaxAge/time.Second), 10)
+	if h.IncludeSubDomains {
+		s += "; includeSubDomains"
+	}
+	if h.Preload {
+		s += "; preload"
+	}
+	return s
+}
+
+// ContentSecurityPolicy is the Content Security Policy.
+type ContentSecurityPolicy struct {
+	// Directives maps directive names to their values, such as
+	// "default-src": {"'self'"}, directives are sorted by name when building the header.
+	Directives map[string][]string
+
+	// Nonce indicates whether to generate a nonce for every request, the nonce
+	// will be appended to the script-src and style-src directives if present, and
+	// to the default-src directive if either of them is absent, since browsers fall
+	// back to it. The nonce can be retrieved by Context.CSPNonce.
+	Nonce bool
+
+	// ReportOnly indicates whether to send Content-Security-Policy-Report-Only
+	// header instead of Content-Security-Policy.
+	ReportOnly bool
+}
+
+// build returns the value of header with the given nonce.
+func (p ContentSecurityPolicy) build(nonce string) string {
+	names := make([]string, 0, len(p.Directives))
+	for name := range p.Directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	_, hasScriptSrc := p.Directives[cspDirectiveScriptSrc]
+	_, hasStyleSrc := p.Directives[cspDirectiveStyleSrc]
+	directives := make([]string, 0, len(names))
+	for _, name := range names {
+		values := p.Directives[name]
+		if nonce != "" && (name == cspDirectiveScriptSrc || name == cspDirectiveStyleSrc ||
+			(name == cspDirectiveDefaultSrc && (!hasScriptSrc || !hasStyleSrc))) {
+			values = append(values[:len(values):len(values)], "'nonce-"+nonce+"'")
+		}
+		directive := name
+		if len(values) > 0 {
+			directive += " " + strings.Join(values, " ")
+		}
+		directives = append(directives, directive)
+	}
+	return strings.Join(directives, "; ")
+}
+
+func (p ContentSecurityPolicy) header() string {
+	if p.ReportOnly {
+		return headerContentSecurityPolicyReportOnly
+	}
+	return headerContentSecurityPolicy
+}
+
+// SecureOption is a function that receives a secure instance.
+type SecureOption func(*secure)
+
+// SecureHSTS is an option that sets HSTS policy, the header will be sent to HTTPS
+// requests only, see Context.IsTLS.
+func SecureHSTS(hsts HSTS) SecureOption {
+	return func(s *secure) {
+		s.hsts = hsts
+	}
+}
+
+// SecureCSP is an option that sets content security policy.
+func SecureCSP(csp ContentSecurityPolicy) SecureOption {
+	return func(s *secure) {
+		s.csp = &csp
+	}
+}
+
+// SecureFrameOptions is an option that sets X-Frame-Options header,
+// such as "DENY" and "SAMEORIGIN", empty value disables the header.
+func SecureFrameOptions(value string) SecureOption {
+	return func(s *secure) {
+		s.frameOptions = value
+	}
+}
+
+// SecureContentTypeNosniff is an option that indicates whether to send
+// "X-Content-Type-Options: nosniff" header.
+func SecureContentTypeNosniff(enabled bool) SecureOption {
+	return func(s *secure) {
+		s.contentTypeNosniff = enabled
+	}
+}
+
+// SecureReferrerPolicy is an option that sets Referrer-Policy header,
+// empty value disables the header.
+func SecureReferrerPolicy(value string) SecureOption {
+	return func(s *secure) {
+		s.referrerPolicy = value
+	}
+}
+
+// SecurePermissionsPolicy is an option that sets Permissions-Policy header,
+// such as "geolocation=(), camera=()".
+func SecurePermissionsPolicy(value string) SecureOption {
+	return func(s *secure) {
+		s.permissionsPolicy = value
+	}
+}
+
+// SecureCrossOriginOpenerPolicy is an option that sets Cross-Origin-Opener-Policy header,
+// such as "same-origin".
+func SecureCrossOriginOpenerPolicy(value string) SecureOption {
+	return func(s *secure) {
+		s.crossOriginOpenerPolicy = value
+	}
+}
+
+// SecureCrossOriginEmbedderPolicy is an option that sets Cross-Origin-Embedder-Policy header,
+// such as "require-corp".
+func SecureCrossOriginEmbedderPolicy(value string) SecureOption {
+	return func(s *secure) {
+		s.crossOriginEmbedderPolicy = value
+	}
+}
+
+// SecureCrossOriginResourcePolicy is an option that sets Cross-Origin-Resource-Policy header,
+// such as "same-origin".
+func SecureCrossOriginResourcePolicy(value string) SecureOption {
+	return func(s *secure) {
+		s.crossOriginResourcePolicy = value
+	}
+}
+
+// SecureSkipper is an option that sets skipper.
+func SecureSkipper(skipper Skipper) SecureOption {
+	return func(s *secure) {
+		s.skipper = skipper
+	}
+}
+
+// Secure returns a middleware that sets security headers, by default it sends
+// the following headers:
+//
+//	X-Frame-Options: SAMEORIGIN
+//	X-Content-Type-Options: nosniff
+//	Referrer-Policy: strict-origin-when-cross-origin
+func Secure(opts ...SecureOption) MiddlewareFunc {
+	s := &secure{
+		frameOptions:       "SAMEORIGIN",
+		contentTypeNosniff: true,
+		referrerPolicy:     "strict-origin-when-cross-origin",
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.hsts.MaxAge > 0 {
+		s.hstsValue = s.hsts.String()
+	}
+	if s.csp != nil && !s.csp.Nonce {
+		s.cspValue = s.csp.build("")
+	}
+	return s.middleware
+}
+
+type secure struct {
+	hsts                      HSTS
+	hstsValue                 string
+	csp                       *ContentSecurityPolicy
+	cspValue                  string
+	frameOptions              string
+	contentTypeNosniff        bool
+	referrerPolicy            string
+	permissionsPolicy         string
+	crossOriginOpenerPolicy   string
+	crossOriginEmbedderPolicy string
+	crossOriginResourcePolicy string
+	skipper                   Skipper
+}
+
+func (s *secure) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if s.skipper != nil && s.skipper(c) {
+			return next(c)
+		}
+
+		header := c.Response.Header()
+		if s.hstsValue != "" && c.IsTLS() {
+			header.Set(headerStrictTransportSecurity, s.hstsValue)
+		}
+		if s.csp != nil {
+			value := s.cspValue
+			if s.csp.Nonce {
+				nonce := newCSPNonce()
+				c.WithValue(cspNonceKey{}, nonce)
+				value = s.csp.build(nonce)
+			}
+			header.Set(s.csp.header(), value)
+		}
+		setHeaderIfNotEmpty(header, headerXFrameOptions, s.frameOptions)
+		if s.contentTypeNosniff {
+			header.Set(headerXContentTypeOptions, "nosniff")
+		}
+		setHeaderIfNotEmpty(header, headerReferrerPolicy, s.referrerPolicy)
+		setHeaderIfNotEmpty(header, headerPermissionsPolicy, s.permissionsPolicy)
+		setHeaderIfNotEmpty(header, headerCrossOriginOpenerPolicy, s.crossOriginOpenerPolicy)
+		setHeaderIfNotEmpty(header, headerCrossOriginEmbedderPolicy, s.crossOriginEmbedderPolicy)
+		setHeaderIfNotEmpty(header, headerCrossOriginResourcePolicy, s.crossOriginResourcePolicy)
+
+		return next(c)
+	}
+}
+
+func setHeaderIfNotEmpty(header http.Header, key, value string) {
+	if value != "" {
+		header.Set(key, value)
+	}
+}
+
+type cspNonceKey struct{}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
@@ -0,0 +1,224 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Cookie errors.
+var (
+	ErrInvalidCookie   = errors.New("invalid cookie value")
+	ErrCookieExpired   = errors.New("cookie expired")
+	ErrCookieKeyNotSet = errors.New("cookie key not set")
+)
+
+// SetCookieHashKeys sets the HMAC-SHA256 keys of signed cookies, the first key is
+// used for signing, and all keys are tried in order for verifying, which allows
+// key rotation.
+func (app *Application) SetCookieHashKeys(keys ...[]byte) {
+	app.cookieHashKeys = keys
+}
+
+// SetCookieBlockKeys sets the AES-GCM keys of encrypted cookies, each key must be
+// 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256. The first key is
+// used for encryption, and all keys are tried in order for decryption, which allows
+// key rotation.
+func (app *Application) SetCookieBlockKeys(keys ...[]byte) error {
+	for _, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			return err
+		}
+	}
+	app.cookieBlockKeys = keys
+	return nil
+}
+
+// SetSignedCookie sets a cookie whose value is timestamped and signed by the hash
+// keys of application, ErrCookieKeyNotSet will be returned if no key was set.
+func (c *Context) SetSignedCookie(cookie *http.Cookie) error {
+	if c.app == nil || len(c.app.cookieHashKeys) == 0 {
+		return ErrCookieKeyNotSet
+	}
+	return c.setSecureCookie(cookieCodec{hashKeys: c.app.cookieHashKeys}, cookie)
+}
+
+// SignedCookie returns the verified value of the signed cookie, http.ErrNoCookie will
+// be returned if the cookie is absent, ErrInvalidCookie will be returned if the
+// signature is invalid, and ErrCookieExpired will be returned if max age is greater
+// than zero and the value was signed earlier than it.
+func (c *Context) SignedCookie(name string, maxAge time.Duration) (string, error) {
+	if c.app == nil || len(c.app.cookieHashKeys) == 0 {
+		return "", ErrCookieKeyNotSet
+	}
+	return c.secureCookie(cookieCodec{hashKeys: c.app.cookieHashKeys}, name, maxAge)
+}
+
+// SetEncryptedCookie sets a cookie whose value is timestamped and encrypted by the
+// block keys of application, ErrCookieKeyNotSet will be returned if no key was set.
+func (c *Context) SetEncryptedCookie(cookie *http.Cookie) error {
+	if c.app == nil || len(c.app.cookieBlockKeys) == 0 {
+		return ErrCookieKeyNotSet
+	}
+	return c.setSecureCookie(cookieCodec{blockKeys: c.app.cookieBlockKeys}, cookie)
+}
+
+// EncryptedCookie returns the decrypted value of the encrypted cookie, the errors are
+// the same as SignedCookie.
+func (c *Context) EncryptedCookie(name string, maxAge time.Duration) (string, error) {
+	if c.app == nil || len(c.app.cookieBlockKeys) == 0 {
+		return "", ErrCookieKeyNotSet
+	}
+	return c.secureCookie(cookieCodec{blockKeys: c.app.cookieBlockKeys}, name, maxAge)
+}
+
+func (c *Context) setSecureCookie(cc cookieCodec, cookie *http.Cookie) error {
+	value, err := cc.encode(cookie.Name, []byte(cookie.Value), time.Now())
+	if err != nil {
+		return err
+	}
+	encoded := *cookie
+	encoded.Value = value
+	c.SetCookie(&encoded)
+	return nil
+}
+
+func (c *Context) secureCookie(cc cookieCodec, name string, maxAge time.Duration) (string, error) {
+	cookie, err := c.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	value, err := cc.decode(name, cookie.Value, maxAge, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// cookieCodec encodes and decodes cookie values that are timestamped, encrypted by
+// AES-GCM if block keys are present, and signed by HMAC-SHA256 if hash keys are present.
+// The first key is used for encoding, and all keys are tried in order for decoding,
+// which allows key rotation.
+type cookieCodec struct {
+	hashKeys  [][]byte
+	blockKeys [][]byte
+}
+
+func checkBlockKeys(keys [][]byte) {
+	for _, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			panic("clevergo: invalid block key: " + err.Error())
+		}
+	}
+}
+
+func (cc cookieCodec) encode(name string, value []byte, now time.Time) (string, error) {
+	payload := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(now.Unix()))
+	payload = append(payload, value...)
+
+	if len(cc.blockKeys) > 0 {
+		aead, err := newAEAD(cc.blockKeys[0])
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+		if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+			return "", err
+		}
+		payload = aead.Seal(nonce, nonce, payload, []byte(name))
+	}
+
+	if len(cc.hashKeys) > 0 {
+		payload = append(payload, cookieMAC(cc.hashKeys[0], name, payload)...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// decode decodes the value, ErrCookieExpired will be returned if max age is
+// greater than zero and the value is older than it.
+func (cc cookieCodec) decode(name, value string, maxAge time.Duration, now time.Time) ([]byte, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+
+	if len(cc.hashKeys) > 0 {
+		if len(payload) < sha256.Size {
+			return nil, ErrInvalidCookie
+		}
+		mac := payload[len(payload)-sha256.Size:]
+		payload = payload[:len(payload)-sha256.Size]
+		valid := false
+		for _, key := range cc.hashKeys {
+			if hmac.Equal(mac, cookieMAC(key, name, payload)) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, ErrInvalidCookie
+		}
+	}
+
+	if len(cc.blockKeys) > 0 {
+		if payload, err = cc.decrypt(name, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(payload) < 8 {
+		return nil, ErrInvalidCookie
+	}
+	timestamp := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if maxAge > 0 && now.Sub(timestamp) > maxAge {
+		return nil, ErrCookieExpired
+	}
+	return payload[8:], nil
+}
+
+func (cc cookieCodec) decrypt(name string, ciphertext []byte) ([]byte, error) {
+	for _, key := range cc.blockKeys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < aead.NonceSize() {
+			return nil, ErrInvalidCookie
+		}
+		nonce := ciphertext[:aead.NonceSize()]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext[aead.NonceSize():], []byte(name)); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrInvalidCookie
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func cookieMAC(key []byte, name string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.Quote(name)))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
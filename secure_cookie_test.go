@@ -0,0 +1,176 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testHashKey  = []byte("hash-key")
+	testBlockKey = []byte("0123456789abcdef")
+)
+
+func TestCookieCodec(t *testing.T) {
+	now := time.Now()
+	codecs := []cookieCodec{
+		{hashKeys: [][]byte{testHashKey}},
+		{blockKeys: [][]byte{testBlockKey}},
+		{hashKeys: [][]byte{testHashKey}, blockKeys: [][]byte{testBlockKey}},
+	}
+	for _, cc := range codecs {
+		// long enough that the random encoded value never contains it by chance.
+		plaintext := "clevergo-secret-value"
+		value, err := cc.encode("foo", []byte(plaintext), now)
+		assert.Nil(t, err)
+		assert.NotContains(t, value, plaintext)
+
+		data, err := cc.decode("foo", value, time.Minute, now.Add(time.Second))
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, string(data))
+
+		_, err = cc.decode("foo", value, time.Minute, now.Add(time.Hour))
+		assert.Equal(t, ErrCookieExpired, err)
+
+		// max age is disabled.
+		_, err = cc.decode("foo", value, 0, now.Add(time.Hour))
+		assert.Nil(t, err)
+
+		// value is bound to cookie name.
+		_, err = cc.decode("bar", value, 0, now)
+		assert.Equal(t, ErrInvalidCookie, err)
+
+		tampered := []byte(value)
+		tampered[len(tampered)/2] ^= 'A' ^ 'B'
+		_, err = cc.decode("foo", string(tampered), 0, now)
+		assert.Equal(t, ErrInvalidCookie, err)
+		_, err = cc.decode("foo", "!", 0, now)
+		assert.Equal(t, ErrInvalidCookie, err)
+		_, err = cc.decode("foo", "", 0, now)
+		assert.Equal(t, ErrInvalidCookie, err)
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	now := time.Now()
+	old := cookieCodec{hashKeys: [][]byte{[]byte("old")}, blockKeys: [][]byte{[]byte("0123456789ABCDEF")}}
+	value, err := old.encode("foo", []byte("bar"), now)
+	assert.Nil(t, err)
+
+	cc := cookieCodec{hashKeys: [][]byte{testHashKey}, blockKeys: [][]byte{testBlockKey}}
+	_, err = cc.decode("foo", value, 0, now)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	cc.hashKeys = append(cc.hashKeys, old.hashKeys...)
+	_, err = cc.decode("foo", value, 0, now)
+	assert.Equal(t, ErrInvalidCookie, err)
+
+	cc.blockKeys = append(cc.blockKeys, old.blockKeys...)
+	data, err := cc.decode("foo", value, 0, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", string(data))
+}
+
+func TestCheckBlockKeys(t *testing.T) {
+	assert.NotPanics(t, func() {
+		checkBlockKeys([][]byte{make([]byte, 16), make([]byte, 24), make([]byte, 32)})
+	})
+	assert.Panics(t, func() {
+		checkBlockKeys([][]byte{[]byte("short")})
+	})
+}
+
+func TestApplicationSetCookieBlockKeys(t *testing.T) {
+	app := Pure()
+	assert.NotNil(t, app.SetCookieBlockKeys(testBlockKey, []byte("short")))
+	assert.Nil(t, app.cookieBlockKeys)
+	assert.Nil(t, app.SetCookieBlockKeys(testBlockKey))
+	assert.Equal(t, [][]byte{testBlockKey}, app.cookieBlockKeys)
+}
+
+func TestContextSecureCookies(t *testing.T) {
+	type setFunc func(*Context, *http.Cookie) error
+	type getFunc func(*Context, string, time.Duration) (string, error)
+	cases := []struct {
+		set setFunc
+		get getFunc
+	}{
+		{(*Context).SetSignedCookie, (*Context).SignedCookie},
+		{(*Context).SetEncryptedCookie, (*Context).EncryptedCookie},
+	}
+	for _, test := range cases {
+		app := Pure()
+
+		// keys are not set.
+		c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, ErrCookieKeyNotSet, test.set(c, &http.Cookie{Name: "foo", Value: "bar"}))
+		_, err := test.get(c, "foo", 0)
+		assert.Equal(t, ErrCookieKeyNotSet, err)
+		c.app = app
+		assert.Equal(t, ErrCookieKeyNotSet, test.set(c, &http.Cookie{Name: "foo", Value: "bar"}))
+		_, err = test.get(c, "foo", 0)
+		assert.Equal(t, ErrCookieKeyNotSet, err)
+
+		oldHashKey, oldBlockKey := []byte("old"), []byte("0123456789ABCDEF")
+		app.SetCookieHashKeys(oldHashKey)
+		assert.Nil(t, app.SetCookieBlockKeys(oldBlockKey))
+
+		w := httptest.NewRecorder()
+		c = newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		c.app = app
+		cookie := &http.Cookie{Name: "foo", Value: "bar", Path: "/", HttpOnly: true}
+		assert.Nil(t, test.set(c, cookie))
+		assert.Equal(t, "bar", cookie.Value)
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.NotEqual(t, "bar", cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(cookies[0])
+		req.AddCookie(&http.Cookie{Name: "invalid", Value: "bar"})
+		c = newContext(nil, req)
+		c.app = app
+		value, err := test.get(c, "foo", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, "bar", value)
+		_, err = test.get(c, "invalid", 0)
+		assert.Equal(t, ErrInvalidCookie, err)
+		_, err = test.get(c, "missing", 0)
+		assert.Equal(t, http.ErrNoCookie, err)
+
+		// rotates keys.
+		app.SetCookieHashKeys(testHashKey, oldHashKey)
+		assert.Nil(t, app.SetCookieBlockKeys(testBlockKey, oldBlockKey))
+		value, err = test.get(c, "foo", time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, "bar", value)
+		app.SetCookieHashKeys(testHashKey)
+		assert.Nil(t, app.SetCookieBlockKeys(testBlockKey))
+		_, err = test.get(c, "foo", time.Minute)
+		assert.Equal(t, ErrInvalidCookie, err)
+	}
+}
+
+func TestContextSecureCookieMaxAge(t *testing.T) {
+	app := Pure()
+	app.SetCookieHashKeys(testHashKey)
+	value, err := cookieCodec{hashKeys: app.cookieHashKeys}.encode("foo", []byte("bar"), time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "foo", Value: value})
+	c := newContext(nil, req)
+	c.app = app
+	_, err = c.SignedCookie("foo", time.Minute)
+	assert.Equal(t, ErrCookieExpired, err)
+	v, err := c.SignedCookie("foo", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar", v)
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHSTSString(t *testing.T) {
+	cases := []struct {
+		hsts     HSTS
+		expected string
+	}{
+		{HSTS{MaxAge: time.Hour}, "max-age=3600"},
+		{HSTS{MaxAge: time.Hour, IncludeSubDomains: true}, "max-age=3600; includeSubDomains"},
+		{HSTS{MaxAge: time.Hour, IncludeSubDomains: true, Preload: true}, "max-age=3600; includeSubDomains; preload"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, test.hsts.String())
+	}
+}
+
+func TestContentSecurityPolicyBuild(t *testing.T) {
+	csp := ContentSecurityPolicy{
+		Directives: map[string][]string{
+			"default-src":               {"'self'"},
+			"script-src":                {"'self'", "cdn.example.com"},
+			"style-src":                 {"'self'"},
+			"upgrade-insecure-requests": nil,
+		},
+	}
+	assert.Equal(t, "default-src 'self'; script-src 'self' cdn.example.com; style-src 'self'; upgrade-insecure-requests", csp.build(""))
+	assert.Equal(t, "default-src 'self'; script-src 'self' cdn.example.com 'nonce-abc'; style-src 'self' 'nonce-abc'; upgrade-insecure-requests", csp.build("abc"))
+	// directives should not be modified.
+	assert.Equal(t, []string{"'self'", "cdn.example.com"}, csp.Directives["script-src"])
+
+	// the nonce is appended to default-src if either script-src or style-src is absent.
+	csp.Directives = map[string][]string{"default-src": {"'self'"}}
+	assert.Equal(t, "default-src 'self' 'nonce-abc'", csp.build("abc"))
+	csp.Directives["script-src"] = []string{"'self'"}
+	assert.Equal(t, "default-src 'self' 'nonce-abc'; script-src 'self' 'nonce-abc'", csp.build("abc"))
+	assert.Equal(t, []string{"'self'"}, csp.Directives["default-src"])
+
+	assert.Equal(t, "Content-Security-Policy", csp.header())
+	csp.ReportOnly = true
+	assert.Equal(t, "Content-Security-Policy-Report-Only", csp.header())
+}
+
+func TestSecureDefaults(t *testing.T) {
+	w := httptest.NewRecorder()
+	c := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Nil(t, Secure()(echoHandler("foo"))(c))
+	assert.Equal(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", w.Header().Get("Referrer-Policy"))
+	for _, header := range []string{
+		"Strict-Transport-Security", "Content-Security-Policy", "Permissions-Policy",
+		"Cross-Origin-Opener-Policy", "Cross-Origin-Embedder-Policy", "Cross-Origin-Resource-Policy",
+	} {
+		assert.Equal(t, "", w.Header().Get(header), header)
+	}
+}
+
+func TestSecure(t *testing.T) {
+	m := Secure(
+		SecureHSTS(HSTS{MaxAge: time.Hour, IncludeSubDomains: true}),
+		SecureCSP(ContentSecurityPolicy{Directives: map[string][]string{"default-src": {"'self'"}}}),
+		SecureFrameOptions("DENY"),
+		SecureContentTypeNosniff(false),
+		SecureReferrerPolicy(""),
+		SecurePermissionsPolicy("camera=()"),
+		SecureCrossOriginOpenerPolicy("same-origin"),
+		SecureCrossOriginEmbedderPolicy("require-corp"),
+		SecureCrossOriginResourcePolicy("same-site"),
+		SecureSkipper(PathSkipper("/skip")),
+	)
+	cases := []struct {
+		path string
+		tls  bool
+	}{
+		{"/", false},
+		{"/", true},
+		{"/skip", true},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		c := newContext(w, req)
+		assert.Nil(t, m(echoHandler("foo"))(c))
+		header := w.Header()
+		if test.path == "/skip" {
+			assert.Equal(t, "", header.Get("X-Frame-Options"))
+			continue
+		}
+		if test.tls {
+			assert.Equal(t, "max-age=3600; includeSubDomains", header.Get("Strict-Transport-Security"))
+		} else {
+			assert.Equal(t, "", header.Get("Strict-Transport-Security"))
+		}
+		assert.Equal(t, "default-src 'self'", header.Get("Content-Security-Policy"))
+		assert.Equal(t, "DENY", header.Get("X-Frame-Options"))
+		assert.Equal(t, "", header.Get("X-Content-Type-Options"))
+		assert.Equal(t, "", header.Get("Referrer-Policy"))
+		assert.Equal(t, "camera=()", header.Get("Permissions-Policy"))
+		assert.Equal(t, "same-origin", header.Get("Cross-Origin-Opener-Policy"))
+		assert.Equal(t, "require-corp", header.Get("Cross-Origin-Embedder-Policy"))
+		assert.Equal(t, "same-site", header.Get("Cross-Origin-Resource-Policy"))
+		assert.Equal(t, "", c.CSPNonce())
+	}
+}
+
+type nonceRenderer struct{}
+
+func (r *nonceRenderer) Render(w io.Writer, name string, data interface{}, c *Context) error {
+	_, err := io.WriteString(w, `<script nonce="`+c.CSPNonce()+`"></script>`)
+	return err
+}
+
+func TestSecureCSPNonce(t *testing.T) {
+	app := Pure()
+	app.Renderer = &nonceRenderer{}
+	app.Use(Secure(SecureCSP(ContentSecurityPolicy{
+		Directives: map[string][]string{"script-src": {"'self'"}},
+		Nonce:      true,
+		ReportOnly: true,
+	})))
+	app.Get("/", func(c *Context) error {
+		return c.Render(http.StatusOK, "index", nil)
+	})
+
+	nonces := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, "", w.Header().Get("Content-Security-Policy"))
+		policy := w.Header().Get("Content-Security-Policy-Report-Only")
+		assert.True(t, strings.HasPrefix(policy, "script-src 'self' 'nonce-"))
+		nonce := strings.TrimSuffix(strings.TrimPrefix(policy, "script-src 'self' 'nonce-"), "'")
+		assert.Len(t, nonce, 24)
+		assert.Equal(t, `<script nonce="`+nonce+`"></script>`, w.Body.String())
+		assert.False(t, nonces[nonce])
+		nonces[nonce] = true
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Session errors.
+var (
+	ErrSessionNotConfigured = errors.New("session middleware not configured")
+)
+
+const (
+	defaultSessionCookieName = "session"
+	defaultSessionMaxAge     = 24 * time.Hour
+)
+
+// SessionStore is an interface that persists session data.
+type SessionStore interface {
+	// Load returns the data of the given session ID, it returns nil data without
+	// error if the session does not exist or has expired.
+	Load(id string) ([]byte, error)
+
+	// Save saves the data that expires after max age, and returns the session ID
+	// that will be sent to client. A new ID should be generated if the given ID
+	// is empty.
+	Save(id string, data []byte, maxAge time.Duration) (string, error)
+
+	// Delete deletes the session of the given ID.
+	Delete(id string) error
+}
+
+// Flash is a message that is stored in session and will be removed once it was read,
+// such as a notification after redirecting.
+type Flash struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Session contains the values of a client, values are JSON-encoded when saving,
+// so that the type of values may be changed after loading, such as numbers turn
+// into float64.
+type Session struct {
+	id        string
+	isNew     bool
+	values    map[string]interface{}
+	flashes   []Flash
+	modified  bool
+	rotated   bool
+	destroyed bool
+}
+
+type sessionData struct {
+	Values  map[string]interface{} `json:"values,omitempty"`
+	Flashes []Flash                `json:"flashes,omitempty"`
+	Expires int64                  `json:"expires"`
+}
+
+func newSession() *Session {
+	return &Session{
+		isNew:  true,
+		values: make(map[string]interface{}),
+	}
+}
+
+// ID returns the session ID, it is empty if the session has not been saved yet.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether the session is newly created.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get returns the value of the given key, nil if not found.
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+// Set sets the value of the given key.
+func (s *Session) Set(key string, value interface{}) {
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete deletes the value of the given key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Clear deletes all values.
+func (s *Session) Clear() {
+	if len(s.values) > 0 {
+		s.values = make(map[string]interface{})
+		s.modified = true
+	}
+}
+
+// AddFlash adds a flash message with the given type, such as "success" and "error".
+func (s *Session) AddFlash(typ, message string) {
+	s.flashes = append(s.flashes, Flash{Type: typ, Message: message})
+	s.modified = true
+}
+
+// Flashes returns and removes the flash messages.
+func (s *Session) Flashes() []Flash {
+	flashes := s.flashes
+	if len(flashes) > 0 {
+		s.flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// Rotate renews the session ID and keeps the values, the old session will be deleted.
+// It should be called on privilege change, such as logging in and out, to prevent
+// session fixation.
+func (s *Session) Rotate() {
+	s.rotated = true
+	s.modified = true
+}
+
+// Destroy deletes the session and expires the cookie.
+func (s *Session) Destroy() {
+	s.destroyed = true
+}
+
+type sessionKey struct{}
+
+// Session returns the session of the request, the session is loaded lazily on
+// first access. ErrSessionNotConfigured will be returned if the Sessions middleware
+// is absent.
+func (c *Context) Session() (*Session, error) {
+	state, ok := c.Value(sessionKey{}).(*sessionState)
+	if !ok {
+		return nil, ErrSessionNotConfigured
+	}
+	return state.load()
+}
+
+// AddFlash adds a flash message to the session, see Session.AddFlash.
+func (c *Context) AddFlash(typ, message string) error {
+	s, err := c.Session()
+	if err != nil {
+		return err
+	}
+	s.AddFlash(typ, message)
+	return nil
+}
+
+// Flashes returns and removes the flash messages of the session, it returns nil
+// if the session is unavailable. It is intended to be used by Renderer for
+// displaying messages.
+func (c *Context) Flashes() []Flash {
+	s, err := c.Session()
+	if err != nil {
+		return nil
+	}
+	return s.Flashes()
+}
+
+// SessionOption is a function that receives a session manager.
+type SessionOption func(*sessionManager)
+
+// SessionCookieName is an option that sets the cookie name, defaults to "session".
+func SessionCookieName(name string) SessionOption {
+	return func(m *sessionManager) {
+		m.cookieName = name
+	}
+}
+
+// SessionCookiePath is an option that sets the cookie path, defaults to "/".
+func SessionCookiePath(path string) SessionOption {
+	return func(m *sessionManager) {
+		m.cookiePath = path
+	}
+}
+
+// SessionCookieDomain is an option that sets the cookie domain.
+func SessionCookieDomain(domain string) SessionOption {
+	return func(m *sessionManager) {
+		m.cookieDomain = domain
+	}
+}
+
+// SessionCookieSecure is an option that indicates whether the cookie should be
+// sent over HTTPS only.
+func SessionCookieSecure(secure bool) SessionOption {
+	return func(m *sessionManager) {
+		m.cookieSecure = secure
+	}
+}
+
+// SessionCookieSameSite is an option that sets the SameSite attribute of cookie,
+// defaults to http.SameSiteLaxMode.
+func SessionCookieSameSite(sameSite http.SameSite) SessionOption {
+	return func(m *sessionManager) {
+		m.cookieSameSite = sameSite
+	}
+}
+
+// SessionMaxAge is an option that sets the lifetime of sessions, defaults to 24 hours.
+func SessionMaxAge(maxAge time.Duration) SessionOption {
+	return func(m *sessionManager) {
+		m.maxAge = maxAge
+	}
+}
+
+// SessionSkipper is an option that sets skipper.
+func SessionSkipper(skipper Skipper) SessionOption {
+	return func(m *sessionManager) {
+		m.skipper = skipper
+	}
+}
+
+// Sessions returns a session middleware with the given store, see Context.Session.
+//
+// Sessions are loaded lazily on first access, and saved before writing the response
+// header if they were modified.
+func Sessions(store SessionStore, opts ...SessionOption) MiddlewareFunc {
+	m := &sessionManager{
+		store:          store,
+		cookieName:     defaultSessionCookieName,
+		cookiePath:     "/",
+		cookieSameSite: http.SameSiteLaxMode,
+		maxAge:         defaultSessionMaxAge,
+		now:            time.Now,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m.middleware
+}
+
+type sessionManager struct {
+	store          SessionStore
+	cookieName     string
+	cookiePath     string
+	cookieDomain   string
+	cookieSecure   bool
+	cookieSameSite http.SameSite
+	maxAge         time.Duration
+	skipper        Skipper
+	now            func() time.Time
+}
+
+func (m *sessionManager) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if m.skipper != nil && m.skipper(c) {
+			return next(c)
+		}
+
+		state := &sessionState{manager: m, c: c}
+		c.WithValue(sessionKey{}, state)
+
+		resp := newResponseWriter(c.Response)
+		resp.beforeWriteHeader = state.save
+		defer func(w http.ResponseWriter) {
+			c.Response = w
+		}(c.Response)
+		c.Response = resp
+
+		err := next(c)
+		if !resp.wroteHeader {
+			state.save()
+		}
+		if err == nil {
+			err = state.saveErr
+		}
+		return err
+	}
+}
+
+func (m *sessionManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     m.cookiePath,
+		Domain:   m.cookieDomain,
+		MaxAge:   maxAge,
+		Secure:   m.cookieSecure,
+		HttpOnly: true,
+		SameSite: m.cookieSameSite,
+	}
+}
+
+// sessionState holds the session of a request.
+type sessionState struct {
+	manager *sessionManager
+	c       *Context
+	session *Session
+	loadErr error
+	saved   bool
+	saveErr error
+}
+
+func (s *sessionState) load() (*Session, error) {
+	if s.session != nil || s.loadErr != nil {
+		return s.session, s.loadErr
+	}
+
+	session := newSession()
+	if cookie, err := s.c.Cookie(s.manager.cookieName); err == nil && cookie.Value != "" {
+		data, err := s.manager.store.Load(cookie.Value)
+		if err != nil {
+			s.loadErr = err
+			return nil, err
+		}
+		var sd sessionData
+		if data != nil && json.Unmarshal(data, &sd) == nil && s.manager.now().Unix() < sd.Expires {
+			session.id = cookie.Value
+			session.isNew = false
+			if sd.Values != nil {
+				session.values = sd.Values
+			}
+			session.flashes = sd.Flashes
+		}
+	}
+	s.session = session
+	return session, nil
+}
+
+func (s *sessionState) save() {
+	if s.saved || s.session == nil {
+		return
+	}
+	s.saved = true
+	s.saveErr = s.doSave()
+}
+
+func (s *sessionState) doSave() error {
+	m := s.manager
+	session := s.session
+	if session.destroyed {
+		if session.id != "" {
+			if err := m.store.Delete(session.id); err != nil {
+				return err
+			}
+		}
+		s.c.SetCookie(m.cookie("", -1))
+		return nil
+	}
+
+	if !session.modified {
+		return nil
+	}
+	if session.rotated && session.id != "" {
+		if err := m.store.Delete(session.id); err != nil {
+			return err
+		}
+		session.id = ""
+	}
+
+	data, err := json.Marshal(sessionData{
+		Values:  session.values,
+		Flashes: session.flashes,
+		Expires: m.now().Add(m.maxAge).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	id, err := m.store.Save(session.id, data, m.maxAge)
+	if err != nil {
+		return err
+	}
+	session.id = id
+	s.c.SetCookie(m.cookie(id, int(m.maxAge/time.Second)))
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
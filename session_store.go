@@ -0,0 +1,294 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxCookieSize = 4096
+
+var errSessionTooLarge = errors.New("session data exceeds the cookie size limit")
+
+// CookieSessionStore is a session store that stores session data in cookie, the
+// data is signed by HMAC-SHA256, and encrypted by AES-GCM only if block keys are
+// given, otherwise it is readable, but not modifiable, by clients.
+type CookieSessionStore struct {
+	codec cookieCodec
+}
+
+// NewCookieSessionStore returns a cookie session store with the given hash keys
+// and block keys, at least one hash key is required, block keys are optional and
+// must be 16, 24 or 32 bytes long. The first key is used for encoding, and all keys are tried in
+// order for decoding, which allows key rotation.
+func NewCookieSessionStore(hashKeys, blockKeys [][]byte) *CookieSessionStore {
+	if len(hashKeys) == 0 {
+		panic("clevergo: at least one hash key is required")
+	}
+	checkBlockKeys(blockKeys)
+	return &CookieSessionStore{
+		codec: cookieCodec{hashKeys: hashKeys, blockKeys: blockKeys},
+	}
+}
+
+// Load implements SessionStore.Load, the session ID is the encoded data.
+func (s *CookieSessionStore) Load(id string) ([]byte, error) {
+	data, err := s.codec.decode(defaultSessionCookieName, id, 0, time.Now())
+	if err != nil {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Save implements SessionStore.Save, it returns the encoded data as session ID.
+func (s *CookieSessionStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	value, err := s.codec.encode(defaultSessionCookieName, data, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if len(value) > maxCookieSize {
+		return "", errSessionTooLarge
+	}
+	return value, nil
+}
+
+// Delete implements SessionStore.Delete, it does nothing since the data is stored
+// in client.
+func (s *CookieSessionStore) Delete(id string) error {
+	return nil
+}
+
+// MemorySessionStore is a session store that stores sessions in memory, expired
+// sessions are evicted on access and periodically on saving.
+type MemorySessionStore struct {
+	mu         sync.Mutex
+	sessions   map[string]memorySession
+	gcInterval time.Duration
+	lastGC     time.Time
+	now        func() time.Time
+}
+
+type memorySession struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore returns a memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions:   make(map[string]memorySession),
+		gcInterval: time.Minute,
+		now:        time.Now,
+	}
+}
+
+// Load implements SessionStore.Load.
+func (s *MemorySessionStore) Load(id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if !s.now().Before(session.expires) {
+		delete(s.sessions, id)
+		return nil, nil
+	}
+	return session.data, nil
+}
+
+// Save implements SessionStore.Save.
+func (s *MemorySessionStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastGC) >= s.gcInterval {
+		s.gc(now)
+	}
+	if id == "" {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return "", err
+		}
+	}
+	s.sessions[id] = memorySession{
+		data:    append([]byte(nil), data...),
+		expires: now.Add(maxAge),
+	}
+	return id, nil
+}
+
+// Delete implements SessionStore.Delete.
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// GC evicts expired sessions.
+func (s *MemorySessionStore) GC() {
+	s.mu.Lock()
+	s.gc(s.now())
+	s.mu.Unlock()
+}
+
+func (s *MemorySessionStore) gc(now time.Time) {
+	s.lastGC = now
+	for id, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+const sessionFilePrefix = "session_"
+
+// FileSessionStore is a session store that stores sessions in files of a directory,
+// expired sessions are removed on access or by GC.
+type FileSessionStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileSessionStore returns a file session store with the given directory, the
+// directory will be created if it does not exist.
+func NewFileSessionStore(dir string) (*FileSessionStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSessionStore{dir: dir, now: time.Now}, nil
+}
+
+func (s *FileSessionStore) filename(id string) string {
+	return filepath.Join(s.dir, sessionFilePrefix+id)
+}
+
+// isValidSessionID reports whether the ID was generated by newSessionID, which
+// prevents from traversing paths.
+func isValidSessionID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Load implements SessionStore.Load.
+func (s *FileSessionStore) Load(id string) ([]byte, error) {
+	if !isValidSessionID(id) {
+		return nil, nil
+	}
+	filename := s.filename(id)
+	data, expired, err := s.read(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if expired {
+		return nil, removeFile(filename)
+	}
+	return data, nil
+}
+
+// read reads the session file, which is composed of the expiration time and the data.
+func (s *FileSessionStore) read(filename string) (data []byte, expired bool, err error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(content) < 8 {
+		return nil, true, nil
+	}
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(content)))
+	return content[8:], !s.now().Before(expires), nil
+}
+
+// Save implements SessionStore.Save.
+func (s *FileSessionStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	if !isValidSessionID(id) {
+		var err error
+		if id, err = newSessionID(); err != nil {
+			return "", err
+		}
+	}
+
+	content := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(content, uint64(s.now().Add(maxAge).UnixNano()))
+	content = append(content, data...)
+
+	// writes to a temporary file and renames it, so that readers never see partial content.
+	f, err := ioutil.TempFile(s.dir, "tmp_")
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err = os.Rename(f.Name(), s.filename(id)); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete implements SessionStore.Delete.
+func (s *FileSessionStore) Delete(id string) error {
+	if !isValidSessionID(id) {
+		return nil
+	}
+	return removeFile(s.filename(id))
+}
+
+// GC removes expired sessions.
+func (s *FileSessionStore) GC() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasPrefix(info.Name(), sessionFilePrefix) {
+			continue
+		}
+		filename := filepath.Join(s.dir, info.Name())
+		_, expired, err := s.read(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if expired {
+			if err = removeFile(filename); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func removeFile(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieSessionStore(t *testing.T) {
+	assert.Panics(t, func() {
+		NewCookieSessionStore(nil, nil)
+	})
+	assert.Panics(t, func() {
+		NewCookieSessionStore([][]byte{testHashKey}, [][]byte{[]byte("short")})
+	})
+
+	store := NewCookieSessionStore([][]byte{testHashKey}, [][]byte{testBlockKey})
+	id, err := store.Save("", []byte("foo"), time.Hour)
+	assert.Nil(t, err)
+	data, err := store.Load(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	data, err = store.Load("invalid")
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	assert.Nil(t, store.Delete(id))
+
+	_, err = store.Save("", []byte(strings.Repeat("a", maxCookieSize)), time.Hour)
+	assert.Equal(t, errSessionTooLarge, err)
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemorySessionStore()
+	store.now = func() time.Time { return now }
+
+	id, err := store.Save("", []byte("foo"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, isValidSessionID(id))
+	data, err := store.Load(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	_, err = store.Save(id, []byte("bar"), time.Minute)
+	assert.Nil(t, err)
+	data, _ = store.Load(id)
+	assert.Equal(t, "bar", string(data))
+
+	data, err = store.Load("unknown")
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	assert.Nil(t, store.Delete(id))
+	data, _ = store.Load(id)
+	assert.Nil(t, data)
+
+	// evicts expired sessions on access.
+	id, _ = store.Save("", []byte("foo"), time.Minute)
+	now = now.Add(time.Minute)
+	data, _ = store.Load(id)
+	assert.Nil(t, data)
+	assert.Len(t, store.sessions, 0)
+
+	// evicts expired sessions periodically.
+	store.Save("expired", []byte("foo"), time.Second)
+	now = now.Add(time.Second)
+	store.Save("foo", []byte("foo"), time.Minute)
+	assert.Len(t, store.sessions, 2)
+	now = now.Add(time.Minute)
+	store.Save("bar", []byte("bar"), time.Minute)
+	assert.Len(t, store.sessions, 1)
+
+	now = now.Add(time.Minute)
+	store.GC()
+	assert.Len(t, store.sessions, 0)
+}
+
+func TestFileSessionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	store, err := NewFileSessionStore(filepath.Join(dir, "sessions"))
+	assert.Nil(t, err)
+	store.now = func() time.Time { return now }
+
+	id, err := store.Save("", []byte("foo"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, isValidSessionID(id))
+	data, err := store.Load(id)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo", string(data))
+
+	newID, err := store.Save(id, []byte("bar"), time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, id, newID)
+	data, _ = store.Load(id)
+	assert.Equal(t, "bar", string(data))
+
+	// invalid IDs.
+	for _, invalid := range []string{"", "../../etc/passwd", strings.Repeat(".", 43)} {
+		data, err = store.Load(invalid)
+		assert.Nil(t, err)
+		assert.Nil(t, data)
+		assert.Nil(t, store.Delete(invalid))
+	}
+	newID, err = store.Save("../foo", []byte("foo"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, isValidSessionID(newID))
+
+	assert.Nil(t, store.Delete(id))
+	assert.Nil(t, store.Delete(id))
+	data, err = store.Load(id)
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	// removes expired session on access.
+	id, _ = store.Save("", []byte("foo"), time.Minute)
+	now = now.Add(time.Minute)
+	data, err = store.Load(id)
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+	_, err = os.Stat(store.filename(id))
+	assert.True(t, os.IsNotExist(err))
+
+	// GC.
+	expired, _ := store.Save("", []byte("foo"), time.Second)
+	alive, _ := store.Save("", []byte("bar"), time.Hour)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(store.dir, "other"), nil, 0600))
+	now = now.Add(time.Minute)
+	assert.Nil(t, store.GC())
+	_, err = os.Stat(store.filename(expired))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(store.filename(alive))
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(store.dir, "other"))
+	assert.Nil(t, err)
+}
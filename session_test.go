@@ -0,0 +1,322 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	s := newSession()
+	assert.True(t, s.IsNew())
+	assert.Equal(t, "", s.ID())
+	assert.Nil(t, s.Get("foo"))
+
+	s.Delete("foo")
+	s.Clear()
+	assert.Nil(t, s.Flashes())
+	assert.False(t, s.modified)
+
+	s.Set("foo", "bar")
+	assert.Equal(t, "bar", s.Get("foo"))
+	assert.True(t, s.modified)
+
+	s.modified = false
+	s.Delete("foo")
+	assert.Nil(t, s.Get("foo"))
+	assert.True(t, s.modified)
+
+	s.modified = false
+	s.Set("foo", "bar")
+	s.Clear()
+	assert.Nil(t, s.Get("foo"))
+
+	s.modified = false
+	s.AddFlash("success", "saved")
+	assert.True(t, s.modified)
+	s.modified = false
+	assert.Equal(t, []Flash{{"success", "saved"}}, s.Flashes())
+	assert.True(t, s.modified)
+	assert.Nil(t, s.Flashes())
+
+	s.modified = false
+	s.Rotate()
+	assert.True(t, s.rotated)
+	assert.True(t, s.modified)
+	s.Destroy()
+	assert.True(t, s.destroyed)
+}
+
+func TestContextSessionNotConfigured(t *testing.T) {
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	_, err := c.Session()
+	assert.Equal(t, ErrSessionNotConfigured, err)
+	assert.Equal(t, ErrSessionNotConfigured, c.AddFlash("success", "saved"))
+	assert.Nil(t, c.Flashes())
+}
+
+type sessionClient struct {
+	t      *testing.T
+	app    *Application
+	cookie *http.Cookie
+}
+
+func (sc *sessionClient) do(method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if sc.cookie != nil {
+		req.AddCookie(sc.cookie)
+	}
+	sc.app.ServeHTTP(w, req)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			sc.cookie = nil
+		} else {
+			sc.cookie = cookie
+		}
+	}
+	return w
+}
+
+func newSessionTestApp(store SessionStore, opts ...SessionOption) *Application {
+	app := New()
+	app.Use(Sessions(store, opts...))
+	app.Get("/", func(c *Context) error {
+		s, err := c.Session()
+		if err != nil {
+			return err
+		}
+		user, _ := s.Get("user").(string)
+		return c.String(http.StatusOK, user)
+	})
+	app.Get("/login", func(c *Context) error {
+		s, err := c.Session()
+		if err != nil {
+			return err
+		}
+		s.Rotate()
+		s.Set("user", c.QueryParam("user"))
+		if err = c.AddFlash("success", "logged in"); err != nil {
+			return err
+		}
+		return c.Redirect(http.StatusFound, "/")
+	})
+	app.Get("/flashes", func(c *Context) error {
+		flashes := c.Flashes()
+		if len(flashes) == 0 {
+			return c.String(http.StatusOK, "")
+		}
+		return c.String(http.StatusOK, flashes[0].Message)
+	})
+	app.Get("/logout", func(c *Context) error {
+		s, err := c.Session()
+		if err != nil {
+			return err
+		}
+		s.Destroy()
+		return nil
+	})
+	app.Get("/error", func(c *Context) error {
+		s, err := c.Session()
+		if err != nil {
+			return err
+		}
+		s.Set("foo", "bar")
+		return errors.New("error")
+	})
+	return app
+}
+
+func TestSessions(t *testing.T) {
+	stores := map[string]SessionStore{
+		"cookie": NewCookieSessionStore([][]byte{testHashKey}, [][]byte{testBlockKey}),
+		"memory": NewMemorySessionStore(),
+	}
+	for name, store := range stores {
+		sc := &sessionClient{t: t, app: newSessionTestApp(store, SessionCookieName("sid"))}
+
+		// sessions are not saved unless modified.
+		w := sc.do(http.MethodGet, "/")
+		assert.Equal(t, "", w.Body.String(), name)
+		assert.Nil(t, sc.cookie, name)
+
+		w = sc.do(http.MethodGet, "/login?user=foo")
+		assert.Equal(t, http.StatusFound, w.Code, name)
+		assert.NotNil(t, sc.cookie, name)
+		assert.Equal(t, "sid", sc.cookie.Name)
+		assert.True(t, sc.cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, sc.cookie.SameSite)
+		assert.Equal(t, int(defaultSessionMaxAge/time.Second), sc.cookie.MaxAge)
+		first := sc.cookie.Value
+
+		w = sc.do(http.MethodGet, "/")
+		assert.Equal(t, "foo", w.Body.String(), name)
+		assert.Equal(t, first, sc.cookie.Value, name)
+
+		w = sc.do(http.MethodGet, "/flashes")
+		assert.Equal(t, "logged in", w.Body.String(), name)
+		w = sc.do(http.MethodGet, "/flashes")
+		assert.Equal(t, "", w.Body.String(), name)
+
+		// rotates session ID.
+		sc.do(http.MethodGet, "/login?user=bar")
+		assert.NotEqual(t, first, sc.cookie.Value, name)
+		w = sc.do(http.MethodGet, "/")
+		assert.Equal(t, "bar", w.Body.String(), name)
+
+		sc.do(http.MethodGet, "/logout")
+		assert.Nil(t, sc.cookie, name)
+		w = sc.do(http.MethodGet, "/")
+		assert.Equal(t, "", w.Body.String(), name)
+
+		// session is saved even if the handler returns an error.
+		w = sc.do(http.MethodGet, "/error")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.NotNil(t, sc.cookie, name)
+
+		// invalid cookie.
+		sc.cookie.Value = "invalid"
+		w = sc.do(http.MethodGet, "/")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestSessionsOldSessionDeleted(t *testing.T) {
+	store := NewMemorySessionStore()
+	sc := &sessionClient{t: t, app: newSessionTestApp(store)}
+	sc.do(http.MethodGet, "/login?user=foo")
+	first := sc.cookie.Value
+	sc.do(http.MethodGet, "/login?user=bar")
+	data, _ := store.Load(first)
+	assert.Nil(t, data)
+
+	// an attacker replays the old session ID.
+	sc.cookie.Value = first
+	w := sc.do(http.MethodGet, "/")
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestSessionsExpired(t *testing.T) {
+	store := NewCookieSessionStore([][]byte{testHashKey}, nil)
+	now := time.Now()
+	app := New()
+	app.Use(Sessions(store, SessionMaxAge(time.Minute), func(m *sessionManager) {
+		m.now = func() time.Time { return now }
+	}))
+	app.Get("/", func(c *Context) error {
+		s, _ := c.Session()
+		if s.IsNew() {
+			s.Set("foo", "bar")
+		}
+		return c.String(http.StatusOK, s.ID())
+	})
+	sc := &sessionClient{t: t, app: app}
+	sc.do(http.MethodGet, "/")
+	assert.Equal(t, 60, sc.cookie.MaxAge)
+	w := sc.do(http.MethodGet, "/")
+	assert.NotEqual(t, "", w.Body.String())
+
+	now = now.Add(time.Minute)
+	w = sc.do(http.MethodGet, "/")
+	assert.Equal(t, "", w.Body.String())
+}
+
+type errSessionStore struct {
+	err error
+}
+
+func (s errSessionStore) Load(id string) ([]byte, error) {
+	return nil, s.err
+}
+
+func (s errSessionStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	return "", s.err
+}
+
+func (s errSessionStore) Delete(id string) error {
+	return s.err
+}
+
+func TestSessionsStoreError(t *testing.T) {
+	storeErr := errors.New("store error")
+	m := Sessions(errSessionStore{storeErr})
+
+	// load error.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: defaultSessionCookieName, Value: "foo"})
+	c := newContext(httptest.NewRecorder(), req)
+	err := m(func(c *Context) error {
+		_, err := c.Session()
+		assert.Equal(t, storeErr, err)
+		_, err = c.Session()
+		return err
+	})(c)
+	assert.Equal(t, storeErr, err)
+
+	// save error.
+	for _, write := range []bool{false, true} {
+		c = newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		err = m(func(c *Context) error {
+			s, _ := c.Session()
+			s.Set("foo", "bar")
+			if write {
+				c.WriteString("foo")
+			}
+			return nil
+		})(c)
+		assert.Equal(t, storeErr, err)
+	}
+
+	// delete error.
+	for _, destroy := range []bool{false, true} {
+		c = newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		err = m(func(c *Context) error {
+			s, _ := c.Session()
+			s.id = "foo"
+			if destroy {
+				s.Destroy()
+			} else {
+				s.Rotate()
+			}
+			return nil
+		})(c)
+		assert.Equal(t, storeErr, err)
+	}
+}
+
+func TestSessionsCookieOptions(t *testing.T) {
+	m := Sessions(NewMemorySessionStore(),
+		SessionCookiePath("/admin"),
+		SessionCookieDomain("example.com"),
+		SessionCookieSecure(true),
+		SessionCookieSameSite(http.SameSiteStrictMode),
+		SessionSkipper(PathSkipper("/skip")),
+	)
+	w := httptest.NewRecorder()
+	c := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	err := m(func(c *Context) error {
+		return c.AddFlash("success", "foo")
+	})(c)
+	assert.Nil(t, err)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "/admin", cookies[0].Path)
+	assert.Equal(t, "example.com", cookies[0].Domain)
+	assert.True(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookies[0].SameSite)
+
+	c = newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/skip", nil))
+	err = m(func(c *Context) error {
+		_, err := c.Session()
+		return err
+	})(c)
+	assert.Equal(t, ErrSessionNotConfigured, err)
+}
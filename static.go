@@ -0,0 +1,332 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerAcceptEncoding  = "Accept-Encoding"
+	headerCacheControl    = "Cache-Control"
+	headerContentEncoding = "Content-Encoding"
+	headerETag            = "ETag"
+	headerVary            = "Vary"
+
+	cacheControlImmutable = "public, max-age=31536000, immutable"
+	cacheControlNoCache   = "no-cache"
+)
+
+// precompressed encodings in order of preference.
+var staticEncodings = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticOption is a function that receives a static instance.
+type StaticOption func(*Static)
+
+// StaticIndex is an option that sets the index file of directories, defaults
+// to "index.html".
+func StaticIndex(name string) StaticOption {
+	return func(s *Static) {
+		s.index = name
+	}
+}
+
+// StaticBrowse is an option that indicates whether to list the files of directories
+// that have no index file, it is disabled by default.
+func StaticBrowse(browse bool) StaticOption {
+	return func(s *Static) {
+		s.browse = browse
+	}
+}
+
+// StaticPrecompressed is an option that indicates whether to serve the precompressed
+// siblings, such as "app.js.br" and "app.js.gz", if the client accepts the encoding,
+// it is enabled by default.
+func StaticPrecompressed(precompressed bool) StaticOption {
+	return func(s *Static) {
+		s.precompressed = precompressed
+	}
+}
+
+// StaticMaxAge is an option that sets the max age of Cache-Control header of files
+// that are not fingerprinted, by default clients have to revalidate files by ETag.
+// Fingerprinted files are always cached for one year as immutable, only the files
+// fingerprinted by Assets are recognized, see Assets.IsFingerprinted.
+func StaticMaxAge(maxAge time.Duration) StaticOption {
+	return func(s *Static) {
+		s.cacheControl = "public, max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+}
+
+// StaticFallback is an option that sets the file which will be served for the
+// missing paths that have no extension, such as the index.html of single-page
+// applications that use client-side routing.
+func StaticFallback(name string) StaticOption {
+	return func(s *Static) {
+		s.fallback = name
+	}
+}
+
+// Static serves static files from a file system, it can be used as a Handle, such
+// as app.Get("/static/*filepath", s.Handle), or a http.Handler, such as
+// app.Mount("/static", s).
+//
+// Files are served with ETag, so that conditional requests are answered with 304
+// status code.
+type Static struct {
+	root          http.FileSystem
+	fileServer    http.Handler
+	index         string
+	browse        bool
+	precompressed bool
+	cacheControl  string
+	fallback      string
+
+	mu    sync.RWMutex
+	etags map[string]staticETag
+}
+
+type staticETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+// NewStatic returns a static instance with the given root file system.
+func NewStatic(root http.FileSystem, opts ...StaticOption) *Static {
+	s := &Static{
+		root:          root,
+		fileServer:    http.FileServer(root),
+		index:         "index.html",
+		precompressed: true,
+		cacheControl:  cacheControlNoCache,
+		etags:         make(map[string]staticETag),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Handle serves the file of the "filepath" parameter if present, otherwise the
+// file of request path.
+func (s *Static) Handle(c *Context) error {
+	name := c.Params.String("filepath")
+	if name == "" {
+		name = c.Request.URL.Path
+	}
+	return s.serve(c.Response, c.Request, name)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.serve(w, r, r.URL.Path); err != nil {
+		code := http.StatusInternalServerError
+		if e, ok := err.(Error); ok {
+			code = e.Status()
+		}
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func (s *Static) serve(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return ErrMethodNotAllowed
+	}
+
+	name = path.Clean("/" + name)
+	f, info, err := s.open(name)
+	if err != nil {
+		if os.IsNotExist(err) && s.fallback != "" && path.Ext(name) == "" {
+			return s.serveFile(w, r, path.Clean("/"+s.fallback), false)
+		}
+		return toHTTPError(err)
+	}
+	f.Close()
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// redirects to canonical path, so that the relative links work.
+			w.Header().Set("Location", path.Base(r.URL.Path)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return nil
+		}
+		index := path.Join(name, s.index)
+		if _, err := s.stat(index); err == nil {
+			return s.serveFile(w, r, index, false)
+		}
+		if s.browse {
+			req := new(http.Request)
+			*req = *r
+			req.URL = new(url.URL)
+			*req.URL = *r.URL
+			req.URL.Path = name + "/"
+			s.fileServer.ServeHTTP(w, req)
+			return nil
+		}
+		return ErrNotFound
+	}
+
+	return s.serveFile(w, r, name, true)
+}
+
+// serveFile serves the file or its precompressed sibling, cacheable indicates
+// whether the file can be cached as immutable if it is fingerprinted.
+func (s *Static) serveFile(w http.ResponseWriter, r *http.Request, name string, cacheable bool) error {
+	header := w.Header()
+	served := name
+	if s.precompressed {
+		acceptEncoding := r.Header.Get(headerAcceptEncoding)
+		vary := false
+		for _, e := range staticEncodings {
+			if info, err := s.stat(name + e.extension); err == nil && !info.IsDir() {
+				vary = true
+				if acceptsEncoding(acceptEncoding, e.encoding) {
+					served = name + e.extension
+					header.Set(headerContentEncoding, e.encoding)
+					break
+				}
+			}
+		}
+		if vary {
+			header.Add(headerVary, headerAcceptEncoding)
+		}
+	}
+
+	f, info, err := s.open(served)
+	if err != nil {
+		header.Del(headerContentEncoding)
+		return toHTTPError(err)
+	}
+	defer f.Close()
+	if info.IsDir() {
+		header.Del(headerContentEncoding)
+		return ErrNotFound
+	}
+
+	etag, err := s.etag(served, info, f)
+	if err != nil {
+		return err
+	}
+	header.Set(headerETag, etag)
+	if cacheable && s.isFingerprinted(name) {
+		header.Set(headerCacheControl, cacheControlImmutable)
+	} else {
+		header.Set(headerCacheControl, s.cacheControl)
+	}
+
+	// the content type is detected by the extension of the original file.
+	http.ServeContent(w, r, name, info.ModTime(), f)
+	return nil
+}
+
+// isFingerprinted reports whether the file is fingerprinted by the root, if it is
+// an Assets instance.
+func (s *Static) isFingerprinted(name string) bool {
+	assets, ok := s.root.(*Assets)
+	return ok && assets.IsFingerprinted(name)
+}
+
+func (s *Static) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+func (s *Static) stat(name string) (os.FileInfo, error) {
+	f, info, err := s.open(name)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return info, nil
+}
+
+// etag returns the strong ETag of the file, which is computed from content and
+// cached until the file is modified.
+func (s *Static) etag(name string, info os.FileInfo, f http.File) (string, error) {
+	s.mu.RLock()
+	entry, ok := s.etags[name]
+	s.mu.RUnlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	s.mu.Lock()
+	s.etags[name] = staticETag{modTime: info.ModTime(), size: info.Size(), etag: etag}
+	s.mu.Unlock()
+	return etag, nil
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accepts the encoding,
+// the wildcard "*" matches the encodings that are not listed explicitly.
+func acceptsEncoding(header, encoding string) bool {
+	accepted, wildcard := false, false
+	for _, value := range strings.Split(header, ",") {
+		params := strings.Split(value, ";")
+		coding := strings.TrimSpace(params[0])
+		isWildcard := coding == "*"
+		if !isWildcard && !strings.EqualFold(coding, encoding) {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					ok = false
+				}
+			}
+		}
+		if !isWildcard {
+			return ok
+		}
+		accepted, wildcard = ok, true
+	}
+	return wildcard && accepted
+}
+
+func toHTTPError(err error) error {
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if os.IsPermission(err) {
+		return ErrForbidden
+	}
+	return err
+}
@@ -0,0 +1,18 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package clevergo
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+// NewStaticFS returns a static instance with the given fs.FS, such as embed.FS.
+func NewStaticFS(fsys fs.FS, opts ...StaticOption) *Static {
+	return NewStatic(http.FS(fsys), opts...)
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package clevergo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":  {Data: []byte("index")},
+		"app.js":      {Data: []byte("app")},
+		"app.js.gz":   {Data: []byte("app gzip")},
+		"css/app.css": {Data: []byte("css")},
+	}
+	s := NewStaticFS(fsys, StaticFallback("index.html"))
+	cases := []struct {
+		path     string
+		code     int
+		body     string
+		encoding string
+	}{
+		{"/", http.StatusOK, "index", ""},
+		{"/app.js", http.StatusOK, "app gzip", "gzip"},
+		{"/css/", http.StatusNotFound, "Not Found\n", ""},
+		{"/users", http.StatusOK, "index", ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		s.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"), test.path)
+	}
+}
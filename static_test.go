@@ -0,0 +1,209 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStaticTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "static")
+	assert.Nil(t, err)
+	writeTemplateFiles(t, dir, map[string]string{
+		"index.html":           "index",
+		"app.js":               "app",
+		"app.js.gz":            "app gzip",
+		"app.js.br":            "app br",
+		"app.3f9a1c.css":       "css",
+		"docs/index.html":      "docs",
+		"images/logo.svg":      "<svg></svg>",
+		"images/logo.svg.gz":   "svg gzip",
+		"images/icons/foo.png": "png",
+	})
+	return dir
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	cases := []struct {
+		header   string
+		encoding string
+		expected bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"deflate, GZIP;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"gzip; q=0.0, br", "br", true},
+		{"br", "gzip", false},
+		{"*", "gzip", true},
+		{"br, *;q=0", "gzip", false},
+		{"*;q=0, gzip", "gzip", true},
+		{"gzip;q=0, *", "gzip", false},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, acceptsEncoding(test.header, test.encoding), test.header)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	dir := newStaticTestDir(t)
+	defer os.RemoveAll(dir)
+
+	app := Pure()
+	s := NewStatic(http.Dir(dir))
+	app.Get("/static/*filepath", s.Handle)
+	app.Head("/static/*filepath", s.Handle)
+
+	cases := []struct {
+		method         string
+		path           string
+		acceptEncoding string
+		code           int
+		body           string
+		encoding       string
+		cacheControl   string
+		contentType    string
+		location       string
+	}{
+		{http.MethodGet, "/static/app.js", "", http.StatusOK, "app", "", cacheControlNoCache, "text/javascript; charset=utf-8", ""},
+		{http.MethodGet, "/static/app.js", "gzip", http.StatusOK, "app gzip", "gzip", cacheControlNoCache, "text/javascript; charset=utf-8", ""},
+		{http.MethodGet, "/static/app.js", "gzip, br", http.StatusOK, "app br", "br", cacheControlNoCache, "text/javascript; charset=utf-8", ""},
+		{http.MethodGet, "/static/app.js", "gzip, br;q=0", http.StatusOK, "app gzip", "gzip", cacheControlNoCache, "text/javascript; charset=utf-8", ""},
+		{http.MethodGet, "/static/images/logo.svg", "br", http.StatusOK, "<svg></svg>", "", cacheControlNoCache, "image/svg+xml", ""},
+		{http.MethodGet, "/static/app.3f9a1c.css", "", http.StatusOK, "css", "", cacheControlNoCache, "text/css; charset=utf-8", ""},
+		{http.MethodHead, "/static/app.3f9a1c.css", "", http.StatusOK, "", "", cacheControlNoCache, "text/css; charset=utf-8", ""},
+		{http.MethodGet, "/static/", "", http.StatusOK, "index", "", cacheControlNoCache, "text/html; charset=utf-8", ""},
+		{http.MethodGet, "/static/docs/", "", http.StatusOK, "docs", "", cacheControlNoCache, "text/html; charset=utf-8", ""},
+		{http.MethodGet, "/static/docs", "", http.StatusMovedPermanently, "", "", "", "", "docs/"},
+		{http.MethodGet, "/static/images/", "", http.StatusNotFound, "Not Found\n", "", "", "text/plain; charset=utf-8", ""},
+		{http.MethodGet, "/static/missing", "", http.StatusNotFound, "Not Found\n", "", "", "text/plain; charset=utf-8", ""},
+		{http.MethodGet, "/static/../../etc/passwd", "", http.StatusNotFound, "Not Found\n", "", "", "text/plain; charset=utf-8", ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		app.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.encoding, w.Header().Get("Content-Encoding"), test.path)
+		assert.Equal(t, test.cacheControl, w.Header().Get("Cache-Control"), test.path)
+		assert.Equal(t, test.contentType, w.Header().Get("Content-Type"), test.path)
+		assert.Equal(t, test.location, w.Header().Get("Location"), test.path)
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+	assert.Equal(t, []string{"Accept-Encoding"}, w.Header()["Vary"])
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	app.ServeHTTP(w, req)
+	assert.Equal(t, []string{"Accept-Encoding"}, w.Header()["Vary"])
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static/app.3f9a1c.css", nil))
+	assert.Equal(t, "", w.Header().Get("Vary"))
+}
+
+func TestStaticETag(t *testing.T) {
+	dir := newStaticTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s := NewStatic(http.Dir(dir))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// the precompressed variant has a different ETag.
+	req = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// the ETag changes once the file is modified.
+	assert.Nil(t, ioutil.WriteFile(dir+"/app.js", []byte("modified"), 0644))
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(dir+"/app.js", future, future))
+	req = httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "modified", w.Body.String())
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestStaticOptions(t *testing.T) {
+	dir := newStaticTestDir(t)
+	defer os.RemoveAll(dir)
+
+	s := NewStatic(http.Dir(dir),
+		StaticIndex("app.js"),
+		StaticBrowse(true),
+		StaticPrecompressed(false),
+		StaticMaxAge(time.Hour),
+		StaticFallback("index.html"),
+	)
+	cases := []struct {
+		method       string
+		path         string
+		code         int
+		body         string
+		cacheControl string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "app", "public, max-age=3600"},
+		{http.MethodGet, "/users/1", http.StatusOK, "index", "public, max-age=3600"},
+		{http.MethodGet, "/missing.js", http.StatusNotFound, "Not Found\n", ""},
+		{http.MethodGet, "/app.3f9a1c.css", http.StatusOK, "css", "public, max-age=3600"},
+		{http.MethodPost, "/app.js", http.StatusMethodNotAllowed, "Method Not Allowed\n", ""},
+	}
+	for _, test := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		s.ServeHTTP(w, req)
+		assert.Equal(t, test.code, w.Code, test.path)
+		assert.Equal(t, test.body, w.Body.String(), test.path)
+		assert.Equal(t, test.cacheControl, w.Header().Get("Cache-Control"), test.path)
+	}
+
+	// directory listing.
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "logo.svg")
+	assert.Contains(t, w.Body.String(), "icons/")
+}
+
+func TestStaticMount(t *testing.T) {
+	dir := newStaticTestDir(t)
+	defer os.RemoveAll(dir)
+
+	app := Pure()
+	app.Mount("/assets", NewStatic(http.Dir(dir)))
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/assets/images/logo.svg", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<svg></svg>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/assets", nil))
+	assert.Equal(t, "index", w.Body.String())
+}
@@ -0,0 +1,350 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errCSRFTokenNotSet = errors.New("csrf token function not set")
+
+// TemplateOption is a function that receives a template renderer.
+type TemplateOption func(*TemplateRenderer)
+
+// TemplateLayout is an option that sets the default layout, the layout renders the
+// content of pages by blocks, such as {{block "content" .}}{{end}}, and pages override
+// blocks by {{define "content"}}...{{end}}. Pages are rendered without layout if the
+// layout is empty.
+func TemplateLayout(layout string) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.layout = layout
+	}
+}
+
+// TemplatePageLayout is an option that sets the layout of the given page, which
+// overrides the default layout, empty layout disables the layout of the page.
+func TemplatePageLayout(page, layout string) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.pageLayouts[page] = layout
+	}
+}
+
+// TemplatePartials is an option that sets the directories of partials, all templates
+// of the directories are shared by pages, and can be included by their path, such as
+// {{template "partials/nav.html" .}}.
+func TemplatePartials(dirs ...string) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.partials = append(r.partials, dirs...)
+	}
+}
+
+// TemplateFuncs is an option that adds template functions.
+func TemplateFuncs(funcs template.FuncMap) TemplateOption {
+	return func(r *TemplateRenderer) {
+		for name, f := range funcs {
+			r.funcs[name] = f
+		}
+	}
+}
+
+// TemplateDelims is an option that sets the action delimiters.
+func TemplateDelims(left, right string) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.leftDelim = left
+		r.rightDelim = right
+	}
+}
+
+// TemplateCSRFToken is an option that sets the function that returns the CSRF token
+// of request, which is used by the "csrf" template function.
+func TemplateCSRFToken(f func(c *Context) string) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.csrfToken = f
+	}
+}
+
+// TemplateReload is an option that indicates whether to reload templates on file
+// change, it should be enabled in development only.
+func TemplateReload(reload bool) TemplateOption {
+	return func(r *TemplateRenderer) {
+		r.reload = reload
+	}
+}
+
+// TemplateRenderer is a html/template based Renderer that loads templates from a
+// http.FileSystem, templates are named by their path, such as "posts/index.html".
+// Compiled templates are cached, and reloaded on file change if reloading is enabled.
+//
+// The following template functions depend on request:
+//
+//	url      generates URL by route name and arguments, see Context.RouteURL.
+//	asset    returns the fingerprinted URL of asset, see Context.AssetURL.
+//	csrf     returns the CSRF token, see TemplateCSRFToken.
+//	flashes  returns and removes the flash messages, see Context.Flashes.
+//	cspNonce returns the nonce of content security policy, see Context.CSPNonce.
+//	flush    flushes the written output in streaming mode, see Context.RenderStream.
+type TemplateRenderer struct {
+	fs          http.FileSystem
+	layout      string
+	pageLayouts map[string]string
+	partials    []string
+	funcs       template.FuncMap
+	leftDelim   string
+	rightDelim  string
+	csrfToken   func(c *Context) string
+	reload      bool
+
+	mu   sync.RWMutex
+	sets map[string]*templateSet
+}
+
+// templateSet is a compiled page along with its layout and partials, the template
+// is never executed, so that it can be cloned. The clones are pooled, so that a
+// template is cloned only if all of the clones are in use.
+type templateSet struct {
+	tmpl    *template.Template
+	entry   string
+	modTime map[string]time.Time
+	pool    sync.Pool
+}
+
+// templateInstance is a clone of templateSet whose request dependent functions
+// are bound to the state, which is set before executing.
+type templateInstance struct {
+	tmpl  *template.Template
+	state *renderState
+}
+
+type renderState struct {
+	w io.Writer
+	c *Context
+}
+
+// NewTemplateRenderer returns a template renderer that loads templates from the given
+// file system.
+func NewTemplateRenderer(fs http.FileSystem, opts ...TemplateOption) *TemplateRenderer {
+	r := &TemplateRenderer{
+		fs:          fs,
+		pageLayouts: make(map[string]string),
+		funcs:       make(template.FuncMap),
+		sets:        make(map[string]*templateSet),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Render implements Renderer.Render.
+func (r *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c *Context) error {
+	set, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	inst, err := r.instance(set)
+	if err != nil {
+		return err
+	}
+	inst.state.w, inst.state.c = w, c
+	err = inst.tmpl.ExecuteTemplate(w, set.entry, data)
+	inst.state.w, inst.state.c = nil, nil
+	set.pool.Put(inst)
+	return err
+}
+
+func (r *TemplateRenderer) instance(set *templateSet) (*templateInstance, error) {
+	if inst, ok := set.pool.Get().(*templateInstance); ok {
+		return inst, nil
+	}
+	tmpl, err := set.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	state := &renderState{}
+	return &templateInstance{tmpl: tmpl.Funcs(r.contextFuncs(state)), state: state}, nil
+}
+
+func (r *TemplateRenderer) lookup(name string) (*templateSet, error) {
+	r.mu.RLock()
+	set, ok := r.sets[name]
+	r.mu.RUnlock()
+	if ok && (!r.reload || !r.isModified(set)) {
+		return set, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if set, ok = r.sets[name]; ok && (!r.reload || !r.isModified(set)) {
+		return set, nil
+	}
+	set, err := r.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	r.sets[name] = set
+	return set, nil
+}
+
+func (r *TemplateRenderer) isModified(set *templateSet) bool {
+	for name, modTime := range set.modTime {
+		info, err := r.stat(name)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TemplateRenderer) parse(name string) (*templateSet, error) {
+	set := &templateSet{
+		entry:   name,
+		modTime: make(map[string]time.Time),
+	}
+	layout, ok := r.pageLayouts[name]
+	if !ok {
+		layout = r.layout
+	}
+	files := make([]string, 0, 2)
+	if layout != "" {
+		set.entry = layout
+		files = append(files, layout)
+	}
+	for _, dir := range r.partials {
+		partials, err := r.readDir(dir, set.modTime)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, partials...)
+	}
+	files = append(files, name)
+
+	set.tmpl = template.New(set.entry).Delims(r.leftDelim, r.rightDelim).Funcs(r.placeholderFuncs()).Funcs(r.funcs)
+	for _, file := range files {
+		content, modTime, err := r.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		set.modTime[file] = modTime
+		tmpl := set.tmpl
+		if file != set.entry {
+			tmpl = tmpl.New(file)
+		}
+		if _, err = tmpl.Parse(content); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func (r *TemplateRenderer) stat(name string) (os.FileInfo, error) {
+	f, err := r.fs.Open("/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (r *TemplateRenderer) readFile(name string) (string, time.Time, error) {
+	f, err := r.fs.Open("/" + name)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return string(content), info.ModTime(), nil
+}
+
+// readDir returns the files of the directory recursively, and records the modification
+// time of directories, so that the new files can be detected on reloading.
+func (r *TemplateRenderer) readDir(dir string, modTime map[string]time.Time) ([]string, error) {
+	f, err := r.fs.Open("/" + dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	modTime[dir] = info.ModTime()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	var files []string
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		if !info.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		subFiles, err := r.readDir(name, modTime)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, subFiles...)
+	}
+	return files, nil
+}
+
+// placeholderFuncs returns the request dependent functions that are used for parsing,
+// they will be replaced on rendering.
+func (r *TemplateRenderer) placeholderFuncs() template.FuncMap {
+	return r.contextFuncs(&renderState{})
+}
+
+func (r *TemplateRenderer) contextFuncs(state *renderState) template.FuncMap {
+	return template.FuncMap{
+		"flush": func() string {
+			if f, ok := state.w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return ""
+		},
+		"url": func(name string, args ...string) (string, error) {
+			u, err := state.c.RouteURL(name, args...)
+			if err != nil {
+				return "", err
+			}
+			return u.String(), nil
+		},
+		"asset": func(name string) string {
+			return state.c.AssetURL(name)
+		},
+		"csrf": func() (string, error) {
+			if r.csrfToken == nil {
+				return "", errCSRFTokenNotSet
+			}
+			return r.csrfToken(state.c), nil
+		},
+		"flashes": func() []Flash {
+			return state.c.Flashes()
+		},
+		"cspNonce": func() string {
+			return state.c.CSPNonce()
+		},
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package clevergo
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+// NewTemplateRendererFS returns a template renderer that loads templates from the
+// given fs.FS, such as embed.FS.
+func NewTemplateRendererFS(fsys fs.FS, opts ...TemplateOption) *TemplateRenderer {
+	return NewTemplateRenderer(http.FS(fsys), opts...)
+}
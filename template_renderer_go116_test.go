@@ -0,0 +1,28 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package clevergo
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTemplateRendererFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/main.html": {Data: []byte(`<main>{{block "content" .}}{{end}}</main>`)},
+		"partials/nav.html": {Data: []byte(`<nav></nav>`)},
+		"index.html":        {Data: []byte(`{{define "content"}}{{template "partials/nav.html"}}{{.}}{{end}}`)},
+	}
+	r := NewTemplateRendererFS(fsys, TemplateLayout("layouts/main.html"), TemplatePartials("partials"))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, r.Render(buf, "index.html", "foo", nil))
+	assert.Equal(t, "<main><nav></nav>foo</main>", buf.String())
+}
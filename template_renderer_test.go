@@ -0,0 +1,224 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTemplateFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	}
+}
+
+func newTemplateTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	writeTemplateFiles(t, dir, map[string]string{
+		"layouts/main.html":      `<main>{{template "partials/nav.html" .}}{{block "content" .}}default{{end}}</main>`,
+		"layouts/admin.html":     `<admin>{{block "content" .}}{{end}}</admin>`,
+		"partials/nav.html":      `<nav>{{.}}</nav>`,
+		"partials/sub/foot.html": `{{define "footer"}}<footer>{{upper .}}</footer>{{end}}`,
+		"index.html":             `{{define "content"}}<p>{{.}}</p>{{template "footer" .}}{{end}}`,
+		"empty.html":             ``,
+		"standalone.html":        `<p>{{.}}</p>`,
+		"funcs.html":             `{{url "post" "id" .}}|{{csrf}}|{{cspNonce}}|{{range flashes}}{{.Message}}{{end}}`,
+		"invalid.html":           `{{.`,
+		"undefined.html":         `{{undefined}}`,
+	})
+	return dir
+}
+
+func TestTemplateRenderer(t *testing.T) {
+	dir := newTemplateTestDir(t)
+	defer os.RemoveAll(dir)
+
+	r := NewTemplateRenderer(http.Dir(dir),
+		TemplateLayout("layouts/main.html"),
+		TemplatePartials("partials"),
+		TemplatePageLayout("standalone.html", ""),
+		TemplatePageLayout("funcs.html", ""),
+		TemplatePageLayout("admin.html", "layouts/admin.html"),
+		TemplateFuncs(template.FuncMap{"upper": strings.ToUpper}),
+	)
+	cases := []struct {
+		name   string
+		output string
+		err    bool
+	}{
+		{"index.html", "<main><nav>foo</nav><p>foo</p><footer>FOO</footer></main>", false},
+		{"empty.html", "<main><nav>foo</nav>default</main>", false},
+		{"standalone.html", "<p>foo</p>", false},
+		{"missing.html", "", true},
+		{"invalid.html", "", true},
+		{"undefined.html", "", true},
+	}
+	for _, test := range cases {
+		buf := &bytes.Buffer{}
+		err := r.Render(buf, test.name, "foo", nil)
+		assert.Equal(t, test.err, err != nil, test.name)
+		if !test.err {
+			assert.Equal(t, test.output, buf.String())
+		}
+	}
+
+	writeTemplateFiles(t, dir, map[string]string{"admin.html": `{{define "content"}}{{.}}{{end}}`})
+	buf := &bytes.Buffer{}
+	assert.Nil(t, r.Render(buf, "admin.html", "foo", nil))
+	assert.Equal(t, "<admin>foo</admin>", buf.String())
+
+	// invalid partials directory.
+	r = NewTemplateRenderer(http.Dir(dir), TemplatePartials("missing"))
+	assert.NotNil(t, r.Render(buf, "index.html", "foo", nil))
+}
+
+func TestTemplateRendererDelims(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeTemplateFiles(t, dir, map[string]string{"index.html": `[[.]]{{.}}`})
+
+	r := NewTemplateRenderer(http.Dir(dir), TemplateDelims("[[", "]]"))
+	buf := &bytes.Buffer{}
+	assert.Nil(t, r.Render(buf, "index.html", "foo", nil))
+	assert.Equal(t, "foo{{.}}", buf.String())
+}
+
+func TestTemplateRendererContextFuncs(t *testing.T) {
+	dir := newTemplateTestDir(t)
+	defer os.RemoveAll(dir)
+
+	app := New()
+	app.Renderer = NewTemplateRenderer(http.Dir(dir), TemplateCSRFToken(func(c *Context) string {
+		return "token"
+	}))
+	app.Use(Secure(SecureCSP(ContentSecurityPolicy{
+		Directives: map[string][]string{"script-src": {"'self'"}},
+		Nonce:      true,
+	})))
+	app.Use(Sessions(NewMemorySessionStore()))
+	app.Get("/posts/:id", func(c *Context) error {
+		if err := c.AddFlash("success", "saved"); err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "funcs.html", c.Params.String("id"))
+	}, RouteName("post"))
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/posts/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	parts := strings.Split(w.Body.String(), "|")
+	assert.Len(t, parts, 4)
+	assert.Equal(t, "/posts/1", parts[0])
+	assert.Equal(t, "token", parts[1])
+	nonce := html.UnescapeString(parts[2])
+	assert.NotEmpty(t, nonce)
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "'nonce-"+nonce+"'")
+	assert.Equal(t, "saved", parts[3])
+
+	// undefined route.
+	r := NewTemplateRenderer(http.Dir(dir))
+	c := newContext(nil, httptest.NewRequest(http.MethodGet, "/", nil))
+	c.app = Pure()
+	assert.NotNil(t, r.Render(&bytes.Buffer{}, "funcs.html", "1", c))
+
+	// CSRF token function is not set.
+	c.app = app
+	err := r.Render(&bytes.Buffer{}, "funcs.html", "1", c)
+	assert.Contains(t, err.Error(), errCSRFTokenNotSet.Error())
+}
+
+func TestTemplateRendererConcurrency(t *testing.T) {
+	dir := newTemplateTestDir(t)
+	defer os.RemoveAll(dir)
+
+	app := Pure()
+	app.Renderer = NewTemplateRenderer(http.Dir(dir), TemplateCSRFToken(func(c *Context) string {
+		return c.Params.String("id")
+	}))
+	app.Get("/posts/:id", func(c *Context) error {
+		return c.Render(http.StatusOK, "funcs.html", c.Params.String("id"))
+	}, RouteName("post"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/posts/"+id, nil))
+			assert.Equal(t, "/posts/"+id+"|"+id+"||", w.Body.String())
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+}
+
+func TestTemplateRendererReload(t *testing.T) {
+	for _, reload := range []bool{false, true} {
+		dir := newTemplateTestDir(t)
+		r := NewTemplateRenderer(http.Dir(dir), TemplateReload(reload), TemplatePartials("partials"),
+			TemplateFuncs(template.FuncMap{"upper": strings.ToUpper}))
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, r.Render(buf, "standalone.html", "foo", nil))
+		assert.Equal(t, "<p>foo</p>", buf.String())
+
+		filename := filepath.Join(dir, "standalone.html")
+		assert.Nil(t, ioutil.WriteFile(filename, []byte(`<div>{{.}}</div>{{template "partials/new.html"}}`), 0644))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "partials", "new.html"), []byte(`new`), 0644))
+		future := time.Now().Add(time.Minute)
+		assert.Nil(t, os.Chtimes(filename, future, future))
+		assert.Nil(t, os.Chtimes(filepath.Join(dir, "partials"), future, future))
+
+		buf.Reset()
+		assert.Nil(t, r.Render(buf, "standalone.html", "foo", nil))
+		if reload {
+			assert.Equal(t, "<div>foo</div>new", buf.String())
+		} else {
+			assert.Equal(t, "<p>foo</p>", buf.String())
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTemplateRendererFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	writeTemplateFiles(t, dir, map[string]string{"index.html": `<head></head>{{flush}}<body>{{.}}</body>`})
+
+	app := Pure()
+	app.Renderer = NewTemplateRenderer(http.Dir(dir))
+	app.Get("/", func(c *Context) error {
+		return c.RenderStream(http.StatusOK, "index.html", "foo")
+	})
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "<head></head><body>foo</body>", w.Body.String())
+
+	// buffered rendering.
+	buf := &bytes.Buffer{}
+	assert.Nil(t, app.Renderer.Render(buf, "index.html", "foo", nil))
+	assert.Equal(t, "<head></head><body>foo</body>", buf.String())
+}
@@ -0,0 +1,420 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerTraceParent = "traceparent"
+	headerTraceState  = "tracestate"
+
+	maxTraceStateMembers = 32
+)
+
+var (
+	errInvalidTraceParent = errors.New("invalid traceparent")
+
+	traceStateMemberRegexp = regexp.MustCompile(`^(?:[a-z0-9][_0-9a-z\-*/]{0,255}|[a-z0-9][_0-9a-z\-*/]{0,240}@[a-z][_0-9a-z\-*/]{0,13})=[\x20-\x2b\x2d-\x3c\x3e-\x7e]{0,255}[\x21-\x2b\x2d-\x3c\x3e-\x7e]$`)
+)
+
+// TraceID is a 16 bytes trace identifier.
+type TraceID [16]byte
+
+// IsValid indicates whether the trace ID is valid, i.e. not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the lowercase hex encoding of the trace ID.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is a 8 bytes span identifier.
+type SpanID [8]byte
+
+// IsValid indicates whether the span ID is valid, i.e. not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// String returns the lowercase hex encoding of the span ID.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// TraceFlags is the trace flags.
+type TraceFlags byte
+
+// TraceFlagsSampled indicates that the caller may have recorded trace data.
+const TraceFlagsSampled TraceFlags = 0x01
+
+// IsSampled indicates whether the sampled flag is set.
+func (f TraceFlags) IsSampled() bool {
+	return f&TraceFlagsSampled == TraceFlagsSampled
+}
+
+// TraceContext is the W3C trace context, see https://www.w3.org/TR/trace-context/.
+type TraceContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   TraceFlags
+	// State is the vendor-specific trace state, the value of tracestate header.
+	State string
+}
+
+// IsValid indicates whether both of trace ID and span ID are valid.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID.IsValid() && tc.SpanID.IsValid()
+}
+
+// TraceParent returns the value of traceparent header.
+func (tc TraceContext) TraceParent() string {
+	return "00-" + tc.TraceID.String() + "-" + tc.SpanID.String() + "-" + hex.EncodeToString([]byte{byte(tc.Flags)})
+}
+
+// NewTraceContext returns a sampled trace context with random trace ID and span ID.
+func NewTraceContext() TraceContext {
+	return TraceContext{
+		TraceID: newTraceID(),
+		SpanID:  newSpanID(),
+		Flags:   TraceFlagsSampled,
+	}
+}
+
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
+
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return
+}
+
+// ParseTraceParent parses the value of traceparent header.
+func ParseTraceParent(s string) (tc TraceContext, err error) {
+	s = strings.TrimSpace(s)
+	// version-format: 2 + 1 + 32 + 1 + 16 + 1 + 2 = 55.
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, errInvalidTraceParent
+	}
+	version, err := decodeLowerHex(s[:2])
+	if err != nil || version[0] == 0xff {
+		return tc, errInvalidTraceParent
+	}
+	// future versions may append fields that separated by dash.
+	if (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return tc, errInvalidTraceParent
+	}
+
+	traceID, err := decodeLowerHex(s[3:35])
+	if err != nil {
+		return tc, errInvalidTraceParent
+	}
+	spanID, err := decodeLowerHex(s[36:52])
+	if err != nil {
+		return tc, errInvalidTraceParent
+	}
+	flags, err := decodeLowerHex(s[53:55])
+	if err != nil {
+		return tc, errInvalidTraceParent
+	}
+	copy(tc.TraceID[:], traceID)
+	copy(tc.SpanID[:], spanID)
+	tc.Flags = TraceFlags(flags[0])
+	if !tc.IsValid() {
+		return TraceContext{}, errInvalidTraceParent
+	}
+	return tc, nil
+}
+
+func decodeLowerHex(s string) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, errInvalidTraceParent
+	}
+	return hex.DecodeString(s)
+}
+
+// parseTraceState validates and normalizes tracestate header values, returns
+// an empty string if the trace state is invalid.
+func parseTraceState(values []string) string {
+	var members []string
+	for _, value := range values {
+		for _, member := range strings.Split(value, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			if !traceStateMemberRegexp.MatchString(member) {
+				return ""
+			}
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxTraceStateMembers {
+		return ""
+	}
+	return strings.Join(members, ",")
+}
+
+// ExtractTraceContext extracts trace context from the given header.
+func ExtractTraceContext(header http.Header) (TraceContext, bool) {
+	tc, err := ParseTraceParent(header.Get(headerTraceParent))
+	if err != nil {
+		return tc, false
+	}
+	tc.State = parseTraceState(header[http.CanonicalHeaderKey(headerTraceState)])
+	return tc, true
+}
+
+// InjectTraceContext injects the trace context into the given header,
+// it is useful for propagating trace context to outgoing requests.
+func InjectTraceContext(header http.Header, tc TraceContext) {
+	header.Set(headerTraceParent, tc.TraceParent())
+	if tc.State != "" {
+		header.Set(headerTraceState, tc.State)
+	} else {
+		header.Del(headerTraceState)
+	}
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx that carries the trace context.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context stored in ctx.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// SpanContext is the context of a span.
+type SpanContext struct {
+	TraceContext
+
+	// ParentSpanID is the span ID of the remote parent, it is invalid if the span
+	// is a root span.
+	ParentSpanID SpanID
+}
+
+// Span is a single operation within a trace.
+type Span interface {
+	// SetName sets the name of span.
+	SetName(name string)
+
+	// SetAttribute sets an attribute.
+	SetAttribute(key string, value interface{})
+
+	// End completes the span with an optional error.
+	End(err error)
+}
+
+// SpanStarter starts spans, it is the extension point for plugging tracing
+// exporters, such as OpenTelemetry.
+type SpanStarter interface {
+	// StartSpan starts a span with the given name and span context, the returned
+	// context will be used as the request's context.
+	StartSpan(ctx context.Context, name string, sc SpanContext) (context.Context, Span)
+}
+
+// TracingOption is a function that receives a tracing instance.
+type TracingOption func(*tracing)
+
+// TracingSpanStarter is an option that sets span starter.
+func TracingSpanStarter(starter SpanStarter) TracingOption {
+	return func(t *tracing) {
+		t.starter = starter
+	}
+}
+
+// TracingSampler is an option that sets a function to determine whether
+// to sample new traces, all new traces are sampled by default.
+// Traces that propagated from upstream always respect the sampled flag.
+func TracingSampler(sampler func(c *Context) bool) TracingOption {
+	return func(t *tracing) {
+		t.sampler = sampler
+	}
+}
+
+// TracingSkipper is an option that sets skipper.
+func TracingSkipper(skipper Skipper) TracingOption {
+	return func(t *tracing) {
+		t.skipper = skipper
+	}
+}
+
+// Tracing returns a middleware that extracts the trace context from the traceparent
+// and tracestate headers, or creates a new one if absent or invalid. It starts a
+// server span as a child of the extracted trace context, and stores the span's
+// trace context in the request's context, see TraceContextFromContext.
+//
+// Spans are initially named after the request method, and then renamed after the
+// matched route pattern once the request was handled, such as "GET /users/{name}".
+func Tracing(opts ...TracingOption) MiddlewareFunc {
+	t := &tracing{}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t.middleware
+}
+
+type tracing struct {
+	starter SpanStarter
+	sampler func(c *Context) bool
+	skipper Skipper
+}
+
+func (t *tracing) middleware(next Handle) Handle {
+	return func(c *Context) error {
+		if t.skipper != nil && t.skipper(c) {
+			return next(c)
+		}
+
+		sc := SpanContext{}
+		if parent, ok := ExtractTraceContext(c.Request.Header); ok {
+			sc.TraceContext = parent
+			sc.ParentSpanID = parent.SpanID
+			sc.SpanID = newSpanID()
+		} else {
+			sc.TraceContext = NewTraceContext()
+			if t.sampler != nil && !t.sampler(c) {
+				sc.Flags &^= TraceFlagsSampled
+			}
+		}
+
+		ctx := ContextWithTraceContext(c.Request.Context(), sc.TraceContext)
+		if t.starter == nil {
+			c.Request = c.Request.WithContext(ctx)
+			return next(c)
+		}
+
+		ctx, span := t.starter.StartSpan(ctx, c.Request.Method, sc)
+		c.Request = c.Request.WithContext(ctx)
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.target", c.Request.RequestURI)
+		span.SetAttribute("http.client_ip", c.RealIP())
+		resp := newResponseWriter(c.Response)
+		defer func(w http.ResponseWriter) {
+			c.Response = w
+		}(c.Response)
+		c.Response = resp
+
+		err := next(c)
+		if c.Route != nil {
+			span.SetName(c.Request.Method + " " + c.Route.pattern)
+			span.SetAttribute("http.route", c.Route.pattern)
+		}
+		span.SetAttribute("http.status_code", resp.status(err))
+		span.End(err)
+		return err
+	}
+}
+
+// RecordedSpan is a span recorded by SpanRecorder.
+type RecordedSpan struct {
+	Name        string
+	SpanContext SpanContext
+	Attributes  map[string]interface{}
+	Err         error
+	StartTime   time.Time
+	EndTime     time.Time
+	Ended       bool
+}
+
+// SpanRecorder is an in-memory SpanStarter that records spans, it is
+// intended for testing.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*recorderSpan
+}
+
+// NewSpanRecorder returns a span recorder.
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+// StartSpan implements SpanStarter.StartSpan.
+func (r *SpanRecorder) StartSpan(ctx context.Context, name string, sc SpanContext) (context.Context, Span) {
+	span := &recorderSpan{
+		recorder: r,
+		span: RecordedSpan{
+			Name:        name,
+			SpanContext: sc,
+			Attributes:  make(map[string]interface{}),
+			StartTime:   time.Now(),
+		},
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+	return ctx, span
+}
+
+// Spans returns a snapshot of recorded spans in the order they were started.
+func (r *SpanRecorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make([]RecordedSpan, len(r.spans))
+	for i, span := range r.spans {
+		spans[i] = span.span
+		spans[i].Attributes = make(map[string]interface{}, len(span.span.Attributes))
+		for key, value := range span.span.Attributes {
+			spans[i].Attributes[key] = value
+		}
+	}
+	return spans
+}
+
+// Reset removes all recorded spans.
+func (r *SpanRecorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+type recorderSpan struct {
+	recorder *SpanRecorder
+	span     RecordedSpan
+}
+
+func (s *recorderSpan) SetName(name string) {
+	s.recorder.mu.Lock()
+	s.span.Name = name
+	s.recorder.mu.Unlock()
+}
+
+func (s *recorderSpan) SetAttribute(key string, value interface{}) {
+	s.recorder.mu.Lock()
+	s.span.Attributes[key] = value
+	s.recorder.mu.Unlock()
+}
+
+func (s *recorderSpan) End(err error) {
+	s.recorder.mu.Lock()
+	if !s.span.Ended {
+		s.span.Ended = true
+		s.span.Err = err
+		s.span.EndTime = time.Now()
+	}
+	s.recorder.mu.Unlock()
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 CleverGo. All rights reserved.
+// Use of this source code is governed by a MIT style license that can be found
+// in the LICENSE file.
+
+package clevergo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	tc, err := ParseTraceParent(testTraceParent)
+	assert.Nil(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", tc.SpanID.String())
+	assert.True(t, tc.Flags.IsSampled())
+	assert.Equal(t, testTraceParent, tc.TraceParent())
+
+	tc, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.Nil(t, err)
+	assert.False(t, tc.Flags.IsSampled())
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"0g-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0z",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	for _, s := range invalid {
+		_, err = ParseTraceParent(s)
+		assert.Equal(t, errInvalidTraceParent, err, s)
+	}
+}
+
+func TestNewTraceContext(t *testing.T) {
+	tc := NewTraceContext()
+	assert.True(t, tc.IsValid())
+	assert.True(t, tc.Flags.IsSampled())
+	assert.NotEqual(t, tc, NewTraceContext())
+}
+
+func TestParseTraceState(t *testing.T) {
+	assert.Equal(t, "", parseTraceState(nil))
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,foo@bar=baz", parseTraceState([]string{
+		"rojo=00f067aa0ba902b7, congo=t61rcWkgMzE",
+		"foo@bar=baz,",
+	}))
+	assert.Equal(t, "", parseTraceState([]string{"rojo=00f067aa0ba902b7", "Invalid=1"}))
+
+	members := make([]string, maxTraceStateMembers+1)
+	for i := range members {
+		members[i] = "k" + string(rune('a'+i%26)) + string(rune('a'+i/26)) + "=v"
+	}
+	assert.Equal(t, "", parseTraceState([]string{strings.Join(members, ",")}))
+	assert.NotEqual(t, "", parseTraceState([]string{strings.Join(members[1:], ",")}))
+}
+
+func TestExtractInjectTraceContext(t *testing.T) {
+	header := http.Header{}
+	_, ok := ExtractTraceContext(header)
+	assert.False(t, ok)
+
+	header.Set("traceparent", testTraceParent)
+	header.Set("tracestate", "foo=bar")
+	tc, ok := ExtractTraceContext(header)
+	assert.True(t, ok)
+	assert.Equal(t, "foo=bar", tc.State)
+
+	outgoing := http.Header{}
+	outgoing.Set("tracestate", "stale=1")
+	tc.State = ""
+	InjectTraceContext(outgoing, tc)
+	assert.Equal(t, testTraceParent, outgoing.Get("traceparent"))
+	assert.Equal(t, "", outgoing.Get("tracestate"))
+
+	tc.State = "foo=bar"
+	InjectTraceContext(outgoing, tc)
+	assert.Equal(t, "foo=bar", outgoing.Get("tracestate"))
+}
+
+func TestTraceContextFromContext(t *testing.T) {
+	_, ok := TraceContextFromContext(context.Background())
+	assert.False(t, ok)
+
+	tc := NewTraceContext()
+	actual, ok := TraceContextFromContext(ContextWithTraceContext(context.Background(), tc))
+	assert.True(t, ok)
+	assert.Equal(t, tc, actual)
+}
+
+func TestTracing(t *testing.T) {
+	recorder := NewSpanRecorder()
+	app := Pure()
+	app.Use(Tracing(TracingSpanStarter(recorder), TracingSkipper(PathSkipper("/health"))))
+	var current TraceContext
+	app.Get("/users/:name", func(c *Context) error {
+		current, _ = TraceContextFromContext(c.Context())
+		return c.String(http.StatusCreated, "created")
+	})
+	app.Get("/error", func(c *Context) error {
+		return ErrNotFound
+	})
+	app.Get("/health", func(c *Context) error {
+		_, ok := TraceContextFromContext(c.Context())
+		assert.False(t, ok)
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/foo", nil)
+	req.Header.Set("traceparent", testTraceParent)
+	req.Header.Set("tracestate", "foo=bar")
+	app.ServeHTTP(httptest.NewRecorder(), req)
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/error", nil))
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	spans := recorder.Spans()
+	assert.Len(t, spans, 2)
+
+	span := spans[0]
+	assert.Equal(t, "GET /users/{name}", span.Name)
+	assert.True(t, span.Ended)
+	assert.Nil(t, span.Err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.SpanContext.ParentSpanID.String())
+	assert.NotEqual(t, span.SpanContext.ParentSpanID, span.SpanContext.SpanID)
+	assert.Equal(t, "foo=bar", span.SpanContext.State)
+	assert.Equal(t, span.SpanContext.TraceContext, current)
+	assert.Equal(t, http.StatusCreated, span.Attributes["http.status_code"])
+	assert.Equal(t, "/users/{name}", span.Attributes["http.route"])
+	assert.Equal(t, http.MethodGet, span.Attributes["http.method"])
+
+	span = spans[1]
+	assert.Equal(t, "GET /error", span.Name)
+	assert.Equal(t, ErrNotFound, span.Err)
+	assert.False(t, span.SpanContext.ParentSpanID.IsValid())
+	assert.True(t, span.SpanContext.Flags.IsSampled())
+	assert.Equal(t, http.StatusNotFound, span.Attributes["http.status_code"])
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Spans())
+}
+
+func TestTracingSampler(t *testing.T) {
+	recorder := NewSpanRecorder()
+	m := Tracing(TracingSpanStarter(recorder), TracingSampler(func(c *Context) bool {
+		return false
+	}))
+	c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	c.app = Pure()
+	err := errors.New("foo")
+	assert.Equal(t, err, m(func(c *Context) error {
+		return err
+	})(c))
+	spans := recorder.Spans()
+	assert.Len(t, spans, 1)
+	assert.False(t, spans[0].SpanContext.Flags.IsSampled())
+	assert.Equal(t, "GET", spans[0].Name)
+	assert.Equal(t, http.StatusInternalServerError, spans[0].Attributes["http.status_code"])
+}
+
+func TestTracingWithoutSpanStarter(t *testing.T) {
+	m := Tracing()
+	c := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	handled := false
+	m(func(c *Context) error {
+		handled = true
+		tc, ok := TraceContextFromContext(c.Context())
+		assert.True(t, ok)
+		assert.True(t, tc.IsValid())
+		return nil
+	})(c)
+	assert.True(t, handled)
+}